@@ -11,8 +11,10 @@ require (
 	github.com/mitchellh/copystructure v1.0.0 // indirect
 	github.com/niemeyer/pretty v0.0.0-20200227124842-a10e7caefd8e // indirect
 	github.com/panjf2000/ants/v2 v2.4.3
+	github.com/prometheus/client_golang v1.7.1
 	github.com/tidwall/gjson v1.6.0
 	gopkg.in/check.v1 v1.0.0-20200227125254-8fa46927fb4f // indirect
+	k8s.io/api v0.19.2
 	k8s.io/apimachinery v0.19.2
 	k8s.io/client-go v0.19.2
 	k8s.io/klog/v2 v2.4.0
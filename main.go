@@ -38,8 +38,11 @@ import (
 var (
 	scheme = runtime.NewScheme()
 
-	enableLeaderElection          bool
-	nettpl, vmtpl, fiptpl, tmpdir string
+	enableLeaderElection, enableWebhook, enableService bool
+	nettpl, vmtpl, fiptpl, tmpdir                      string
+	certdir, metricsaddr, backend                      string
+	lbapi                                              string
+	webhookport                                        int
 )
 
 func init() {
@@ -62,6 +65,15 @@ func main() {
 	flag.StringVar(&vmtpl, "vm-tpl", "/opt/vm.tpl", "vm tpl file path")
 	flag.StringVar(&fiptpl, "fip-tpl", "/opt/fip.tpl", "floatip tpl file path")
 	flag.StringVar(&tmpdir, "tmp-dir", "/tmp", "must have write permission on this dir")
+	flag.StringVar(&backend, "backend", controllers.HeatBackend, "default backend which provision openstack resources, heat or direct")
+	flag.StringVar(&lbapi, "lb-api", manage.LbApiAuto, "api of load balance, neutron, octavia or auto which detect octavia from service catalog")
+
+	flag.BoolVar(&enableWebhook, "enable-webhook", false, "Enabling defaulting and validating webhook for virtual machine")
+	flag.BoolVar(&enableService, "enable-service-controller", false, "Enabling provision load balance for LoadBalancer service of class "+manage.LoadBalancerClass)
+	flag.IntVar(&webhookport, "webhook-port", 9443, "webhook server listen port")
+	flag.StringVar(&certdir, "webhook-cert-dir", "/tmp/k8s-webhook-server/serving-certs", "directory which contains tls.crt and tls.key")
+
+	flag.StringVar(&metricsaddr, "metrics-addr", ":8080", "the address the metric endpoint binds to, 0 means disable")
 
 	optime := flag.Duration("openstack-sync-period", time.Second*30, "sync time which openstack fetch resource")
 	k8time := flag.Duration("k8s-sync-period", time.Second*30, "sync time which k8s sync external service")
@@ -76,9 +88,11 @@ func main() {
 	opt := ctrl.Options{
 		SyncPeriod:         syncdu,
 		Scheme:             scheme,
-		MetricsBindAddress: "0",
+		MetricsBindAddress: metricsaddr,
 		LeaderElection:     enableLeaderElection,
 		LeaderElectionID:   leaderid,
+		Port:               webhookport,
+		CertDir:            certdir,
 	}
 
 	mgr, err := ctrl.NewManager(config, opt)
@@ -92,16 +106,23 @@ func main() {
 		klog.Errorf("create dynamic client failed:%v ", err)
 		os.Exit(1)
 	}
-	k8smgr := manage.NewK8sMgr(client)
+	recorder := mgr.GetEventRecorderFor("vm-controller")
+	k8smgr := manage.NewK8sMgr(client, recorder)
 
 	tempengine := template.NewTemplate()
 	tempengine.AddTempFileMust(template.Fip, fiptpl)
 	tempengine.AddTempFileMust(template.Lb, nettpl)
 	tempengine.AddTempFileMust(template.Vm, vmtpl)
 
-	server := controllers.NewServer(tempengine, tmpdir, k8smgr, enableLeaderElection, *k8time, *optime)
+	server := controllers.NewServer(tempengine, tmpdir, backend, lbapi, k8smgr, recorder, enableLeaderElection, *k8time, *optime)
 
 	controllers.NewVirtualMachine(mgr, server)
+	if enableService {
+		controllers.NewService(mgr, mgr.GetEventRecorderFor("service-controller"))
+	}
+	if enableWebhook {
+		controllers.NewWebhook(mgr)
+	}
 
 	klog.Infof("manager start")
 	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
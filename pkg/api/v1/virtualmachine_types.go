@@ -31,6 +31,33 @@ const (
 	Recreate AssemblyPhaseType = "Recreate"
 )
 
+const (
+	// MemberModeAll use all pods of link as pool members
+	MemberModeAll = "All"
+	// MemberModeReady only use Ready pods, which are not terminating
+	MemberModeReady = "Ready"
+)
+
+const (
+	// ServiceTypeExternalIP sync ClusterIP service with lb ip as externalIPs
+	ServiceTypeExternalIP = "ExternalIP"
+	// ServiceTypeLoadBalancer sync LoadBalancer service, which ingress
+	// status is lb ip and floating ip
+	ServiceTypeLoadBalancer = "LoadBalancer"
+)
+
+const (
+	// IpSourceNetwork find ips of network in multus networks-status annotation
+	IpSourceNetwork = "Network"
+	// IpSourcePodIPs use status.podIPs of pod
+	IpSourcePodIPs = "PodIPs"
+	// IpSourceAnnotation find ips by path in custom annotation
+	IpSourceAnnotation = "Annotation"
+	// IpSourceEndpointSlices use addresses in endpoint slices of service,
+	// which is only used by service link
+	IpSourceEndpointSlices = "EndpointSlices"
+)
+
 // VirtualMachineSpec defines the desired state of VirtualMachine
 type VirtualMachineSpec struct {
 	Auth          *AuthSpec         `json:"auth"`
@@ -41,8 +68,17 @@ type VirtualMachineSpec struct {
 }
 
 type AuthSpec struct {
-	ProjectID string `json:"projectID"`
-	Token     string `json:"token"`
+	ProjectID string `json:"projectID,omitempty"`
+	Token     string `json:"token,omitempty"`
+
+	// SecretRef the secret in the same namespace, which hold token,
+	// application credential or username and password.
+	// The keys are same with openrc, such as OS_TOKEN, OS_PROJECT_ID.
+	SecretRef *SecretRef `json:"secretRef,omitempty"`
+}
+
+type SecretRef struct {
+	Name string `json:"name"`
 }
 
 type PortMap struct {
@@ -50,6 +86,73 @@ type PortMap struct {
 	Port     int32    `json:"port"`
 	PodPort  int32    `json:"pod_port,omitempty"` //default same with port
 	Protocol string   `json:"protocol"`
+
+	// HealthCheck create health monitor on the pool of listener
+	HealthCheck *HealthCheck `json:"health_check,omitempty"`
+
+	// Algorithm of pool, one of ROUND_ROBIN, LEAST_CONNECTIONS and SOURCE_IP,
+	// default ROUND_ROBIN
+	Algorithm          string              `json:"algorithm,omitempty"`
+	SessionPersistence *SessionPersistence `json:"session_persistence,omitempty"`
+	// ConnectionLimit of listener, default -1 means unlimited
+	ConnectionLimit int32 `json:"connection_limit,omitempty"`
+	// TimeoutClientData and TimeoutMemberData are milliseconds of
+	// inactivity on listener, which are only supported by octavia
+	TimeoutClientData int32 `json:"timeout_client_data,omitempty"`
+	TimeoutMemberData int32 `json:"timeout_member_data,omitempty"`
+
+	// TlsSecret is kubernetes.io/tls secret in the same namespace, which is
+	// the default certificate of TERMINATED_HTTPS listener
+	TlsSecret string `json:"tls_secret,omitempty"`
+	// SniSecrets are kubernetes.io/tls secrets used by SNI
+	SniSecrets []string `json:"sni_secrets,omitempty"`
+	// TlsContainerRef and SniContainerRefs are references of certificates
+	// in key store, which are generated by operator and only rendered
+	TlsContainerRef  string   `json:"-"`
+	SniContainerRefs []string `json:"-"`
+}
+
+type SessionPersistence struct {
+	// Type is one of SOURCE_IP, HTTP_COOKIE and APP_COOKIE
+	Type string `json:"type"`
+	// CookieName is required by APP_COOKIE
+	CookieName string `json:"cookie_name,omitempty"`
+}
+
+type HealthCheck struct {
+	// Type is one of TCP, HTTP, HTTPS and PING
+	Type string `json:"type"`
+	// Delay seconds between two probes, default 5
+	Delay int32 `json:"delay,omitempty"`
+	// Timeout seconds of one probe, default 3
+	Timeout int32 `json:"timeout,omitempty"`
+	// MaxRetries number of failed probes before member is offline, default 3
+	MaxRetries int32 `json:"max_retries,omitempty"`
+	// UrlPath and ExpectedCodes only used by HTTP and HTTPS
+	UrlPath       string `json:"url_path,omitempty"`
+	ExpectedCodes string `json:"expected_codes,omitempty"`
+}
+
+type LinkRef struct {
+	APIVersion string `json:"apiVersion"`
+	// Kind is one of Pod, Service, ReplicationController, Deployment,
+	// StatefulSet, DaemonSet, ReplicaSet and Job
+	Kind string `json:"kind"`
+	// Namespace default is same with virtual machine
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+}
+
+type IpSource struct {
+	// Type is Network, PodIPs, Annotation or EndpointSlices, default Network
+	Type string `json:"type,omitempty"`
+	// Network name in k8s.v1.cni.cncf.io/networks-status, default kuryr,
+	// which matches the name with or without namespace
+	Network string `json:"network,omitempty"`
+	// Annotation of pod which hold ips, and Path is gjson path of ips in
+	// annotation value. the whole value is used when path is empty
+	Annotation string `json:"annotation,omitempty"`
+	Path       string `json:"path,omitempty"`
 }
 
 type SubnetSpec struct {
@@ -80,6 +183,33 @@ type ServerSpec struct {
 
 	AvailableZone string      `json:"availability_zone"`
 	Subnet        *SubnetSpec `json:"subnet"`
+
+	// DeleteMembers the member index which should be removed,
+	// scale down will remove those first rather than the tail.
+	DeleteMembers []int32 `json:"deleteMembers,omitempty"`
+	// Nodes the member index list, generated by operator and only rendered
+	Nodes []int32 `json:"-"`
+	// Owner name of virtual machine, generated by operator and only rendered,
+	// which is set on metadata of members and selected by scale subresource
+	Owner string `json:"-"`
+
+	// Expose ACTIVE members by selectorless service and endpoint slices,
+	// which can be reached by cluster dns
+	Expose *ExposeSpec `json:"expose,omitempty"`
+}
+
+type ExposeSpec struct {
+	// Name of service, default is name of virtual machine
+	Name  string        `json:"name,omitempty"`
+	Ports []*ExposePort `json:"ports"`
+}
+
+type ExposePort struct {
+	// Name default is protocol-port, such as tcp-80
+	Name string `json:"name,omitempty"`
+	Port int32  `json:"port"`
+	// Protocol is TCP, UDP or SCTP, default TCP
+	Protocol string `json:"protocol,omitempty"`
 }
 
 type LoadBalanceSpec struct {
@@ -89,6 +219,32 @@ type LoadBalanceSpec struct {
 	LbIp       string      `json:"loadbalance_ip,omitempty"`
 	Link       string      `json:"link,omitempty"`
 	UseService bool        `json:"use_service,omitempty"`
+	// ServiceType is ExternalIP or LoadBalancer, default ExternalIP
+	ServiceType string `json:"service_type,omitempty"`
+	// LinkRef is reference of pods, workload or service, and link is
+	// generated from it by operator
+	LinkRef *LinkRef `json:"link_ref,omitempty"`
+	// MemberMode is All or Ready, default All. pods which are not ready
+	// are removed from pool when Ready, and added back once ready
+	MemberMode string `json:"member_mode,omitempty"`
+	// IpSource where to find ip of pods on link, default is kuryr network
+	IpSource *IpSource `json:"ip_source,omitempty"`
+	// Weights of members from nova servers and pods on link, both sources
+	// are used at the same time when it is set, which is used to shift
+	// traffic between servers and pods. source without weight is not used
+	Weights *MemberWeights `json:"weights,omitempty"`
+	// MemberWeights is weight of each member ip, which is set by operator
+	// and only rendered
+	MemberWeights map[string]int32 `json:"-"`
+	// LbApi is neutron or octavia, which is set by operator and only rendered
+	LbApi string `json:"-"`
+}
+
+// MemberWeights is weight of members from each source, 0 means the
+// members are drained but still in pool, maximum is 256
+type MemberWeights struct {
+	Server *int32 `json:"server,omitempty"`
+	Pod    *int32 `json:"pod,omitempty"`
 }
 
 type PublicSepc struct {
@@ -112,25 +268,63 @@ type Address struct {
 }
 
 type VirtualMachineStatus struct {
-	VmStatus   *ResourceStatus `json:"vmStatus,omitempty"`
-	NetStatus  *ResourceStatus `json:"netStatus,omitempty"`
-	PubStatus  *ResourceStatus `json:"pubStatus,omitempty"`
-	Members    []*ServerStat   `json:"members,omitempty"`
-	Conditions []*Condition    `json:"conditions,omitempty"`
+	VmStatus  *ResourceStatus `json:"vmStatus,omitempty"`
+	NetStatus *ResourceStatus `json:"netStatus,omitempty"`
+	PubStatus *ResourceStatus `json:"pubStatus,omitempty"`
+	Members   []*ServerStat   `json:"members,omitempty"`
+	// LbMembers is status of pool members on load balance
+	LbMembers []*LbMemberStat `json:"lbMembers,omitempty"`
+
+	// Conditions include Ready, ComputeReady, LoadBalancerReady,
+	// PublicIPReady and Progressing
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+	// Phase aggregated from conditions, which is one of
+	// Pending, Progressing, Ready, Failed and Deleting
+	Phase string `json:"phase,omitempty"`
+
+	// ServerAction record the result of Stop, Start or Recreate on members
+	ServerAction *ActionStatus `json:"serverAction,omitempty"`
+
+	// ExposeService is name of service which expose members
+	ExposeService string `json:"exposeService,omitempty"`
+
+	// Replicas and Selector used by scale subresource, selector matches
+	// metadata of members, which is mixapp.easystack.io/owner=<name>
+	Replicas int32  `json:"replicas,omitempty"`
+	Selector string `json:"selector,omitempty"`
 }
 
-type Condition struct {
-	LastUpdateTime string `json:"lastUpdateTime,omitempty"`
-	Type           string `json:"type,omitempty"` //should add Ready type for application
-	Reason         string `json:"reason,omitempty"`
+type ActionStatus struct {
+	Phase AssemblyPhaseType `json:"phase,omitempty"`
+	Stat  string            `json:"stat,omitempty"`
+	// member id which action had been sent, only used by Recreate
+	Members        []string `json:"members,omitempty"`
+	Message        string   `json:"message,omitempty"`
+	LastUpdateTime string   `json:"lastUpdateTime,omitempty"`
 }
 
 type ServerStat struct {
 	Id         string `json:"id,omitempty"`
 	CreateTime string `json:"creationTimestamp,omitempty"`
 	ResStat    string `json:"resstat,omitempty"`
-	Ip         string `json:"ip,omitempty"`
-	ResName    string `json:"resname,omitempty"`
+	// ProvisioningStatus and OperatingStatus only for load balance
+	ProvisioningStatus string `json:"provisioningStatus,omitempty"`
+	OperatingStatus    string `json:"operatingStatus,omitempty"`
+	Ip                 string `json:"ip,omitempty"`
+	ResName            string `json:"resname,omitempty"`
+	// Index is the member index on stack, only for nova server
+	Index *int32 `json:"index,omitempty"`
+}
+
+type LbMemberStat struct {
+	Address string `json:"address"`
+	Port    int32  `json:"port"`
+	// OperatingStatus of member, such as ONLINE, OFFLINE, ERROR and NO_MONITOR
+	OperatingStatus string `json:"operatingStatus,omitempty"`
+	// PodName is set when the ip is found on link,
+	// and MemberId is the nova server id of member
+	PodName  string `json:"podName,omitempty"`
+	MemberId string `json:"memberId,omitempty"`
 }
 
 type ResourceStatus struct {
@@ -141,9 +335,18 @@ type ResourceStatus struct {
 	Name       string     `json:"name"`
 	Stat       string     `json:"phase,omitempty"`
 	Template   string     `json:"template,omitempty"`
+	// backend which provision the resources, such as heat and direct
+	Backend string `json:"backend,omitempty"`
+	// certificates stored in key store, key is name and value is reference,
+	// only used by load balance
+	Certificates map[string]string `json:"certificates,omitempty"`
 }
 
 // +kubebuilder:object:root=true
+// +kubebuilder:subresource:scale:specpath=.spec.server.replicas,statuspath=.status.replicas,selectorpath=.status.selector
+// +kubebuilder:printcolumn:name="Replicas",type=integer,JSONPath=`.status.replicas`
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 // VirtualMachine is the Schema for the virtualmachines API
 type VirtualMachine struct {
 	metav1.TypeMeta   `json:",inline"`
@@ -5,9 +5,30 @@
 package v1
 
 import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ActionStatus) DeepCopyInto(out *ActionStatus) {
+	*out = *in
+	if in.Members != nil {
+		in, out := &in.Members, &out.Members
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ActionStatus.
+func (in *ActionStatus) DeepCopy() *ActionStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ActionStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Address) DeepCopyInto(out *Address) {
 	*out = *in
@@ -26,6 +47,11 @@ func (in *Address) DeepCopy() *Address {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *AuthSpec) DeepCopyInto(out *AuthSpec) {
 	*out = *in
+	if in.SecretRef != nil {
+		in, out := &in.SecretRef, &out.SecretRef
+		*out = new(SecretRef)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AuthSpec.
@@ -39,16 +65,102 @@ func (in *AuthSpec) DeepCopy() *AuthSpec {
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *Condition) DeepCopyInto(out *Condition) {
+func (in *ExposePort) DeepCopyInto(out *ExposePort) {
 	*out = *in
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Condition.
-func (in *Condition) DeepCopy() *Condition {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ExposePort.
+func (in *ExposePort) DeepCopy() *ExposePort {
 	if in == nil {
 		return nil
 	}
-	out := new(Condition)
+	out := new(ExposePort)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ExposeSpec) DeepCopyInto(out *ExposeSpec) {
+	*out = *in
+	if in.Ports != nil {
+		in, out := &in.Ports, &out.Ports
+		*out = make([]*ExposePort, len(*in))
+		for i := range *in {
+			if (*in)[i] != nil {
+				in, out := &(*in)[i], &(*out)[i]
+				*out = new(ExposePort)
+				**out = **in
+			}
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ExposeSpec.
+func (in *ExposeSpec) DeepCopy() *ExposeSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ExposeSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HealthCheck) DeepCopyInto(out *HealthCheck) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HealthCheck.
+func (in *HealthCheck) DeepCopy() *HealthCheck {
+	if in == nil {
+		return nil
+	}
+	out := new(HealthCheck)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IpSource) DeepCopyInto(out *IpSource) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IpSource.
+func (in *IpSource) DeepCopy() *IpSource {
+	if in == nil {
+		return nil
+	}
+	out := new(IpSource)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *LbMemberStat) DeepCopyInto(out *LbMemberStat) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LbMemberStat.
+func (in *LbMemberStat) DeepCopy() *LbMemberStat {
+	if in == nil {
+		return nil
+	}
+	out := new(LbMemberStat)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *LinkRef) DeepCopyInto(out *LinkRef) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LinkRef.
+func (in *LinkRef) DeepCopy() *LinkRef {
+	if in == nil {
+		return nil
+	}
+	out := new(LinkRef)
 	in.DeepCopyInto(out)
 	return out
 }
@@ -72,6 +184,28 @@ func (in *LoadBalanceSpec) DeepCopyInto(out *LoadBalanceSpec) {
 			}
 		}
 	}
+	if in.LinkRef != nil {
+		in, out := &in.LinkRef, &out.LinkRef
+		*out = new(LinkRef)
+		**out = **in
+	}
+	if in.IpSource != nil {
+		in, out := &in.IpSource, &out.IpSource
+		*out = new(IpSource)
+		**out = **in
+	}
+	if in.Weights != nil {
+		in, out := &in.Weights, &out.Weights
+		*out = new(MemberWeights)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.MemberWeights != nil {
+		in, out := &in.MemberWeights, &out.MemberWeights
+		*out = make(map[string]int32, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LoadBalanceSpec.
@@ -84,6 +218,31 @@ func (in *LoadBalanceSpec) DeepCopy() *LoadBalanceSpec {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MemberWeights) DeepCopyInto(out *MemberWeights) {
+	*out = *in
+	if in.Server != nil {
+		in, out := &in.Server, &out.Server
+		*out = new(int32)
+		**out = **in
+	}
+	if in.Pod != nil {
+		in, out := &in.Pod, &out.Pod
+		*out = new(int32)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MemberWeights.
+func (in *MemberWeights) DeepCopy() *MemberWeights {
+	if in == nil {
+		return nil
+	}
+	out := new(MemberWeights)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *PortMap) DeepCopyInto(out *PortMap) {
 	*out = *in
@@ -92,6 +251,26 @@ func (in *PortMap) DeepCopyInto(out *PortMap) {
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.HealthCheck != nil {
+		in, out := &in.HealthCheck, &out.HealthCheck
+		*out = new(HealthCheck)
+		**out = **in
+	}
+	if in.SessionPersistence != nil {
+		in, out := &in.SessionPersistence, &out.SessionPersistence
+		*out = new(SessionPersistence)
+		**out = **in
+	}
+	if in.SniSecrets != nil {
+		in, out := &in.SniSecrets, &out.SniSecrets
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.SniContainerRefs != nil {
+		in, out := &in.SniContainerRefs, &out.SniContainerRefs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PortMap.
@@ -132,7 +311,14 @@ func (in *PublicSepc) DeepCopy() *PublicSepc {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ResourceStatus) DeepCopyInto(out *ResourceStatus) {
 	*out = *in
-	out.ServerStat = in.ServerStat
+	in.ServerStat.DeepCopyInto(&out.ServerStat)
+	if in.Certificates != nil {
+		in, out := &in.Certificates, &out.Certificates
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ResourceStatus.
@@ -145,6 +331,21 @@ func (in *ResourceStatus) DeepCopy() *ResourceStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SecretRef) DeepCopyInto(out *SecretRef) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SecretRef.
+func (in *SecretRef) DeepCopy() *SecretRef {
+	if in == nil {
+		return nil
+	}
+	out := new(SecretRef)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ServerSpec) DeepCopyInto(out *ServerSpec) {
 	*out = *in
@@ -174,6 +375,21 @@ func (in *ServerSpec) DeepCopyInto(out *ServerSpec) {
 		*out = new(SubnetSpec)
 		**out = **in
 	}
+	if in.DeleteMembers != nil {
+		in, out := &in.DeleteMembers, &out.DeleteMembers
+		*out = make([]int32, len(*in))
+		copy(*out, *in)
+	}
+	if in.Nodes != nil {
+		in, out := &in.Nodes, &out.Nodes
+		*out = make([]int32, len(*in))
+		copy(*out, *in)
+	}
+	if in.Expose != nil {
+		in, out := &in.Expose, &out.Expose
+		*out = new(ExposeSpec)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ServerSpec.
@@ -189,6 +405,11 @@ func (in *ServerSpec) DeepCopy() *ServerSpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ServerStat) DeepCopyInto(out *ServerStat) {
 	*out = *in
+	if in.Index != nil {
+		in, out := &in.Index, &out.Index
+		*out = new(int32)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ServerStat.
@@ -201,6 +422,21 @@ func (in *ServerStat) DeepCopy() *ServerStat {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SessionPersistence) DeepCopyInto(out *SessionPersistence) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SessionPersistence.
+func (in *SessionPersistence) DeepCopy() *SessionPersistence {
+	if in == nil {
+		return nil
+	}
+	out := new(SessionPersistence)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *SubnetSpec) DeepCopyInto(out *SubnetSpec) {
 	*out = *in
@@ -281,7 +517,7 @@ func (in *VirtualMachineSpec) DeepCopyInto(out *VirtualMachineSpec) {
 	if in.Auth != nil {
 		in, out := &in.Auth, &out.Auth
 		*out = new(AuthSpec)
-		**out = **in
+		(*in).DeepCopyInto(*out)
 	}
 	if in.Server != nil {
 		in, out := &in.Server, &out.Server
@@ -316,17 +552,17 @@ func (in *VirtualMachineStatus) DeepCopyInto(out *VirtualMachineStatus) {
 	if in.VmStatus != nil {
 		in, out := &in.VmStatus, &out.VmStatus
 		*out = new(ResourceStatus)
-		**out = **in
+		(*in).DeepCopyInto(*out)
 	}
 	if in.NetStatus != nil {
 		in, out := &in.NetStatus, &out.NetStatus
 		*out = new(ResourceStatus)
-		**out = **in
+		(*in).DeepCopyInto(*out)
 	}
 	if in.PubStatus != nil {
 		in, out := &in.PubStatus, &out.PubStatus
 		*out = new(ResourceStatus)
-		**out = **in
+		(*in).DeepCopyInto(*out)
 	}
 	if in.Members != nil {
 		in, out := &in.Members, &out.Members
@@ -335,21 +571,33 @@ func (in *VirtualMachineStatus) DeepCopyInto(out *VirtualMachineStatus) {
 			if (*in)[i] != nil {
 				in, out := &(*in)[i], &(*out)[i]
 				*out = new(ServerStat)
-				**out = **in
+				(*in).DeepCopyInto(*out)
 			}
 		}
 	}
-	if in.Conditions != nil {
-		in, out := &in.Conditions, &out.Conditions
-		*out = make([]*Condition, len(*in))
+	if in.LbMembers != nil {
+		in, out := &in.LbMembers, &out.LbMembers
+		*out = make([]*LbMemberStat, len(*in))
 		for i := range *in {
 			if (*in)[i] != nil {
 				in, out := &(*in)[i], &(*out)[i]
-				*out = new(Condition)
+				*out = new(LbMemberStat)
 				**out = **in
 			}
 		}
 	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.ServerAction != nil {
+		in, out := &in.ServerAction, &out.ServerAction
+		*out = new(ActionStatus)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VirtualMachineStatus.
@@ -0,0 +1,140 @@
+package controllers
+
+import (
+	"fmt"
+
+	vmv1 "easystack.io/vm-operator/pkg/api/v1"
+	"easystack.io/vm-operator/pkg/manage"
+	"easystack.io/vm-operator/pkg/template"
+	klog "k8s.io/klog/v2"
+)
+
+const (
+	HeatBackend   = "heat"
+	DirectBackend = "direct"
+
+	// annotation on virtual machine, which select backend of new resources
+	BackendAnnotation = "mixapp.easystack.io/backend"
+)
+
+// Backend provision openstack resources of one kind (nova, lb or fip).
+//
+// The ResourceStatus of the kind must not be nil, and StackName is set before.
+// All backends keep the same contract on ResourceStatus:
+// StackID is not empty after created, Stat is Creating, Updating, Succeeded or Failed,
+// HashId is the hash of rendered template, and Template is json which has resources.
+type Backend interface {
+	Name() string
+	// Ensure create or update resources to match spec
+	Ensure(kind manage.OpResource, vm *vmv1.VirtualMachine) error
+	// Observe update Stat of resources, reason is returned when failed
+	Observe(kind manage.OpResource, vm *vmv1.VirtualMachine) error
+	// Delete remove resources, StackID and StackName is cleaned when all removed
+	Delete(kind manage.OpResource, vm *vmv1.VirtualMachine) error
+}
+
+type backends struct {
+	def   string
+	items map[string]Backend
+}
+
+func newBackends(def string, items ...Backend) *backends {
+	bs := &backends{
+		def:   def,
+		items: make(map[string]Backend),
+	}
+	for _, v := range items {
+		bs.items[v.Name()] = v
+	}
+	if _, ok := bs.items[def]; !ok {
+		panic(fmt.Sprintf("not found backend %s", def))
+	}
+	return bs
+}
+
+// the backend is fixed once resources created, annotation
+// and flag only affect new resources
+func (b *backends) get(kind manage.OpResource, vm *vmv1.VirtualMachine) (Backend, error) {
+	stat := statOf(kind, vm)
+	if stat == nil {
+		return nil, fmt.Errorf("not found status of %s", kind)
+	}
+	name := b.def
+	switch {
+	case stat.Backend != "":
+		name = stat.Backend
+	case stat.StackID != "":
+		// created before backend is recorded
+		name = HeatBackend
+	case vm.Annotations[BackendAnnotation] != "":
+		name = vm.Annotations[BackendAnnotation]
+	}
+	be, ok := b.items[name]
+	if !ok {
+		return nil, fmt.Errorf("not found backend %s", name)
+	}
+	stat.Backend = name
+	return be, nil
+}
+
+// ensure resources of kind, and observe the stat
+func (b *backends) process(kind manage.OpResource, vm *vmv1.VirtualMachine) error {
+	be, err := b.get(kind, vm)
+	if err != nil {
+		return err
+	}
+	klog.V(3).Infof("process %s by backend %s", kind, be.Name())
+	err = be.Ensure(kind, vm)
+	if err != nil {
+		return err
+	}
+	return be.Observe(kind, vm)
+}
+
+func (b *backends) delete(kind manage.OpResource, vm *vmv1.VirtualMachine) error {
+	stat := statOf(kind, vm)
+	if stat == nil || stat.StackName == "" {
+		return nil
+	}
+	be, err := b.get(kind, vm)
+	if err != nil {
+		return err
+	}
+	klog.V(3).Infof("delete %s by backend %s", kind, be.Name())
+	return be.Delete(kind, vm)
+}
+
+func validBackend(name string) error {
+	switch name {
+	case HeatBackend, DirectBackend:
+		return nil
+	default:
+		return fmt.Errorf("backend should be %s or %s, but %s", HeatBackend, DirectBackend, name)
+	}
+}
+
+func statOf(kind manage.OpResource, vm *vmv1.VirtualMachine) *vmv1.ResourceStatus {
+	switch kind {
+	case manage.Vm:
+		return vm.Status.VmStatus
+	case manage.Lb:
+		return vm.Status.NetStatus
+	case manage.Fip:
+		return vm.Status.PubStatus
+	default:
+		return nil
+	}
+}
+
+func templateOf(kind manage.OpResource) (template.Kind, error) {
+	switch kind {
+	case manage.Vm:
+		return template.Vm, nil
+	case manage.Lb:
+		return template.Lb, nil
+	case manage.Fip:
+		return template.Fip, nil
+	default:
+		return 0, fmt.Errorf("not found openstack resource %v", kind)
+	}
+}
@@ -0,0 +1,357 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	vmv1 "easystack.io/vm-operator/pkg/api/v1"
+	"easystack.io/vm-operator/pkg/manage"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	CondReady        = "Ready"
+	CondCompute      = "ComputeReady"
+	CondLoadBalancer = "LoadBalancerReady"
+	CondPublicIp     = "PublicIPReady"
+	CondProgressing  = "Progressing"
+
+	PhasePending     = "Pending"
+	PhaseProgressing = "Progressing"
+	PhaseReady       = "Ready"
+	PhaseFailed      = "Failed"
+	PhaseDeleting    = "Deleting"
+
+	// load balance operating status
+	LbOnline    = "ONLINE"
+	LbNoMonitor = "NO_MONITOR"
+	LbDegraded  = "DEGRADED"
+
+	// load balance provisioning status
+	LbProvisionError = "ERROR"
+)
+
+var (
+	// conditions sorted by Ready condition
+	resourceConds = []string{CondCompute, CondLoadBalancer, CondPublicIp}
+)
+
+// specError is returned when spec is invalid, which is terminal until
+// spec is changed, and other errors are retried
+type specError struct {
+	error
+}
+
+func invalidSpec(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &specError{err}
+}
+
+// record error on condition which op belong to. invalid spec is terminal,
+// and other errors such as openstack api error are retried, which are
+// recorded on Progressing
+func updateCondition(vm *vmv1.VirtualMachine, op string, err error) {
+	if err == nil {
+		return
+	}
+	var (
+		cond = metav1.Condition{
+			Status:             metav1.ConditionFalse,
+			ObservedGeneration: vm.Generation,
+			Reason:             "InvalidSpec",
+			Message:            err.Error(),
+		}
+		prefix string
+		serr   *specError
+	)
+	switch op {
+	case manage.Vm.String():
+		cond.Type, prefix = CondCompute, "Compute"
+	case manage.Lb.String():
+		cond.Type, prefix = CondLoadBalancer, "LoadBalancer"
+	case manage.Fip.String():
+		cond.Type, prefix = CondPublicIp, "PublicIP"
+	default:
+		cond.Type = CondReady
+	}
+	if prefix != "" && !errors.As(err, &serr) {
+		cond.Type = CondProgressing
+		cond.Status = metav1.ConditionTrue
+		cond.Reason = prefix + "Retrying"
+	}
+	meta.SetStatusCondition(&vm.Status.Conditions, cond)
+	if cond.Type != CondReady {
+		setReady(vm)
+	}
+	setPhase(vm)
+}
+
+// setConditions derive conditions and phase from status
+func setConditions(vm *vmv1.VirtualMachine) {
+	var (
+		stat = &vm.Status
+		gen  = vm.Generation
+	)
+	// remove condition which is not known, such as older format
+	var conds []metav1.Condition
+	for _, cond := range stat.Conditions {
+		switch cond.Type {
+		case CondReady, CondCompute, CondLoadBalancer, CondPublicIp, CondProgressing:
+			conds = append(conds, cond)
+		}
+	}
+	stat.Conditions = conds
+
+	if vm.Spec.Server != nil {
+		setCondition(stat, computeCondition(vm), gen)
+	} else {
+		meta.RemoveStatusCondition(&stat.Conditions, CondCompute)
+	}
+	if vm.Spec.LoadBalance != nil {
+		setCondition(stat, lbCondition(vm), gen)
+	} else {
+		meta.RemoveStatusCondition(&stat.Conditions, CondLoadBalancer)
+	}
+	if isPublicRequired(vm.Spec.Public) {
+		setCondition(stat, fipCondition(vm), gen)
+	} else {
+		meta.RemoveStatusCondition(&stat.Conditions, CondPublicIp)
+	}
+	setCondition(stat, progressCondition(vm), gen)
+	setReady(vm)
+	setPhase(vm)
+}
+
+func setCondition(stat *vmv1.VirtualMachineStatus, cond metav1.Condition, gen int64) {
+	cond.ObservedGeneration = gen
+	meta.SetStatusCondition(&stat.Conditions, cond)
+}
+
+func setReady(vm *vmv1.VirtualMachine) {
+	var (
+		stat = &vm.Status
+		cond = metav1.Condition{
+			Type:   CondReady,
+			Status: metav1.ConditionTrue,
+			Reason: "Ready",
+		}
+	)
+	for _, ty := range resourceConds {
+		v := meta.FindStatusCondition(stat.Conditions, ty)
+		if v == nil || v.Status == metav1.ConditionTrue {
+			continue
+		}
+		cond.Status = v.Status
+		cond.Reason = strings.TrimSuffix(ty, "Ready") + "NotReady"
+		cond.Message = v.Message
+		break
+	}
+	setCondition(stat, cond, vm.Generation)
+}
+
+func setPhase(vm *vmv1.VirtualMachine) {
+	var (
+		stat = &vm.Status
+	)
+	if vm.DeletionTimestamp != nil {
+		stat.Phase = PhaseDeleting
+		return
+	}
+	if meta.IsStatusConditionTrue(stat.Conditions, CondReady) {
+		stat.Phase = PhaseReady
+		return
+	}
+	for _, ty := range append(resourceConds, CondReady) {
+		v := meta.FindStatusCondition(stat.Conditions, ty)
+		if v == nil || v.Status == metav1.ConditionTrue {
+			continue
+		}
+		if isFailedReason(v.Reason) {
+			stat.Phase = PhaseFailed
+			return
+		}
+	}
+	if meta.IsStatusConditionTrue(stat.Conditions, CondProgressing) {
+		stat.Phase = PhaseProgressing
+		return
+	}
+	stat.Phase = PhasePending
+}
+
+func isFailedReason(reason string) bool {
+	switch reason {
+	case "StackFailed", "ActionFailed", "MemberError", "InvalidSpec":
+		return true
+	}
+	return false
+}
+
+// condition by stack stat, the second return is true if stack is succeeded
+func stackCondition(ty string, rs *vmv1.ResourceStatus) (metav1.Condition, bool) {
+	var cond = metav1.Condition{
+		Type:   ty,
+		Status: metav1.ConditionFalse,
+	}
+	if rs == nil || rs.StackID == "" {
+		cond.Reason = "Pending"
+		cond.Message = "stack is not created"
+		return cond, false
+	}
+	switch rs.Stat {
+	case Succeeded:
+		return cond, true
+	case Failed:
+		cond.Reason = "StackFailed"
+		cond.Message = fmt.Sprintf("stack %s failed", rs.StackName)
+	case string(vmv1.Creating), string(vmv1.Updating):
+		cond.Reason = "StackInProgress"
+		cond.Message = fmt.Sprintf("stack %s is %s", rs.StackName, rs.Stat)
+	case string(vmv1.Deleting):
+		cond.Reason = "Deleting"
+	default:
+		cond.Status = metav1.ConditionUnknown
+		cond.Reason = "Unknown"
+		cond.Message = fmt.Sprintf("stack %s stat is %s", rs.StackName, rs.Stat)
+	}
+	return cond, false
+}
+
+func computeCondition(vm *vmv1.VirtualMachine) metav1.Condition {
+	var (
+		stat = &vm.Status
+		want = ServerRunStat
+		num  int32
+	)
+	cond, ok := stackCondition(CondCompute, stat.VmStatus)
+	if !ok {
+		return cond
+	}
+	if act := stat.ServerAction; act != nil && act.Phase == vm.Spec.AssemblyPhase && act.Stat == Failed {
+		cond.Reason = "ActionFailed"
+		cond.Message = act.Message
+		return cond
+	}
+	if vm.Spec.AssemblyPhase == vmv1.Stop {
+		want = ServerStopStat
+	}
+	for _, mem := range stat.Members {
+		switch mem.ResStat {
+		case want:
+			num++
+		case ServerErrStat:
+			cond.Reason = "MemberError"
+			cond.Message = fmt.Sprintf("member %s is in %s stat", mem.Id, ServerErrStat)
+			return cond
+		}
+	}
+	if num < vm.Spec.Server.Replicas {
+		cond.Reason = "MembersNotReady"
+		cond.Message = fmt.Sprintf("%d/%d members are %s", num, vm.Spec.Server.Replicas, want)
+		return cond
+	}
+	cond.Status = metav1.ConditionTrue
+	cond.Reason = "MembersReady"
+	cond.Message = fmt.Sprintf("%d members are %s", num, want)
+	return cond
+}
+
+func lbCondition(vm *vmv1.VirtualMachine) metav1.Condition {
+	var (
+		stat = &vm.Status
+	)
+	cond, ok := stackCondition(CondLoadBalancer, stat.NetStatus)
+	if !ok {
+		return cond
+	}
+	ss := stat.NetStatus.ServerStat
+	if ss.Id == "" {
+		cond.Reason = "NotFound"
+		cond.Message = "load balance is not found"
+		return cond
+	}
+	if ss.ProvisioningStatus == LbProvisionError {
+		cond.Reason = "ProvisionError"
+		cond.Message = fmt.Sprintf("load balance %s provisioning status is %s", ss.Ip, ss.ProvisioningStatus)
+		return cond
+	}
+	switch ss.ResStat {
+	case LbOnline, LbNoMonitor:
+		cond.Status = metav1.ConditionTrue
+		cond.Reason = "Online"
+	case LbDegraded:
+		cond.Status = metav1.ConditionTrue
+		cond.Reason = "Degraded"
+	default:
+		cond.Reason = "Offline"
+	}
+	cond.Message = fmt.Sprintf("load balance %s operating status is %s", ss.Ip, ss.ResStat)
+	return cond
+}
+
+func fipCondition(vm *vmv1.VirtualMachine) metav1.Condition {
+	var (
+		stat = &vm.Status
+	)
+	cond, ok := stackCondition(CondPublicIp, stat.PubStatus)
+	if !ok {
+		return cond
+	}
+	ss := stat.PubStatus.ServerStat
+	if ss.Ip == "" {
+		cond.Reason = "Unbound"
+		cond.Message = "floating ip is not bound"
+		return cond
+	}
+	cond.Status = metav1.ConditionTrue
+	cond.Reason = "Bound"
+	cond.Message = fmt.Sprintf("floating ip %s is %s", ss.Ip, ss.ResStat)
+	return cond
+}
+
+func progressCondition(vm *vmv1.VirtualMachine) metav1.Condition {
+	var (
+		stat = &vm.Status
+		cond = metav1.Condition{
+			Type:   CondProgressing,
+			Status: metav1.ConditionTrue,
+		}
+	)
+	for _, rs := range []*vmv1.ResourceStatus{stat.VmStatus, stat.NetStatus, stat.PubStatus} {
+		if rs == nil {
+			continue
+		}
+		if rs.Stat == string(vmv1.Creating) || rs.Stat == string(vmv1.Updating) {
+			cond.Reason = "StackInProgress"
+			cond.Message = fmt.Sprintf("stack %s is %s", rs.StackName, rs.Stat)
+			return cond
+		}
+	}
+	if act := stat.ServerAction; act != nil && act.Phase == vm.Spec.AssemblyPhase && act.Stat == Processing {
+		cond.Reason = "ActionInProgress"
+		cond.Message = fmt.Sprintf("%s members is in progress", act.Phase)
+		return cond
+	}
+	for _, mem := range stat.Members {
+		if mem.ResStat == ServerBuildStat {
+			cond.Reason = "MembersBuilding"
+			cond.Message = fmt.Sprintf("member %s is in %s stat", mem.Id, ServerBuildStat)
+			return cond
+		}
+	}
+	cond.Status = metav1.ConditionFalse
+	cond.Reason = "Done"
+	return cond
+}
+
+// public ip condition is only required when floating ip should be bound
+func isPublicRequired(pi *vmv1.PublicSepc) bool {
+	if pi == nil || pi.Address == nil {
+		return false
+	}
+	return pi.Address.Allocate || pi.Address.Ip != ""
+}
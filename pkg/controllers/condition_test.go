@@ -0,0 +1,70 @@
+package controllers
+
+import (
+	"fmt"
+	"testing"
+
+	vmv1 "easystack.io/vm-operator/pkg/api/v1"
+	"easystack.io/vm-operator/pkg/manage"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+)
+
+func TestSetConditions(t *testing.T) {
+	vm := &vmv1.VirtualMachine{
+		Spec: vmv1.VirtualMachineSpec{
+			Server:        &vmv1.ServerSpec{Replicas: 2},
+			AssemblyPhase: vmv1.Creating,
+		},
+		Status: vmv1.VirtualMachineStatus{
+			VmStatus: &vmv1.ResourceStatus{
+				StackID:   "id",
+				StackName: "vm",
+				Stat:      string(vmv1.Creating),
+			},
+		},
+	}
+	vm.Generation = 2
+	setConditions(vm)
+	if vm.Status.Phase != PhaseProgressing {
+		t.Errorf("phase should be %s, but %s", PhaseProgressing, vm.Status.Phase)
+	}
+
+	vm.Status.VmStatus.Stat = Succeeded
+	vm.Status.Members = []*vmv1.ServerStat{
+		{Id: "1", ResStat: ServerRunStat},
+		{Id: "2", ResStat: ServerBuildStat},
+	}
+	setConditions(vm)
+	if meta.IsStatusConditionTrue(vm.Status.Conditions, CondReady) {
+		t.Errorf("should not be ready when member is building")
+	}
+	if vm.Status.Phase != PhaseProgressing {
+		t.Errorf("phase should be %s, but %s", PhaseProgressing, vm.Status.Phase)
+	}
+
+	vm.Status.Members[1].ResStat = ServerRunStat
+	setConditions(vm)
+	cond := meta.FindStatusCondition(vm.Status.Conditions, CondReady)
+	if cond == nil || cond.ObservedGeneration != 2 || vm.Status.Phase != PhaseReady {
+		t.Errorf("should be ready, condition is %v", cond)
+	}
+
+	// transient error is retried, which not change ready members
+	updateCondition(vm, manage.Vm.String(), fmt.Errorf("nova timeout"))
+	cond = meta.FindStatusCondition(vm.Status.Conditions, CondProgressing)
+	if cond == nil || cond.Status != "True" || cond.Reason != "ComputeRetrying" || cond.Message != "nova timeout" {
+		t.Errorf("error should be on progressing, condition is %v", cond)
+	}
+	if vm.Status.Phase != PhaseReady {
+		t.Errorf("phase should be %s, but %s", PhaseReady, vm.Status.Phase)
+	}
+
+	updateCondition(vm, manage.Vm.String(), invalidSpec(fmt.Errorf("invalid flavor")))
+	if vm.Status.Phase != PhaseFailed {
+		t.Errorf("phase should be %s, but %s", PhaseFailed, vm.Status.Phase)
+	}
+	if len(vm.Status.Conditions) != 3 {
+		t.Errorf("conditions should be 3, but %v", vm.Status.Conditions)
+	}
+}
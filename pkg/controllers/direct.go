@@ -0,0 +1,1685 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	vmv1 "easystack.io/vm-operator/pkg/api/v1"
+	"easystack.io/vm-operator/pkg/manage"
+	"easystack.io/vm-operator/pkg/util"
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack"
+	"github.com/gophercloud/gophercloud/openstack/blockstorage/v3/volumes"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/bootfromvolume"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/keypairs"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/flavors"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
+	"github.com/gophercloud/gophercloud/openstack/imageservice/v2/images"
+	octavialisteners "github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/listeners"
+	octavialbs "github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/loadbalancers"
+	octaviamonitors "github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/monitors"
+	octaviapools "github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/pools"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/layer3/floatingips"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/lbaas_v2/listeners"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/lbaas_v2/loadbalancers"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/lbaas_v2/monitors"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/lbaas_v2/pools"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/security/groups"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/networks"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+	klog "k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+)
+
+// resource is not ready, should try again next time
+var errInProgress = errors.New("in progress")
+
+// resource is in error stat, which is recreated after observed
+type brokenError struct {
+	error
+}
+
+func isBroken(err error) bool {
+	_, ok := err.(*brokenError)
+	return ok
+}
+
+// Direct provision resources by nova, cinder, neutron and octavia api, heat is not needed.
+//
+// The same template is rendered as the desired resources, and resources which
+// had been created are recorded on stat.Template with physical id, so the
+// reorder funcs work same as heat.
+// Resource is updated in place when only updatable properties changed, such as
+// server metadata and member weight, otherwise it is recreated.
+// QoS is not supported, bandwidth limit is rejected.
+// Resources removed or broken out of band are found by Observe and recreated.
+type Direct struct {
+	heat     *Heat
+	recorder record.EventRecorder
+}
+
+type directResource struct {
+	Type       string                 `json:"type"`
+	Properties map[string]interface{} `json:"properties,omitempty"`
+	DependsOn  []string               `json:"depends_on,omitempty"`
+	PhysicalID string                 `json:"physical_id,omitempty"`
+	// removed or broken out of band, which should be recreated
+	Drifted bool `json:"drifted,omitempty"`
+}
+
+type directResources struct {
+	Resources map[string]*directResource `json:"resources"`
+}
+
+// heat is used to render template and fetch auth
+func NewDirect(heat *Heat, recorder record.EventRecorder) *Direct {
+	return &Direct{
+		heat:     heat,
+		recorder: recorder,
+	}
+}
+
+func (d *Direct) Name() string {
+	return DirectBackend
+}
+
+func (d *Direct) Ensure(kind manage.OpResource, vm *vmv1.VirtualMachine) error {
+	tpl, err := templateOf(kind)
+	if err != nil {
+		return err
+	}
+	if vm.DeletionTimestamp != nil {
+		return d.Delete(kind, vm)
+	}
+	stat := statOf(kind, vm)
+	data, err := d.heat.render(tpl, &vm.Spec, stat)
+	if err != nil {
+		klog.Errorf("render template failed: %v", err)
+		return err
+	}
+	hashid := util.Hashid(data)
+	if stat.HashId == hashid && stat.Stat == Succeeded {
+		return nil
+	}
+	desired, err := parseResources(data)
+	if err != nil {
+		return err
+	}
+	err = validResources(desired)
+	if err != nil {
+		return invalidSpec(err)
+	}
+	cli, err := d.getClient(vm.Spec.Auth, vm.Namespace)
+	if err != nil {
+		return err
+	}
+	prestat := stat.Stat
+	switch {
+	case stat.StackID == "":
+		stat.StackID = stat.StackName
+		stat.Stat = string(vmv1.Creating)
+	case stat.HashId != hashid || stat.Stat == Failed || stat.Stat == Succeeded:
+		stat.Stat = string(vmv1.Updating)
+	}
+	stat.HashId = hashid
+
+	recorded := loadResources(stat.Template)
+	done, err := converge(cli, desired, recorded)
+	stat.Template = dumpResources(recorded)
+	if err != nil {
+		klog.Errorf("provision %s resources %s failed: %v", kind, stat.StackName, err)
+		stat.Stat = Failed
+		if prestat != Failed {
+			d.recorder.Eventf(vm, corev1.EventTypeWarning, ReasonStackFailed, "%s resources %s failed: %v", kind, stat.StackName, err)
+		}
+		return err
+	}
+	if !done {
+		return nil
+	}
+	if stat.Stat == string(vmv1.Creating) {
+		d.recorder.Eventf(vm, corev1.EventTypeNormal, ReasonStackCreated, "created %s resources %s", kind, stat.StackName)
+	} else {
+		d.recorder.Eventf(vm, corev1.EventTypeNormal, ReasonStackUpdated, "updated %s resources %s", kind, stat.StackName)
+	}
+	stat.Stat = Succeeded
+	return nil
+}
+
+// Observe check recorded resources after succeeded, which are removed or broken
+// out of band are marked as drifted, and the stat is cleared, so next Ensure
+// recreate them.
+func (d *Direct) Observe(kind manage.OpResource, vm *vmv1.VirtualMachine) error {
+	stat := statOf(kind, vm)
+	if stat == nil || vm.DeletionTimestamp != nil {
+		return nil
+	}
+	if stat.Stat == Failed {
+		return fmt.Errorf("%s resources %s failed", kind, stat.StackName)
+	}
+	if stat.Stat != Succeeded {
+		return nil
+	}
+	recorded := loadResources(stat.Template)
+	drifted, err := d.observe(vm, recorded)
+	if len(drifted) != 0 {
+		stat.Template = dumpResources(recorded)
+		stat.Stat = string(vmv1.Updating)
+		d.recorder.Eventf(vm, corev1.EventTypeWarning, ReasonResourceDrifted, "%s resources %v of %s are removed or broken, recreate them", kind, drifted, stat.StackName)
+	}
+	return err
+}
+
+func (d *Direct) observe(vm *vmv1.VirtualMachine, recorded map[string]*directResource) ([]string, error) {
+	if len(recorded) == 0 {
+		return nil, nil
+	}
+	cli, err := d.getClient(vm.Spec.Auth, vm.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	return observe(cli, recorded)
+}
+
+// mark resources which are removed or broken as drifted
+func observe(cli *directClient, recorded map[string]*directResource) ([]string, error) {
+	var drifted []string
+	for _, name := range sortedNames(recorded) {
+		res := recorded[name]
+		handler, ok := directHandlers[res.Type]
+		if !ok || res.PhysicalID == "" || res.Drifted {
+			continue
+		}
+		var err error
+		switch {
+		case handler.ready != nil:
+			_, err = handler.ready(cli, res.PhysicalID)
+		case handler.get != nil:
+			err = handler.get(cli, res.PhysicalID)
+		default:
+			continue
+		}
+		if err == nil {
+			continue
+		}
+		if _, ok := err.(gophercloud.ErrDefault404); !ok && !isBroken(err) {
+			return drifted, fmt.Errorf("get %s(%s) failed: %v", name, res.Type, err)
+		}
+		klog.Warningf("resource %s(%s) id(%s) drifted: %v", name, res.Type, res.PhysicalID, err)
+		res.Drifted = true
+		drifted = append(drifted, name)
+	}
+	return drifted, nil
+}
+
+// resources are removed by order, error is returned until all removed,
+// so the finalizer will not be removed
+func (d *Direct) Delete(kind manage.OpResource, vm *vmv1.VirtualMachine) error {
+	var (
+		stat = statOf(kind, vm)
+		done = true
+		err  error
+	)
+	if stat == nil || stat.StackName == "" {
+		return nil
+	}
+	stat.Stat = string(vmv1.Deleting)
+	recorded := loadResources(stat.Template)
+	if len(recorded) != 0 {
+		// same with heat, operator is used when credentials of auth can not be used
+		cli := &directClient{provider: d.heat.deleteProvider(vm.Spec.Auth, vm.Namespace)}
+		done, err = converge(cli, nil, recorded)
+		stat.Template = dumpResources(recorded)
+	}
+	if err != nil {
+		return err
+	}
+	if !done {
+		return fmt.Errorf("resources %s are deleting", stat.StackName)
+	}
+	klog.V(2).Infof("success delete resources %s", stat.StackName)
+	stat.StackID = ""
+	stat.StackName = ""
+	stat.Template = ""
+	return nil
+}
+
+func (d *Direct) getClient(as *vmv1.AuthSpec, namespace string) (*directClient, error) {
+	opts, err := d.heat.authOptions(as, namespace)
+	if err != nil {
+		return nil, err
+	}
+	provider, err := openstack.AuthenticatedClient(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &directClient{provider: provider}, nil
+}
+
+// converge recorded to desired step by step, deletion is first.
+// return true if all resources are desired and ready.
+func converge(cli *directClient, desired, recorded map[string]*directResource) (bool, error) {
+	for {
+		name, create, err := nextStep(desired, recorded)
+		if err != nil {
+			return false, err
+		}
+		if name == "" {
+			break
+		}
+		var res *directResource
+		if create {
+			res = desired[name]
+		} else {
+			res = recorded[name]
+		}
+		handler, ok := directHandlers[res.Type]
+		if !ok {
+			return false, fmt.Errorf("resource %s type %s is not supported", name, res.Type)
+		}
+		ok, err = dependsReady(cli, res, recorded)
+		if err != nil || !ok {
+			return false, err
+		}
+		if create {
+			props := resolve(res.Properties, recorded).(map[string]interface{})
+			klog.V(2).Infof("create resource %s(%s)", name, res.Type)
+			id, err := handler.create(cli, name, props)
+			if err != nil {
+				if isInProgress(err) {
+					return false, nil
+				}
+				return false, fmt.Errorf("create %s(%s) failed: %v", name, res.Type, err)
+			}
+			cp := *res
+			cp.PhysicalID = id
+			recorded[name] = &cp
+			continue
+		}
+		klog.V(2).Infof("delete resource %s(%s) id(%s)", name, res.Type, res.PhysicalID)
+		if res.PhysicalID != "" {
+			err = handler.delete(cli, res.PhysicalID)
+			if err != nil {
+				if _, ok := err.(gophercloud.ErrDefault404); !ok {
+					if isInProgress(err) {
+						return false, nil
+					}
+					return false, fmt.Errorf("delete %s(%s) failed: %v", name, res.Type, err)
+				}
+			}
+		}
+		delete(recorded, name)
+	}
+	// left changed resources are updatable, see nextStep
+	for _, name := range sortedNames(recorded) {
+		res, want := recorded[name], desired[name]
+		if want == nil || sameResource(want, res) {
+			continue
+		}
+		handler := directHandlers[res.Type]
+		ok, err := dependsReady(cli, res, recorded)
+		if err != nil || !ok {
+			return false, err
+		}
+		props := resolve(want.Properties, recorded).(map[string]interface{})
+		klog.V(2).Infof("update resource %s(%s) id(%s)", name, res.Type, res.PhysicalID)
+		err = handler.update(cli, res.PhysicalID, props)
+		if err != nil {
+			if isInProgress(err) {
+				return false, nil
+			}
+			return false, fmt.Errorf("update %s(%s) failed: %v", name, res.Type, err)
+		}
+		cp := *want
+		cp.PhysicalID = res.PhysicalID
+		recorded[name] = &cp
+	}
+	for _, name := range sortedNames(recorded) {
+		ok, err := checkReady(cli, name, recorded[name])
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// resource removed out of band is marked as drifted, such as volume
+// deleted on termination of drifted server, which is recreated next time
+func checkReady(cli *directClient, name string, res *directResource) (bool, error) {
+	handler, ok := directHandlers[res.Type]
+	if !ok || handler.ready == nil || res.PhysicalID == "" {
+		return true, nil
+	}
+	ok, err := handler.ready(cli, res.PhysicalID)
+	if err != nil {
+		if _, notfound := err.(gophercloud.ErrDefault404); notfound {
+			klog.Warningf("resource %s(%s) id(%s) is removed, recreate it", name, res.Type, res.PhysicalID)
+			res.Drifted = true
+			return false, nil
+		}
+		return false, fmt.Errorf("resource %s(%s) failed: %v", name, res.Type, err)
+	}
+	if !ok {
+		klog.V(2).Infof("resource %s(%s) is not ready", name, res.Type)
+	}
+	return ok, nil
+}
+
+// find next resource should be deleted or created, empty name means nothing to do.
+// resource is stale when drifted, properties changed or not desired, which depends on
+// stale resource is stale too, and stale resources are deleted from leaf.
+// resource changed only on updatable properties is not stale.
+func nextStep(desired, recorded map[string]*directResource) (string, bool, error) {
+	stale := make(map[string]bool)
+	for name, res := range recorded {
+		want, ok := desired[name]
+		if !ok || res.Drifted || !sameResource(want, res) && !canUpdate(want, res) {
+			stale[name] = true
+		}
+	}
+	for changed := true; changed; {
+		changed = false
+		for name, res := range recorded {
+			if stale[name] {
+				continue
+			}
+			for _, dep := range dependsOf(res) {
+				if stale[dep] {
+					stale[name] = true
+					changed = true
+					break
+				}
+			}
+		}
+	}
+	for _, name := range sortedNames(recorded) {
+		if stale[name] && !dependedBy(name, recorded) {
+			return name, false, nil
+		}
+	}
+	for _, name := range sortedNames(desired) {
+		if _, ok := recorded[name]; ok {
+			continue
+		}
+		ready := true
+		for _, dep := range dependsOf(desired[name]) {
+			if _, ok := desired[dep]; !ok {
+				klog.Warningf("resource %s depends on %s which not found, ignore it", name, dep)
+				continue
+			}
+			if _, ok := recorded[dep]; !ok {
+				ready = false
+				break
+			}
+		}
+		if ready {
+			return name, true, nil
+		}
+	}
+	if len(stale) != 0 || len(recorded) != len(desired) {
+		return "", false, fmt.Errorf("dependency loop found in resources")
+	}
+	return "", false, nil
+}
+
+// all resources which res depends on directly or indirectly should be ready
+func dependsReady(cli *directClient, res *directResource, recorded map[string]*directResource) (bool, error) {
+	var (
+		visited = make(map[string]bool)
+		queue   = dependsOf(res)
+	)
+	for len(queue) != 0 {
+		name := queue[0]
+		queue = queue[1:]
+		dep, ok := recorded[name]
+		// drifted one is going to be recreated
+		if !ok || visited[name] || dep.Drifted {
+			continue
+		}
+		visited[name] = true
+		queue = append(queue, dependsOf(dep)...)
+		ok, err := checkReady(cli, name, dep)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func sameResource(a, b *directResource) bool {
+	return a.Type == b.Type &&
+		reflect.DeepEqual(a.Properties, b.Properties) &&
+		reflect.DeepEqual(a.DependsOn, b.DependsOn)
+}
+
+// true if a and b only differ on updatable properties
+func canUpdate(a, b *directResource) bool {
+	handler, ok := directHandlers[a.Type]
+	if !ok || handler.update == nil || a.Type != b.Type ||
+		!reflect.DeepEqual(a.DependsOn, b.DependsOn) {
+		return false
+	}
+	strip := func(props map[string]interface{}) map[string]interface{} {
+		m := make(map[string]interface{}, len(props))
+		for k, v := range props {
+			m[k] = v
+		}
+		for _, k := range handler.updatable {
+			delete(m, k)
+		}
+		return m
+	}
+	return reflect.DeepEqual(strip(a.Properties), strip(b.Properties))
+}
+
+// bandwidth limit is rendered by fip template, but qos is not supported
+func validResources(resources map[string]*directResource) error {
+	for _, name := range sortedNames(resources) {
+		res := resources[name]
+		if res.Type != "OS::Neutron::QoSBandwidthLimitRule" {
+			continue
+		}
+		if directProps(res.Properties).int("max_kbps") != 0 {
+			return fmt.Errorf("bandwidth limit(Mbps) is not supported by %s backend", DirectBackend)
+		}
+	}
+	return nil
+}
+
+func dependedBy(name string, resources map[string]*directResource) bool {
+	for _, res := range resources {
+		for _, dep := range dependsOf(res) {
+			if dep == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// depends_on and resources referenced by get_resource
+func dependsOf(res *directResource) []string {
+	var (
+		deps []string
+		walk func(v interface{})
+	)
+	deps = append(deps, res.DependsOn...)
+	walk = func(v interface{}) {
+		switch t := v.(type) {
+		case map[string]interface{}:
+			if ref, ok := t["get_resource"]; ok && len(t) == 1 {
+				deps = append(deps, toStr(ref))
+				return
+			}
+			for _, val := range t {
+				walk(val)
+			}
+		case []interface{}:
+			for _, val := range t {
+				walk(val)
+			}
+		}
+	}
+	walk(res.Properties)
+	return deps
+}
+
+// replace get_resource by physical id
+func resolve(v interface{}, recorded map[string]*directResource) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		if ref, ok := t["get_resource"]; ok && len(t) == 1 {
+			if res, ok := recorded[toStr(ref)]; ok {
+				return res.PhysicalID
+			}
+			return ""
+		}
+		m := make(map[string]interface{}, len(t))
+		for k, val := range t {
+			m[k] = resolve(val, recorded)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(t))
+		for i, val := range t {
+			l[i] = resolve(val, recorded)
+		}
+		return l
+	default:
+		return v
+	}
+}
+
+// parse rendered template, depends_on could be string or list
+func parseResources(data []byte) (map[string]*directResource, error) {
+	var tpl struct {
+		Resources map[string]struct {
+			Type       string                 `json:"type"`
+			Properties map[string]interface{} `json:"properties"`
+			DependsOn  interface{}            `json:"depends_on"`
+		} `json:"resources"`
+	}
+	bs, err := yaml.YAMLToJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(bs, &tpl)
+	if err != nil {
+		return nil, err
+	}
+	resources := make(map[string]*directResource, len(tpl.Resources))
+	for name, v := range tpl.Resources {
+		res := &directResource{
+			Type:       v.Type,
+			Properties: v.Properties,
+		}
+		switch dep := v.DependsOn.(type) {
+		case string:
+			res.DependsOn = []string{dep}
+		case []interface{}:
+			for _, d := range dep {
+				res.DependsOn = append(res.DependsOn, toStr(d))
+			}
+		}
+		sort.Strings(res.DependsOn)
+		resources[name] = res
+	}
+	return resources, nil
+}
+
+func loadResources(s string) map[string]*directResource {
+	var rs directResources
+	if s != "" {
+		err := json.Unmarshal(util.Str2bytes(s), &rs)
+		if err != nil {
+			klog.Errorf("unmarshal recorded resources failed: %v", err)
+		}
+	}
+	if rs.Resources == nil {
+		rs.Resources = make(map[string]*directResource)
+	}
+	return rs.Resources
+}
+
+func dumpResources(resources map[string]*directResource) string {
+	bs, err := json.Marshal(&directResources{Resources: resources})
+	if err != nil {
+		klog.Errorf("marshal recorded resources failed: %v", err)
+		return ""
+	}
+	return string(bs)
+}
+
+func sortedNames(resources map[string]*directResource) []string {
+	names := make([]string, 0, len(resources))
+	for name := range resources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func isInProgress(err error) bool {
+	if err == errInProgress {
+		return true
+	}
+	// the parent resource is in pending stat
+	_, ok := err.(gophercloud.ErrDefault409)
+	return ok
+}
+
+// service clients are created when used
+type directClient struct {
+	provider *gophercloud.ProviderClient
+
+	compute, network, volume, image, lb *gophercloud.ServiceClient
+}
+
+type newClientFn func(*gophercloud.ProviderClient, gophercloud.EndpointOpts) (*gophercloud.ServiceClient, error)
+
+func (c *directClient) get(cli **gophercloud.ServiceClient, fn newClientFn) (*gophercloud.ServiceClient, error) {
+	if *cli != nil {
+		return *cli, nil
+	}
+	sc, err := fn(c.provider, gophercloud.EndpointOpts{})
+	if err != nil {
+		return nil, err
+	}
+	*cli = sc
+	return sc, nil
+}
+
+func (c *directClient) computeV2() (*gophercloud.ServiceClient, error) {
+	return c.get(&c.compute, openstack.NewComputeV2)
+}
+
+func (c *directClient) networkV2() (*gophercloud.ServiceClient, error) {
+	return c.get(&c.network, openstack.NewNetworkV2)
+}
+
+func (c *directClient) volumeV3() (*gophercloud.ServiceClient, error) {
+	return c.get(&c.volume, openstack.NewBlockStorageV3)
+}
+
+func (c *directClient) imageV2() (*gophercloud.ServiceClient, error) {
+	return c.get(&c.image, openstack.NewImageServiceV2)
+}
+
+func (c *directClient) loadBalancerV2() (*gophercloud.ServiceClient, error) {
+	return c.get(&c.lb, openstack.NewLoadBalancerV2)
+}
+
+func (c *directClient) networkID(name string) (string, error) {
+	if name == "" {
+		return "", fmt.Errorf("network is required")
+	}
+	if isUUID(name) {
+		return name, nil
+	}
+	cli, err := c.networkV2()
+	if err != nil {
+		return "", err
+	}
+	pages, err := networks.List(cli, networks.ListOpts{Name: name}).AllPages()
+	if err != nil {
+		return "", err
+	}
+	lists, err := networks.ExtractNetworks(pages)
+	if err != nil {
+		return "", err
+	}
+	if len(lists) != 1 {
+		return "", fmt.Errorf("found %d networks by name %s", len(lists), name)
+	}
+	return lists[0].ID, nil
+}
+
+func (c *directClient) securityGroupID(name string) (string, error) {
+	if isUUID(name) {
+		return name, nil
+	}
+	cli, err := c.networkV2()
+	if err != nil {
+		return "", err
+	}
+	pages, err := groups.List(cli, groups.ListOpts{Name: name}).AllPages()
+	if err != nil {
+		return "", err
+	}
+	lists, err := groups.ExtractGroups(pages)
+	if err != nil {
+		return "", err
+	}
+	if len(lists) != 1 {
+		return "", fmt.Errorf("found %d security groups by name %s", len(lists), name)
+	}
+	return lists[0].ID, nil
+}
+
+// flavor id is not always uuid, so match both id and name
+func (c *directClient) flavorID(name string) (string, error) {
+	cli, err := c.computeV2()
+	if err != nil {
+		return "", err
+	}
+	pages, err := flavors.ListDetail(cli, nil).AllPages()
+	if err != nil {
+		return "", err
+	}
+	lists, err := flavors.ExtractFlavors(pages)
+	if err != nil {
+		return "", err
+	}
+	for _, v := range lists {
+		if v.ID == name || v.Name == name {
+			return v.ID, nil
+		}
+	}
+	return "", fmt.Errorf("not found flavor %s", name)
+}
+
+func (c *directClient) imageID(name string) (string, error) {
+	if isUUID(name) {
+		return name, nil
+	}
+	cli, err := c.imageV2()
+	if err != nil {
+		return "", err
+	}
+	pages, err := images.List(cli, images.ListOpts{Name: name}).AllPages()
+	if err != nil {
+		return "", err
+	}
+	lists, err := images.ExtractImages(pages)
+	if err != nil {
+		return "", err
+	}
+	if len(lists) != 1 {
+		return "", fmt.Errorf("found %d images by name %s", len(lists), name)
+	}
+	return lists[0].ID, nil
+}
+
+type directProps map[string]interface{}
+
+func (p directProps) str(key string) string {
+	return toStr(p[key])
+}
+
+func (p directProps) int(key string) int {
+	return toInt(p[key])
+}
+
+func (p directProps) list(key string) []interface{} {
+	l, _ := p[key].([]interface{})
+	return l
+}
+
+func (p directProps) strs(key string) []string {
+	var ss []string
+	for _, v := range p.list(key) {
+		ss = append(ss, toStr(v))
+	}
+	return ss
+}
+
+func (p directProps) strMap(key string) map[string]string {
+	m, ok := p[key].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	sm := make(map[string]string, len(m))
+	for k, v := range m {
+		sm[k] = toStr(v)
+	}
+	return sm
+}
+
+func toStr(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return t
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	default:
+		return fmt.Sprint(t)
+	}
+}
+
+func toInt(v interface{}) int {
+	switch t := v.(type) {
+	case float64:
+		return int(t)
+	case int:
+		return t
+	case string:
+		i, _ := strconv.Atoi(t)
+		return i
+	default:
+		return 0
+	}
+}
+
+func toBool(v interface{}) bool {
+	switch t := v.(type) {
+	case bool:
+		return t
+	case string:
+		b, _ := strconv.ParseBool(t)
+		return b
+	default:
+		return false
+	}
+}
+
+func isUUID(s string) bool {
+	return len(s) == 36 && strings.Count(s, "-") == 4
+}
+
+type directHandler struct {
+	// return physical id of resource
+	create func(c *directClient, name string, p directProps) (string, error)
+	delete func(c *directClient, id string) error
+	// nil means the resource is ready once created
+	ready func(c *directClient, id string) (bool, error)
+	// nil means the resource is recreated when properties changed,
+	// otherwise properties in updatable are updated in place
+	update    func(c *directClient, id string, p directProps) error
+	updatable []string
+	// return 404 when removed, used by observe if ready is nil
+	get func(c *directClient, id string) error
+}
+
+// resource types which used by templates
+var directHandlers = map[string]*directHandler{
+	"OS::Cinder::Volume": {
+		create: createVolume,
+		delete: deleteVolume,
+		ready:  volumeReady,
+	},
+	"OS::Neutron::Port": {
+		create: createPort,
+		delete: func(c *directClient, id string) error {
+			cli, err := c.networkV2()
+			if err != nil {
+				return err
+			}
+			return ports.Delete(cli, id).ExtractErr()
+		},
+		get: func(c *directClient, id string) error {
+			cli, err := c.networkV2()
+			if err != nil {
+				return err
+			}
+			return ports.Get(cli, id).Err
+		},
+	},
+	"OS::Nova::Server": {
+		create: createServer,
+		delete: func(c *directClient, id string) error {
+			cli, err := c.computeV2()
+			if err != nil {
+				return err
+			}
+			return servers.Delete(cli, id).ExtractErr()
+		},
+		ready:     serverReady,
+		update:    updateServer,
+		updatable: []string{"name", "metadata"},
+	},
+	"OS::Neutron::LBaaS::LoadBalancer": {
+		create: createLoadBalancer,
+		delete: func(c *directClient, id string) error {
+			cli, err := c.networkV2()
+			if err != nil {
+				return err
+			}
+			return loadbalancers.Delete(cli, id).ExtractErr()
+		},
+		ready: loadBalancerReady,
+	},
+	"OS::Neutron::LBaaS::Listener": {
+		create: createListener,
+		delete: func(c *directClient, id string) error {
+			cli, err := c.networkV2()
+			if err != nil {
+				return err
+			}
+			return listeners.Delete(cli, id).ExtractErr()
+		},
+		get: func(c *directClient, id string) error {
+			cli, err := c.networkV2()
+			if err != nil {
+				return err
+			}
+			return listeners.Get(cli, id).Err
+		},
+	},
+	"OS::Neutron::LBaaS::Pool": {
+		create: createPool,
+		delete: func(c *directClient, id string) error {
+			cli, err := c.networkV2()
+			if err != nil {
+				return err
+			}
+			return pools.Delete(cli, id).ExtractErr()
+		},
+		get: func(c *directClient, id string) error {
+			cli, err := c.networkV2()
+			if err != nil {
+				return err
+			}
+			return pools.Get(cli, id).Err
+		},
+	},
+	"OS::Neutron::LBaaS::HealthMonitor": {
+		create: createMonitor,
+		delete: func(c *directClient, id string) error {
+			cli, err := c.networkV2()
+			if err != nil {
+				return err
+			}
+			return monitors.Delete(cli, id).ExtractErr()
+		},
+		get: func(c *directClient, id string) error {
+			cli, err := c.networkV2()
+			if err != nil {
+				return err
+			}
+			return monitors.Get(cli, id).Err
+		},
+	},
+	"OS::Neutron::LBaaS::PoolMember": {
+		create:    createPoolMember,
+		delete:    deletePoolMember,
+		update:    updatePoolMember,
+		updatable: []string{"weight"},
+		get:       getPoolMember,
+	},
+	"OS::Octavia::LoadBalancer": {
+		create: createOctaviaLoadBalancer,
+		delete: func(c *directClient, id string) error {
+			cli, err := c.loadBalancerV2()
+			if err != nil {
+				return err
+			}
+			return octavialbs.Delete(cli, id, nil).ExtractErr()
+		},
+		ready: octaviaLoadBalancerReady,
+	},
+	"OS::Octavia::Listener": {
+		create: createOctaviaListener,
+		delete: func(c *directClient, id string) error {
+			cli, err := c.loadBalancerV2()
+			if err != nil {
+				return err
+			}
+			return octavialisteners.Delete(cli, id).ExtractErr()
+		},
+		get: func(c *directClient, id string) error {
+			cli, err := c.loadBalancerV2()
+			if err != nil {
+				return err
+			}
+			return octavialisteners.Get(cli, id).Err
+		},
+	},
+	"OS::Octavia::Pool": {
+		create: createOctaviaPool,
+		delete: func(c *directClient, id string) error {
+			cli, err := c.loadBalancerV2()
+			if err != nil {
+				return err
+			}
+			return octaviapools.Delete(cli, id).ExtractErr()
+		},
+		get: func(c *directClient, id string) error {
+			cli, err := c.loadBalancerV2()
+			if err != nil {
+				return err
+			}
+			return octaviapools.Get(cli, id).Err
+		},
+	},
+	"OS::Octavia::HealthMonitor": {
+		create: createOctaviaMonitor,
+		delete: func(c *directClient, id string) error {
+			cli, err := c.loadBalancerV2()
+			if err != nil {
+				return err
+			}
+			return octaviamonitors.Delete(cli, id).ExtractErr()
+		},
+		get: func(c *directClient, id string) error {
+			cli, err := c.loadBalancerV2()
+			if err != nil {
+				return err
+			}
+			return octaviamonitors.Get(cli, id).Err
+		},
+	},
+	"OS::Octavia::PoolMember": {
+		create:    createOctaviaPoolMember,
+		delete:    deleteOctaviaPoolMember,
+		update:    updateOctaviaPoolMember,
+		updatable: []string{"weight"},
+		get:       getOctaviaPoolMember,
+	},
+	"OS::Neutron::FloatingIP": {
+		create: createFloatingIP,
+		delete: func(c *directClient, id string) error {
+			cli, err := c.networkV2()
+			if err != nil {
+				return err
+			}
+			return floatingips.Delete(cli, id).ExtractErr()
+		},
+		get: func(c *directClient, id string) error {
+			cli, err := c.networkV2()
+			if err != nil {
+				return err
+			}
+			return floatingips.Get(cli, id).Err
+		},
+	},
+	"OS::Neutron::FloatingIPAssociation": {
+		create: associateFloatingIP,
+		delete: disassociateFloatingIP,
+		get:    associatedFloatingIP,
+	},
+	// qos is not supported, ignored when no bandwidth limit, see validResources
+	"OS::Neutron::QoSPolicy":             {create: ignoreResource, delete: ignoreDelete},
+	"OS::Neutron::QoSBandwidthLimitRule": {create: ignoreResource, delete: ignoreDelete},
+}
+
+func ignoreResource(c *directClient, name string, p directProps) (string, error) {
+	klog.Warningf("resource %s is not supported by direct backend, ignore it", name)
+	return "", nil
+}
+
+func ignoreDelete(c *directClient, id string) error {
+	return nil
+}
+
+func createVolume(c *directClient, name string, p directProps) (string, error) {
+	cli, err := c.volumeV3()
+	if err != nil {
+		return "", err
+	}
+	opts := volumes.CreateOpts{
+		Name:             name,
+		Size:             p.int("size"),
+		VolumeType:       p.str("volume_type"),
+		AvailabilityZone: p.str("availability_zone"),
+	}
+	if image := p.str("image"); image != "" {
+		opts.ImageID, err = c.imageID(image)
+		if err != nil {
+			return "", err
+		}
+	}
+	v, err := volumes.Create(cli, opts).Extract()
+	if err != nil {
+		return "", err
+	}
+	return v.ID, nil
+}
+
+// volume could be deleted when detached
+func deleteVolume(c *directClient, id string) error {
+	cli, err := c.volumeV3()
+	if err != nil {
+		return err
+	}
+	v, err := volumes.Get(cli, id).Extract()
+	if err != nil {
+		return err
+	}
+	switch v.Status {
+	case "available", "error":
+		return volumes.Delete(cli, id, volumes.DeleteOpts{}).ExtractErr()
+	default:
+		return errInProgress
+	}
+}
+
+func volumeReady(c *directClient, id string) (bool, error) {
+	cli, err := c.volumeV3()
+	if err != nil {
+		return false, err
+	}
+	v, err := volumes.Get(cli, id).Extract()
+	if err != nil {
+		return false, err
+	}
+	switch v.Status {
+	case "available", "in-use":
+		return true, nil
+	case "error":
+		return false, &brokenError{fmt.Errorf("volume %s is in error stat", id)}
+	default:
+		return false, nil
+	}
+}
+
+func createPort(c *directClient, name string, p directProps) (string, error) {
+	cli, err := c.networkV2()
+	if err != nil {
+		return "", err
+	}
+	netid, err := c.networkID(p.str("network"))
+	if err != nil {
+		return "", err
+	}
+	opts := ports.CreateOpts{
+		Name:      name,
+		NetworkID: netid,
+	}
+	var ips []ports.IP
+	for _, v := range p.list("fixed_ips") {
+		fixed := directProps(toMap(v))
+		ips = append(ips, ports.IP{
+			SubnetID:  fixed.str("subnet"),
+			IPAddress: fixed.str("ip_address"),
+		})
+	}
+	if len(ips) != 0 {
+		opts.FixedIPs = ips
+	}
+	if names := p.strs("security_groups"); len(names) != 0 {
+		var ids []string
+		for _, v := range names {
+			id, err := c.securityGroupID(v)
+			if err != nil {
+				return "", err
+			}
+			ids = append(ids, id)
+		}
+		opts.SecurityGroups = &ids
+	}
+	port, err := ports.Create(cli, opts).Extract()
+	if err != nil {
+		return "", err
+	}
+	return port.ID, nil
+}
+
+// security groups are set on port
+func createServer(c *directClient, name string, p directProps) (string, error) {
+	cli, err := c.computeV2()
+	if err != nil {
+		return "", err
+	}
+	flavor, err := c.flavorID(p.str("flavor"))
+	if err != nil {
+		return "", err
+	}
+	opts := servers.CreateOpts{
+		Name:             p.str("name"),
+		FlavorRef:        flavor,
+		Metadata:         p.strMap("metadata"),
+		AdminPass:        p.str("admin_pass"),
+		AvailabilityZone: p.str("availability_zone"),
+	}
+	if data := p.str("user_data"); data != "" {
+		opts.UserData = []byte(data)
+	}
+	var nets []servers.Network
+	for _, v := range p.list("networks") {
+		network := directProps(toMap(v))
+		nets = append(nets, servers.Network{
+			Port: network.str("port"),
+			UUID: network.str("network"),
+		})
+	}
+	opts.Networks = nets
+	var bds []bootfromvolume.BlockDevice
+	for _, v := range p.list("block_device_mapping_v2") {
+		bd := directProps(toMap(v))
+		bds = append(bds, bootfromvolume.BlockDevice{
+			BootIndex:           bd.int("boot_index"),
+			UUID:                bd.str("volume_id"),
+			SourceType:          bootfromvolume.SourceVolume,
+			DestinationType:     bootfromvolume.DestinationVolume,
+			DeleteOnTermination: toBool(bd["delete_on_termination"]),
+		})
+	}
+	var builder servers.CreateOptsBuilder = opts
+	if key := p.str("key_name"); key != "" {
+		builder = keypairs.CreateOptsExt{
+			CreateOptsBuilder: builder,
+			KeyName:           key,
+		}
+	}
+	sv, err := bootfromvolume.Create(cli, bootfromvolume.CreateOptsExt{
+		CreateOptsBuilder: builder,
+		BlockDevice:       bds,
+	}).Extract()
+	if err != nil {
+		return "", err
+	}
+	return sv.ID, nil
+}
+
+// metadata is replaced
+func updateServer(c *directClient, id string, p directProps) error {
+	cli, err := c.computeV2()
+	if err != nil {
+		return err
+	}
+	_, err = servers.Update(cli, id, servers.UpdateOpts{Name: p.str("name")}).Extract()
+	if err != nil {
+		return err
+	}
+	_, err = servers.ResetMetadata(cli, id, servers.MetadataOpts(p.strMap("metadata"))).Extract()
+	return err
+}
+
+func serverReady(c *directClient, id string) (bool, error) {
+	cli, err := c.computeV2()
+	if err != nil {
+		return false, err
+	}
+	sv, err := servers.Get(cli, id).Extract()
+	if err != nil {
+		return false, err
+	}
+	switch sv.Status {
+	case ServerRunStat, ServerStopStat:
+		return true, nil
+	case ServerErrStat:
+		return false, &brokenError{fmt.Errorf("server %s is in %s stat", id, ServerErrStat)}
+	default:
+		return false, nil
+	}
+}
+
+func createLoadBalancer(c *directClient, name string, p directProps) (string, error) {
+	cli, err := c.networkV2()
+	if err != nil {
+		return "", err
+	}
+	lb, err := loadbalancers.Create(cli, loadbalancers.CreateOpts{
+		Name:        p.str("name"),
+		VipSubnetID: p.str("vip_subnet"),
+		VipAddress:  p.str("vip_address"),
+	}).Extract()
+	if err != nil {
+		return "", err
+	}
+	return lb.ID, nil
+}
+
+// children of load balance can be changed only when it is active
+func loadBalancerReady(c *directClient, id string) (bool, error) {
+	cli, err := c.networkV2()
+	if err != nil {
+		return false, err
+	}
+	lb, err := loadbalancers.Get(cli, id).Extract()
+	if err != nil {
+		return false, err
+	}
+	switch lb.ProvisioningStatus {
+	case "ACTIVE":
+		return true, nil
+	case "ERROR":
+		return false, &brokenError{fmt.Errorf("load balance %s is in ERROR stat", id)}
+	default:
+		return false, nil
+	}
+}
+
+func createListener(c *directClient, name string, p directProps) (string, error) {
+	cli, err := c.networkV2()
+	if err != nil {
+		return "", err
+	}
+	opts := listeners.CreateOpts{
+		Name:           name,
+		LoadbalancerID: p.str("loadbalancer"),
+		Protocol:       listeners.Protocol(p.str("protocol")),
+		ProtocolPort:   p.int("protocol_port"),
+
+		DefaultTlsContainerRef: p.str("default_tls_container_ref"),
+		SniContainerRefs:       p.strs("sni_container_refs"),
+	}
+	if _, ok := p["connection_limit"]; ok {
+		limit := p.int("connection_limit")
+		opts.ConnLimit = &limit
+	}
+	if p.int("timeout_client_data") != 0 || p.int("timeout_member_data") != 0 {
+		klog.Warningf("timeout of listener %s is not supported by neutron lbaas, ignore it", name)
+	}
+	listen, err := listeners.Create(cli, opts).Extract()
+	if err != nil {
+		return "", err
+	}
+	return listen.ID, nil
+}
+
+func createPool(c *directClient, name string, p directProps) (string, error) {
+	cli, err := c.networkV2()
+	if err != nil {
+		return "", err
+	}
+	opts := pools.CreateOpts{
+		Name:       name,
+		LBMethod:   pools.LBMethod(p.str("lb_algorithm")),
+		Protocol:   pools.Protocol(p.str("protocol")),
+		ListenerID: p.str("listener"),
+	}
+	if sp, ok := p["session_persistence"].(map[string]interface{}); ok {
+		opts.Persistence = &pools.SessionPersistence{
+			Type:       toStr(sp["type"]),
+			CookieName: toStr(sp["cookie_name"]),
+		}
+	}
+	pool, err := pools.Create(cli, opts).Extract()
+	if err != nil {
+		return "", err
+	}
+	return pool.ID, nil
+}
+
+func createMonitor(c *directClient, name string, p directProps) (string, error) {
+	cli, err := c.networkV2()
+	if err != nil {
+		return "", err
+	}
+	monitor, err := monitors.Create(cli, monitors.CreateOpts{
+		Name:          name,
+		PoolID:        p.str("pool"),
+		Type:          p.str("type"),
+		Delay:         p.int("delay"),
+		Timeout:       p.int("timeout"),
+		MaxRetries:    p.int("max_retries"),
+		URLPath:       p.str("url_path"),
+		ExpectedCodes: p.str("expected_codes"),
+	}).Extract()
+	if err != nil {
+		return "", err
+	}
+	return monitor.ID, nil
+}
+
+// physical id of member is {poolid}/{memberid}
+func createPoolMember(c *directClient, name string, p directProps) (string, error) {
+	cli, err := c.networkV2()
+	if err != nil {
+		return "", err
+	}
+	poolid := p.str("pool")
+	opts := pools.CreateMemberOpts{
+		Name:         name,
+		Address:      p.str("address"),
+		ProtocolPort: p.int("protocol_port"),
+		SubnetID:     p.str("subnet"),
+	}
+	if _, ok := p["weight"]; ok {
+		weight := p.int("weight")
+		opts.Weight = &weight
+	}
+	mem, err := pools.CreateMember(cli, poolid, opts).Extract()
+	if err != nil {
+		return "", err
+	}
+	return poolid + "/" + mem.ID, nil
+}
+
+func updatePoolMember(c *directClient, id string, p directProps) error {
+	cli, err := c.networkV2()
+	if err != nil {
+		return err
+	}
+	ids := strings.SplitN(id, "/", 2)
+	if len(ids) != 2 {
+		return fmt.Errorf("member id %s is invalid", id)
+	}
+	weight := 1
+	if _, ok := p["weight"]; ok {
+		weight = p.int("weight")
+	}
+	return pools.UpdateMember(cli, ids[0], ids[1], pools.UpdateMemberOpts{Weight: &weight}).Err
+}
+
+func getPoolMember(c *directClient, id string) error {
+	cli, err := c.networkV2()
+	if err != nil {
+		return err
+	}
+	ids := strings.SplitN(id, "/", 2)
+	if len(ids) != 2 {
+		return fmt.Errorf("member id %s is invalid", id)
+	}
+	return pools.GetMember(cli, ids[0], ids[1]).Err
+}
+
+func deletePoolMember(c *directClient, id string) error {
+	cli, err := c.networkV2()
+	if err != nil {
+		return err
+	}
+	ids := strings.SplitN(id, "/", 2)
+	if len(ids) != 2 {
+		return fmt.Errorf("member id %s is invalid", id)
+	}
+	return pools.DeleteMember(cli, ids[0], ids[1]).ExtractErr()
+}
+
+func createOctaviaLoadBalancer(c *directClient, name string, p directProps) (string, error) {
+	cli, err := c.loadBalancerV2()
+	if err != nil {
+		return "", err
+	}
+	lb, err := octavialbs.Create(cli, octavialbs.CreateOpts{
+		Name:        p.str("name"),
+		VipSubnetID: p.str("vip_subnet"),
+		VipAddress:  p.str("vip_address"),
+	}).Extract()
+	if err != nil {
+		return "", err
+	}
+	return lb.ID, nil
+}
+
+func octaviaLoadBalancerReady(c *directClient, id string) (bool, error) {
+	cli, err := c.loadBalancerV2()
+	if err != nil {
+		return false, err
+	}
+	lb, err := octavialbs.Get(cli, id).Extract()
+	if err != nil {
+		return false, err
+	}
+	switch lb.ProvisioningStatus {
+	case "ACTIVE":
+		return true, nil
+	case "ERROR":
+		return false, &brokenError{fmt.Errorf("load balance %s is in ERROR stat", id)}
+	default:
+		return false, nil
+	}
+}
+
+func createOctaviaListener(c *directClient, name string, p directProps) (string, error) {
+	cli, err := c.loadBalancerV2()
+	if err != nil {
+		return "", err
+	}
+	opts := octavialisteners.CreateOpts{
+		Name:           name,
+		LoadbalancerID: p.str("loadbalancer"),
+		Protocol:       octavialisteners.Protocol(p.str("protocol")),
+		ProtocolPort:   p.int("protocol_port"),
+
+		DefaultTlsContainerRef: p.str("default_tls_container_ref"),
+		SniContainerRefs:       p.strs("sni_container_refs"),
+	}
+	if _, ok := p["connection_limit"]; ok {
+		limit := p.int("connection_limit")
+		opts.ConnLimit = &limit
+	}
+	if _, ok := p["timeout_client_data"]; ok {
+		timeout := p.int("timeout_client_data")
+		opts.TimeoutClientData = &timeout
+	}
+	if _, ok := p["timeout_member_data"]; ok {
+		timeout := p.int("timeout_member_data")
+		opts.TimeoutMemberData = &timeout
+	}
+	listen, err := octavialisteners.Create(cli, opts).Extract()
+	if err != nil {
+		return "", err
+	}
+	return listen.ID, nil
+}
+
+func createOctaviaPool(c *directClient, name string, p directProps) (string, error) {
+	cli, err := c.loadBalancerV2()
+	if err != nil {
+		return "", err
+	}
+	opts := octaviapools.CreateOpts{
+		Name:       name,
+		LBMethod:   octaviapools.LBMethod(p.str("lb_algorithm")),
+		Protocol:   octaviapools.Protocol(p.str("protocol")),
+		ListenerID: p.str("listener"),
+	}
+	if sp, ok := p["session_persistence"].(map[string]interface{}); ok {
+		opts.Persistence = &octaviapools.SessionPersistence{
+			Type:       toStr(sp["type"]),
+			CookieName: toStr(sp["cookie_name"]),
+		}
+	}
+	pool, err := octaviapools.Create(cli, opts).Extract()
+	if err != nil {
+		return "", err
+	}
+	return pool.ID, nil
+}
+
+func createOctaviaMonitor(c *directClient, name string, p directProps) (string, error) {
+	cli, err := c.loadBalancerV2()
+	if err != nil {
+		return "", err
+	}
+	monitor, err := octaviamonitors.Create(cli, octaviamonitors.CreateOpts{
+		Name:          name,
+		PoolID:        p.str("pool"),
+		Type:          p.str("type"),
+		Delay:         p.int("delay"),
+		Timeout:       p.int("timeout"),
+		MaxRetries:    p.int("max_retries"),
+		URLPath:       p.str("url_path"),
+		ExpectedCodes: p.str("expected_codes"),
+	}).Extract()
+	if err != nil {
+		return "", err
+	}
+	return monitor.ID, nil
+}
+
+// physical id of member is {poolid}/{memberid}
+func createOctaviaPoolMember(c *directClient, name string, p directProps) (string, error) {
+	cli, err := c.loadBalancerV2()
+	if err != nil {
+		return "", err
+	}
+	poolid := p.str("pool")
+	opts := octaviapools.CreateMemberOpts{
+		Name:         name,
+		Address:      p.str("address"),
+		ProtocolPort: p.int("protocol_port"),
+		SubnetID:     p.str("subnet"),
+	}
+	if _, ok := p["weight"]; ok {
+		weight := p.int("weight")
+		opts.Weight = &weight
+	}
+	mem, err := octaviapools.CreateMember(cli, poolid, opts).Extract()
+	if err != nil {
+		return "", err
+	}
+	return poolid + "/" + mem.ID, nil
+}
+
+func updateOctaviaPoolMember(c *directClient, id string, p directProps) error {
+	cli, err := c.loadBalancerV2()
+	if err != nil {
+		return err
+	}
+	ids := strings.SplitN(id, "/", 2)
+	if len(ids) != 2 {
+		return fmt.Errorf("member id %s is invalid", id)
+	}
+	weight := 1
+	if _, ok := p["weight"]; ok {
+		weight = p.int("weight")
+	}
+	return octaviapools.UpdateMember(cli, ids[0], ids[1], octaviapools.UpdateMemberOpts{Weight: &weight}).Err
+}
+
+func getOctaviaPoolMember(c *directClient, id string) error {
+	cli, err := c.loadBalancerV2()
+	if err != nil {
+		return err
+	}
+	ids := strings.SplitN(id, "/", 2)
+	if len(ids) != 2 {
+		return fmt.Errorf("member id %s is invalid", id)
+	}
+	return octaviapools.GetMember(cli, ids[0], ids[1]).Err
+}
+
+func deleteOctaviaPoolMember(c *directClient, id string) error {
+	cli, err := c.loadBalancerV2()
+	if err != nil {
+		return err
+	}
+	ids := strings.SplitN(id, "/", 2)
+	if len(ids) != 2 {
+		return fmt.Errorf("member id %s is invalid", id)
+	}
+	return octaviapools.DeleteMember(cli, ids[0], ids[1]).ExtractErr()
+}
+
+func createFloatingIP(c *directClient, name string, p directProps) (string, error) {
+	cli, err := c.networkV2()
+	if err != nil {
+		return "", err
+	}
+	netid, err := c.networkID(p.str("floating_network"))
+	if err != nil {
+		return "", err
+	}
+	fip, err := floatingips.Create(cli, floatingips.CreateOpts{
+		FloatingNetworkID: netid,
+		PortID:            p.str("port_id"),
+		FixedIP:           p.str("fixed_ip_address"),
+	}).Extract()
+	if err != nil {
+		return "", err
+	}
+	return fip.ID, nil
+}
+
+// physical id of association is the floating ip id
+func associateFloatingIP(c *directClient, name string, p directProps) (string, error) {
+	cli, err := c.networkV2()
+	if err != nil {
+		return "", err
+	}
+	id := p.str("floatingip_id")
+	portid := p.str("port_id")
+	_, err = floatingips.Update(cli, id, floatingips.UpdateOpts{
+		PortID:  &portid,
+		FixedIP: p.str("fixed_ip_address"),
+	}).Extract()
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// association is broken when port is unbound
+func associatedFloatingIP(c *directClient, id string) error {
+	cli, err := c.networkV2()
+	if err != nil {
+		return err
+	}
+	fip, err := floatingips.Get(cli, id).Extract()
+	if err != nil {
+		return err
+	}
+	if fip.PortID == "" {
+		return &brokenError{fmt.Errorf("floating ip %s is not associated", id)}
+	}
+	return nil
+}
+
+func disassociateFloatingIP(c *directClient, id string) error {
+	cli, err := c.networkV2()
+	if err != nil {
+		return err
+	}
+	portid := ""
+	_, err = floatingips.Update(cli, id, floatingips.UpdateOpts{
+		PortID: &portid,
+	}).Extract()
+	return err
+}
+
+func toMap(v interface{}) map[string]interface{} {
+	m, _ := v.(map[string]interface{})
+	return m
+}
@@ -0,0 +1,85 @@
+package controllers
+
+import (
+	"testing"
+)
+
+func TestNextStepUpdatable(t *testing.T) {
+	recorded := map[string]*directResource{
+		"pool": {Type: "OS::Octavia::Pool", Properties: map[string]interface{}{"protocol": "TCP"}, PhysicalID: "p1"},
+		"member": {
+			Type:       "OS::Octavia::PoolMember",
+			Properties: map[string]interface{}{"pool": map[string]interface{}{"get_resource": "pool"}, "address": "10.0.0.1", "weight": float64(1)},
+			PhysicalID: "p1/m1",
+		},
+		"server": {
+			Type:       "OS::Nova::Server",
+			Properties: map[string]interface{}{"flavor": "small", "metadata": map[string]interface{}{"a": "1"}},
+			PhysicalID: "s1",
+		},
+	}
+	desired := map[string]*directResource{
+		"pool": {Type: "OS::Octavia::Pool", Properties: map[string]interface{}{"protocol": "TCP"}},
+		"member": {
+			Type:       "OS::Octavia::PoolMember",
+			Properties: map[string]interface{}{"pool": map[string]interface{}{"get_resource": "pool"}, "address": "10.0.0.1", "weight": float64(5)},
+		},
+		"server": {
+			Type:       "OS::Nova::Server",
+			Properties: map[string]interface{}{"flavor": "small", "metadata": map[string]interface{}{"a": "2"}},
+		},
+	}
+	name, _, err := nextStep(desired, recorded)
+	if err != nil || name != "" {
+		t.Errorf("weight and metadata should be updated in place, but got %q %v", name, err)
+	}
+
+	desired["server"].Properties["flavor"] = "large"
+	name, create, err := nextStep(desired, recorded)
+	if err != nil || name != "server" || create {
+		t.Errorf("server should be deleted when flavor changed, but got %q %v %v", name, create, err)
+	}
+
+	desired["pool"].Properties["protocol"] = "HTTP"
+	name, _, _ = nextStep(desired, recorded)
+	if name != "member" {
+		t.Errorf("member should be deleted before stale pool, but got %q", name)
+	}
+}
+
+func TestNextStepDrifted(t *testing.T) {
+	newRes := func(typ string, deps ...string) *directResource {
+		return &directResource{Type: typ, Properties: map[string]interface{}{"name": typ}, DependsOn: deps}
+	}
+	desired := map[string]*directResource{
+		"lb":       newRes("OS::Octavia::LoadBalancer"),
+		"listener": newRes("OS::Octavia::Listener", "lb"),
+	}
+	recorded := map[string]*directResource{
+		"lb":       newRes("OS::Octavia::LoadBalancer"),
+		"listener": newRes("OS::Octavia::Listener", "lb"),
+	}
+	if name, _, _ := nextStep(desired, recorded); name != "" {
+		t.Errorf("nothing should be done, but got %q", name)
+	}
+	// removed load balance is recreated with its listener
+	recorded["lb"].Drifted = true
+	name, create, _ := nextStep(desired, recorded)
+	if name != "listener" || create {
+		t.Errorf("listener of drifted load balance should be deleted first, but got %q %v", name, create)
+	}
+}
+
+func TestValidResources(t *testing.T) {
+	resources := map[string]*directResource{
+		"qos":  {Type: "OS::Neutron::QoSPolicy"},
+		"rule": {Type: "OS::Neutron::QoSBandwidthLimitRule", Properties: map[string]interface{}{"max_kbps": float64(0)}},
+	}
+	if err := validResources(resources); err != nil {
+		t.Errorf("qos without bandwidth limit should be ignored, but got %v", err)
+	}
+	resources["rule"].Properties["max_kbps"] = float64(1024)
+	if err := validResources(resources); err == nil {
+		t.Errorf("bandwidth limit should be rejected by direct backend")
+	}
+}
@@ -0,0 +1,20 @@
+package controllers
+
+// reasons of events which recorded on virtual machine
+const (
+	ReasonStackCreated      = "StackCreated"
+	ReasonStackCreateFailed = "StackCreateFailed"
+	ReasonStackUpdated      = "StackUpdated"
+	ReasonStackUpdateFailed = "StackUpdateFailed"
+	ReasonStackFailed       = "StackFailed"
+	ReasonMemberError       = "MemberError"
+	ReasonLbDeleted         = "LoadBalanceDeleted"
+	ReasonFipUnbind         = "FloatingIpUnbind"
+	ReasonCertStored        = "CertificateStored"
+	ReasonExposeConflict    = "ExposeConflict"
+	ReasonResourceDrifted   = "ResourceDrifted"
+
+	// reasons recorded on LoadBalancer service
+	ReasonInvalidService = "InvalidService"
+	ReasonVmConflict     = "VirtualMachineConflict"
+)
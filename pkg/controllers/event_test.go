@@ -0,0 +1,87 @@
+package controllers
+
+import (
+	"strings"
+	"testing"
+
+	vmv1 "easystack.io/vm-operator/pkg/api/v1"
+	"easystack.io/vm-operator/pkg/fake"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// events which recorded with reason
+func recorded(recorder *record.FakeRecorder, reason string) []string {
+	var events []string
+	for {
+		select {
+		case ev := <-recorder.Events:
+			if strings.Contains(ev, " "+reason+" ") {
+				events = append(events, ev)
+			}
+		default:
+			return events
+		}
+	}
+}
+
+func TestStackFailedEvent(t *testing.T) {
+	op, server, stop := newFakeServer(t, func(op *fake.OpenStack) {
+		op.FailNewStacks("Resource CREATE failed: quota exceeded")
+	})
+	defer stop()
+	recorder := server.recorder.(*record.FakeRecorder)
+
+	vm := &vmv1.VirtualMachine{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      "event",
+		},
+		Spec: vmv1.VirtualMachineSpec{
+			Auth: &vmv1.AuthSpec{
+				ProjectID: fake.ProjectID,
+				Token:     fake.Token,
+			},
+			Server: &vmv1.ServerSpec{
+				Replicas:  1,
+				BootImage: "image",
+				BootVolume: &vmv1.VolumeSpec{
+					VolumeSize: 10,
+				},
+				Flavor: "flavor",
+				Subnet: &vmv1.SubnetSpec{
+					NetworkName: "private",
+					SubnetId:    "subnet",
+				},
+			},
+			AssemblyPhase: vmv1.Creating,
+		},
+	}
+	defaultVm(vm)
+
+	vm = processUntil(t, server, vm, func(vm *vmv1.VirtualMachine) bool {
+		return vm.Status.Phase == PhaseFailed
+	})
+	events := recorded(recorder, ReasonStackFailed)
+	want := corev1.EventTypeWarning + " " + ReasonStackFailed + " stack " + vm.Status.VmStatus.StackName +
+		" CREATE_FAILED: Resource CREATE failed: quota exceeded"
+	if len(events) != 1 || events[0] != want {
+		t.Errorf("event should be %q, but %v", want, events)
+	}
+
+	// failed again by update
+	op.FailNewStacks("")
+	op.FailStack(vm.Status.VmStatus.StackName, "Resource UPDATE failed: no valid host")
+	vm.Spec.Server.Replicas = 2
+	vm = processUntil(t, server, vm, func(vm *vmv1.VirtualMachine) bool {
+		events = append(events, recorded(recorder, ReasonStackFailed)...)
+		return len(events) == 2
+	})
+	want = corev1.EventTypeWarning + " " + ReasonStackFailed + " stack " + vm.Status.VmStatus.StackName +
+		" UPDATE_FAILED: Resource UPDATE failed: no valid host"
+	if events[1] != want {
+		t.Errorf("event should be %q, but %q", want, events[1])
+	}
+}
@@ -1,7 +1,6 @@
 package controllers
 
 import (
-	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -12,13 +11,16 @@ import (
 
 	vmv1 "easystack.io/vm-operator/pkg/api/v1"
 	"easystack.io/vm-operator/pkg/manage"
+	"easystack.io/vm-operator/pkg/metrics"
 	"easystack.io/vm-operator/pkg/template"
 	"easystack.io/vm-operator/pkg/util"
 	"github.com/gophercloud/gophercloud"
 	"github.com/gophercloud/gophercloud/openstack"
 	"github.com/gophercloud/gophercloud/openstack/orchestration/v1/stacks"
 	"github.com/gophercloud/gophercloud/pagination"
-	"github.com/tidwall/gjson"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	klog "k8s.io/klog/v2"
 	"sigs.k8s.io/yaml"
 )
@@ -46,6 +48,8 @@ type StackResult struct {
 
 	//had sync or not
 	sync bool
+	// virtual machine which the stack belong to
+	owner types.NamespacedName
 }
 
 type Reorderfn func(spec *vmv1.VirtualMachineSpec, stat *vmv1.ResourceStatus)
@@ -69,13 +73,17 @@ func (s *StackResult) DeepCopy() *StackResult {
 	tmp.Name = s.Name
 	tmp.Status = s.Status
 	tmp.StatusReason = s.StatusReason
+	tmp.owner = s.owner
 	return tmp
 }
 
 type Heat struct {
 	engine *template.Template
 
-	opmgr *manage.OpenMgr
+	opmgr    *manage.OpenMgr
+	k8smgr   *manage.K8sMgr
+	notify   *notifier
+	recorder record.EventRecorder
 	// stackid - stackResutl
 	stacks map[string]*StackResult
 
@@ -89,7 +97,7 @@ type Heat struct {
 	reorderfuncs map[template.Kind]Reorderfn
 }
 
-func NewHeat(engine *template.Template, tmpdir string, opmgr *manage.OpenMgr) *Heat {
+func NewHeat(engine *template.Template, tmpdir string, opmgr *manage.OpenMgr, k8smgr *manage.K8sMgr, notify *notifier, recorder record.EventRecorder) *Heat {
 	opt, err := openstack.AuthOptionsFromEnv()
 	if err != nil {
 		panic(err)
@@ -98,6 +106,9 @@ func NewHeat(engine *template.Template, tmpdir string, opmgr *manage.OpenMgr) *H
 		mu:           sync.RWMutex{},
 		engine:       engine,
 		opmgr:        opmgr,
+		k8smgr:       k8smgr,
+		notify:       notify,
+		recorder:     recorder,
 		tmpdir:       tmpdir,
 		endpoint:     opt.IdentityEndpoint,
 		stacks:       make(map[string]*StackResult),
@@ -110,12 +121,12 @@ func NewHeat(engine *template.Template, tmpdir string, opmgr *manage.OpenMgr) *H
 
 //check stack had complete
 // means stack is failed or successed
-func (h *Heat) isSynced(id string) bool {
-	h.mu.RLock()
-	defer h.mu.RUnlock()
+func (h *Heat) isSynced(id string, owner types.NamespacedName) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
 	v, ok := h.stacks[id]
 	if !ok {
-		h.stacks[id] = &StackResult{}
+		h.stacks[id] = &StackResult{owner: owner}
 		return false
 	}
 	if !v.sync {
@@ -127,14 +138,15 @@ func (h *Heat) isSynced(id string) bool {
 	return v.sync
 }
 
-func (h *Heat) listenById(id string) {
-	h.mu.RLock()
-	defer h.mu.RUnlock()
+func (h *Heat) listenById(id string, owner types.NamespacedName) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
 	v, ok := h.stacks[id]
 	if !ok {
-		h.stacks[id] = &StackResult{}
+		h.stacks[id] = &StackResult{owner: owner}
 	} else {
 		v.sync = false
+		v.owner = owner
 	}
 }
 
@@ -166,18 +178,24 @@ func (h *Heat) addStore(page pagination.Page) {
 		klog.Errorf("stacks extract page failed:%v", err)
 		return
 	}
+	var owners []types.NamespacedName
 	h.mu.Lock()
-	defer h.mu.Unlock()
 	for _, stack := range lists {
 		v, ok := h.stacks[stack.ID]
 		if ok {
 			klog.V(3).Infof("callback update stack:%v", stack)
+			if !v.sync || v.Status != stack.Status {
+				owners = append(owners, v.owner)
+			}
 			v.DeepCopyFrom(&stack)
 		}
 	}
 	for _, v := range h.stacks {
 		v.sync = true
 	}
+	metrics.SetCacheSize(manage.Heat.String(), len(h.stacks))
+	h.mu.Unlock()
+	h.notify.notify(owners...)
 	return
 }
 
@@ -193,39 +211,63 @@ func (h *Heat) RegistReOrderFunc(kind template.Kind, fn Reorderfn) {
 	h.reorderfuncs[kind] = fn
 }
 
+func (h *Heat) Name() string {
+	return HeatBackend
+}
+
+// Ensure create or update stack
+func (h *Heat) Ensure(kind manage.OpResource, vm *vmv1.VirtualMachine) error {
+	return h.Process(kind, vm)
+}
+
+// Observe stat from stack cache
+func (h *Heat) Observe(kind manage.OpResource, vm *vmv1.VirtualMachine) error {
+	return h.update(statOf(kind, vm))
+}
+
+func (h *Heat) Delete(kind manage.OpResource, vm *vmv1.VirtualMachine) error {
+	stat := statOf(kind, vm)
+	if stat == nil {
+		return nil
+	}
+	stat.Stat = string(vmv1.Deleting)
+	return h.DeleteStack(stat, vm.Spec.Auth, vm.Namespace)
+}
+
 // the stat on vm must not be nil
 func (h *Heat) Process(kind manage.OpResource, vm *vmv1.VirtualMachine) (reterr error) {
 	var (
 		stat *vmv1.ResourceStatus
-		tpl  template.Kind
 		isdo bool
 	)
 	if vm == nil {
 		return fmt.Errorf("vm param is nil")
 	}
-
-	switch kind {
-	case manage.Vm:
-		stat = vm.Status.VmStatus
-		tpl = template.Vm
-	case manage.Fip:
-		stat = vm.Status.PubStatus
-		tpl = template.Fip
-	case manage.Lb:
-		stat = vm.Status.NetStatus
-		tpl = template.Lb
-	default:
-		return fmt.Errorf("not found openstack resource %v", kind)
+	tpl, err := templateOf(kind)
+	if err != nil {
+		return err
 	}
+	stat = statOf(kind, vm)
 	if vm.DeletionTimestamp != nil {
 		stat.Stat = string(vmv1.Deleting)
-		err := h.DeleteStack(stat)
+		err := h.DeleteStack(stat, vm.Spec.Auth, vm.Namespace)
 		if err != nil {
 			klog.Errorf("delete stack failed:%v", err)
 		}
 		return err
 	}
-	if stat.StackID != "" && !h.isSynced(stat.StackID) {
+	prestat := stat.Stat
+	defer func() {
+		if stat.Stat != Failed || prestat == Failed {
+			return
+		}
+		msg := fmt.Sprintf("stack %s failed", stat.StackName)
+		if v := h.GetStack(stat.StackID); v != nil {
+			msg = fmt.Sprintf("stack %s %s: %s", stat.StackName, v.Status, strings.TrimSpace(v.StatusReason))
+		}
+		h.recorder.Event(vm, corev1.EventTypeWarning, ReasonStackFailed, msg)
+	}()
+	if stat.StackID != "" && !h.isSynced(stat.StackID, ownerOf(vm)) {
 		klog.V(2).Infof("stack %s is in Progress Stat, skip update", stat.StackName)
 		return h.update(stat)
 	}
@@ -240,59 +282,72 @@ func (h *Heat) Process(kind manage.OpResource, vm *vmv1.VirtualMachine) (reterr
 		}
 	}()
 	if stat.HashId == 0 {
-		err = h.createStack(fpath, vm.Spec.Auth, stat)
+		err = h.createStack(fpath, vm.Spec.Auth, vm.Namespace, stat)
 		if err != nil {
 			klog.Errorf("Creat stack failed:%v", err)
+			h.recorder.Eventf(vm, corev1.EventTypeWarning, ReasonStackCreateFailed, "create %s stack %s failed: %v", kind, stat.StackName, err)
 			if stat.StackID == "" {
 				// stackname should remove, will generate new one next
 				stat.StackName = ""
 				return h.update(stat)
 			}
 		}
+		h.recorder.Eventf(vm, corev1.EventTypeNormal, ReasonStackCreated, "created %s stack %s", kind, stat.StackName)
 		stat.HashId = hashid
 		stat.Stat = string(vmv1.Creating)
-		h.listenById(stat.StackID)
+		h.listenById(stat.StackID, ownerOf(vm))
 		isdo = true
 	}
 	if stat.HashId != hashid {
-		// use drone user to update stack, the stack ownership is also who created
-		err = h.updateStack(fpath, stat, true)
+		// update with the same identity which created the stack
+		err = h.updateStack(fpath, vm.Spec.Auth, vm.Namespace, stat, true)
 		if err != nil {
 			klog.Errorf("update stack failed:%v", err)
+			h.recorder.Eventf(vm, corev1.EventTypeWarning, ReasonStackUpdateFailed, "update %s stack %s failed: %v", kind, stat.StackName, err)
+		} else {
+			h.recorder.Eventf(vm, corev1.EventTypeNormal, ReasonStackUpdated, "updated %s stack %s", kind, stat.StackName)
 		}
 		stat.HashId = hashid
 		stat.Stat = string(vmv1.Updating)
-		h.listenById(stat.StackID)
+		h.listenById(stat.StackID, ownerOf(vm))
 		isdo = true
 	}
 	rerr := h.update(stat)
 	if isdo == false {
 		if rerr != nil && strings.Contains(rerr.Error(), "Create timed out") {
-			err = h.updateStack(fpath, stat, false)
+			err = h.updateStack(fpath, vm.Spec.Auth, vm.Namespace, stat, false)
 			if err != nil {
 				klog.Error("update after create timeout failed: %v", err)
 			}
 			stat.Stat = string(vmv1.Creating)
-			h.listenById(stat.StackID)
+			h.listenById(stat.StackID, ownerOf(vm))
 		}
 	}
 	return rerr
 }
 
-// generate template file
-// 1. update stat.Template if hashid not equal
-func (h *Heat) generateTmpFile(tpl template.Kind, spec *vmv1.VirtualMachineSpec, stat *vmv1.ResourceStatus) (fpath string, hashid int64, reterr error) {
+// render template of kind, the spec is reordered by stat first
+func (h *Heat) render(tpl template.Kind, spec *vmv1.VirtualMachineSpec, stat *vmv1.ResourceStatus) ([]byte, error) {
 	// update spec by template
 	fn, ok := h.reorderfuncs[tpl]
 	if ok {
 		fn(spec, stat)
 	}
 
-	data, reterr := json.Marshal(spec)
+	params, err := template.Params(spec)
+	if err != nil {
+		return nil, err
+	}
+	return h.engine.RenderByName(tpl, params)
+}
+
+// generate template file
+// 1. update stat.Template if hashid not equal
+func (h *Heat) generateTmpFile(tpl template.Kind, spec *vmv1.VirtualMachineSpec, stat *vmv1.ResourceStatus) (fpath string, hashid int64, reterr error) {
+	data, reterr := h.render(tpl, spec, stat)
 	if reterr != nil {
 		return
 	}
-	params := template.Parse(gjson.ParseBytes(data))
 	tmpfile, reterr := ioutil.TempFile(h.tmpdir, tmpPattern)
 	if reterr != nil {
 		return
@@ -305,7 +360,6 @@ func (h *Heat) generateTmpFile(tpl template.Kind, spec *vmv1.VirtualMachineSpec,
 		}
 	}()
 
-	data, reterr = h.engine.RenderByName(tpl, params)
 	_, reterr = tmpfile.Write(data)
 	if reterr != nil {
 		return
@@ -320,23 +374,94 @@ func (h *Heat) generateTmpFile(tpl template.Kind, spec *vmv1.VirtualMachineSpec,
 }
 
 // TODO if resource on stack yaml can set project_id, it's not needed
-func (h *Heat) getClient(as *vmv1.AuthSpec) (*gophercloud.ServiceClient, error) {
+func (h *Heat) getClient(as *vmv1.AuthSpec, namespace string) (*gophercloud.ServiceClient, error) {
+	opts, err := h.authOptions(as, namespace)
+	if err != nil {
+		return nil, err
+	}
+	cli, err := openstack.AuthenticatedClient(opts)
+	if err != nil {
+		return nil, err
+	}
+	return openstack.NewOrchestrationV1(cli, gophercloud.EndpointOpts{})
+}
+
+// credentials of auth may be unusable when deleting, such as the secret is
+// removed with namespace or token is expired, then the operator is used,
+// otherwise the finalizer is never removed
+func (h *Heat) deleteProvider(as *vmv1.AuthSpec, namespace string) *gophercloud.ProviderClient {
+	var (
+		provider *gophercloud.ProviderClient
+		err      = fmt.Errorf("not found auth info")
+	)
+	if as != nil {
+		var opts gophercloud.AuthOptions
+		opts, err = h.authOptions(as, namespace)
+		if err == nil {
+			provider, err = openstack.AuthenticatedClient(opts)
+		}
+	}
+	if err == nil {
+		return provider
+	}
+	klog.Warningf("credentials of auth in %s can not be used, delete by operator: %v", namespace, err)
+	h.opmgr.WrapClient(func(client *gophercloud.ProviderClient) {
+		provider = client
+	})
+	return provider
+}
+
+// the secret is fetched every time, so it can be rotated
+// keys in secret are same with openrc, and OS_TOKEN is first used
+func (h *Heat) authOptions(as *vmv1.AuthSpec, namespace string) (gophercloud.AuthOptions, error) {
 	opts := gophercloud.AuthOptions{
 		IdentityEndpoint: h.endpoint,
 		TokenID:          as.Token,
 		TenantID:         as.ProjectID,
 	}
-	cli, err := openstack.AuthenticatedClient(opts)
+	if as.SecretRef == nil {
+		return opts, nil
+	}
+	datas, err := h.k8smgr.GetSecret(namespace, as.SecretRef.Name)
 	if err != nil {
-		return nil, err
+		return opts, err
+	}
+	if v := datas["OS_AUTH_URL"]; v != "" {
+		opts.IdentityEndpoint = v
+	}
+	opts.TenantID = datas["OS_PROJECT_ID"]
+	opts.TenantName = datas["OS_PROJECT_NAME"]
+	switch {
+	case datas["OS_TOKEN"] != "":
+		opts.TokenID = datas["OS_TOKEN"]
+	case datas["OS_APPLICATION_CREDENTIAL_SECRET"] != "":
+		opts.TokenID = ""
+		opts.ApplicationCredentialID = datas["OS_APPLICATION_CREDENTIAL_ID"]
+		opts.ApplicationCredentialName = datas["OS_APPLICATION_CREDENTIAL_NAME"]
+		opts.ApplicationCredentialSecret = datas["OS_APPLICATION_CREDENTIAL_SECRET"]
+		opts.Username = datas["OS_USERNAME"]
+		opts.DomainName = datas["OS_DOMAIN_NAME"]
+		// scope is determined by application credential
+		opts.TenantID = ""
+		opts.TenantName = ""
+	case datas["OS_USERNAME"] != "" && datas["OS_PASSWORD"] != "":
+		opts.TokenID = ""
+		opts.Username = datas["OS_USERNAME"]
+		opts.Password = datas["OS_PASSWORD"]
+		opts.DomainName = datas["OS_DOMAIN_NAME"]
+		if opts.DomainName == "" {
+			opts.DomainName = datas["OS_USER_DOMAIN_NAME"]
+		}
+	default:
+		return opts, fmt.Errorf("not found token, application credential or password in secret %s/%s", namespace, as.SecretRef.Name)
 	}
-	return openstack.NewOrchestrationV1(cli, gophercloud.EndpointOpts{})
+	return opts, nil
 }
 
 // NOTE: stat must be not nil!
 // 1. update stat.StackId
-func (h *Heat) createStack(fpath string, auth *vmv1.AuthSpec, stat *vmv1.ResourceStatus) error {
-	cli, err := h.getClient(auth)
+func (h *Heat) createStack(fpath string, auth *vmv1.AuthSpec, namespace string, stat *vmv1.ResourceStatus) error {
+	cli, err := h.getClient(auth, namespace)
 	if err != nil {
 		return err
 	}
@@ -353,6 +478,7 @@ func (h *Heat) createStack(fpath string, auth *vmv1.AuthSpec, stat *vmv1.Resourc
 	}
 	rst := stacks.Create(cli, ctOpts)
 	result, err := rst.Extract()
+	metrics.IncStackOperation(metrics.OpCreate, err)
 	if result != nil {
 		stat.StackID = result.ID
 		return nil
@@ -364,10 +490,7 @@ func (h *Heat) createStack(fpath string, auth *vmv1.AuthSpec, stat *vmv1.Resourc
 	return err
 }
 
-func (h *Heat) DeleteStack(stat *vmv1.ResourceStatus) error {
-	var (
-		err error
-	)
+func (h *Heat) DeleteStack(stat *vmv1.ResourceStatus, auth *vmv1.AuthSpec, namespace string) error {
 	defer func() {
 		h.mu.Lock()
 		defer h.mu.Unlock()
@@ -380,25 +503,23 @@ func (h *Heat) DeleteStack(stat *vmv1.ResourceStatus) error {
 		return nil
 	}
 	klog.V(2).Infof("start delete stack name(%v) id(%v)", stat.StackName, stat.StackID)
-	h.opmgr.WrapClient(func(client *gophercloud.ProviderClient) {
-		heatcli, rerr := openstack.NewOrchestrationV1(client, gophercloud.EndpointOpts{})
-		if rerr != nil {
-			err = rerr
-			return
-		}
-		err = stacks.Delete(heatcli, stat.StackName, stat.StackID).ExtractErr()
-		if err != nil {
-			klog.Errorf("failed delete stack: %v, err type: %v", err, reflect.TypeOf(err))
-			if _, ok := err.(gophercloud.ErrDefault404); ok {
-				err = nil
-			}
-		}
-		if err == nil {
-			stat.StackID = ""
-			stat.StackName = ""
-			klog.V(2).Infof("success delete stack")
+	heatcli, err := openstack.NewOrchestrationV1(h.deleteProvider(auth, namespace), gophercloud.EndpointOpts{})
+	if err != nil {
+		return err
+	}
+	err = stacks.Delete(heatcli, stat.StackName, stat.StackID).ExtractErr()
+	metrics.IncStackOperation(metrics.OpDelete, err)
+	if err != nil {
+		klog.Errorf("failed delete stack: %v, err type: %v", err, reflect.TypeOf(err))
+		if _, ok := err.(gophercloud.ErrDefault404); ok {
+			err = nil
 		}
-	})
+	}
+	if err == nil {
+		stat.StackID = ""
+		stat.StackName = ""
+		klog.V(2).Infof("success delete stack")
+	}
 	return err
 }
 
@@ -412,16 +533,11 @@ func (h *Heat) GetStack(id string) *StackResult {
 	return v.DeepCopy()
 }
 
-func (h *Heat) updateStack(fpath string, stat *vmv1.ResourceStatus, patch bool) error {
+func (h *Heat) updateStack(fpath string, auth *vmv1.AuthSpec, namespace string, stat *vmv1.ResourceStatus, patch bool) error {
 	var (
-		heatcli *gophercloud.ServiceClient
-		err     error
-		rst     stacks.UpdateResult
+		rst stacks.UpdateResult
 	)
-	h.opmgr.WrapClient(func(cli *gophercloud.ProviderClient) {
-		heatcli, err = openstack.NewOrchestrationV1(cli, gophercloud.EndpointOpts{})
-	})
-
+	heatcli, err := h.getClient(auth, namespace)
 	if err != nil {
 		return err
 	}
@@ -442,6 +558,7 @@ func (h *Heat) updateStack(fpath string, stat *vmv1.ResourceStatus, patch bool)
 	}
 
 	err = rst.ExtractErr()
+	metrics.IncStackOperation(metrics.OpUpdate, err)
 	if err != nil {
 		if _, ok := err.(gophercloud.ErrDefault409); ok {
 			err = nil
@@ -0,0 +1,110 @@
+package controllers
+
+import (
+	"encoding/base64"
+	"reflect"
+	"testing"
+
+	vmv1 "easystack.io/vm-operator/pkg/api/v1"
+	"easystack.io/vm-operator/pkg/manage"
+	"github.com/gophercloud/gophercloud"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+func newSecret(name string, datas map[string]string) *unstructured.Unstructured {
+	encoded := make(map[string]interface{}, len(datas))
+	for k, v := range datas {
+		encoded[k] = base64.StdEncoding.EncodeToString([]byte(v))
+	}
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Secret",
+		"metadata":   map[string]interface{}{"namespace": "test", "name": name},
+		"data":       encoded,
+	}}
+}
+
+func TestAuthOptions(t *testing.T) {
+	const endpoint = "http://keystone:5000/v3"
+	client := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(),
+		newSecret("token", map[string]string{"OS_TOKEN": "t1", "OS_PROJECT_ID": "p1", "OS_AUTH_URL": "http://other:5000/v3"}),
+		newSecret("token-name", map[string]string{"OS_TOKEN": "t1", "OS_PROJECT_NAME": "demo"}),
+		newSecret("appcred", map[string]string{
+			"OS_APPLICATION_CREDENTIAL_ID":     "a1",
+			"OS_APPLICATION_CREDENTIAL_SECRET": "s1",
+			"OS_PROJECT_ID":                    "p1",
+		}),
+		newSecret("password", map[string]string{
+			"OS_USERNAME":         "admin",
+			"OS_PASSWORD":         "pw",
+			"OS_USER_DOMAIN_NAME": "Default",
+			"OS_PROJECT_NAME":     "demo",
+		}),
+		newSecret("no-password", map[string]string{"OS_USERNAME": "admin", "OS_PROJECT_ID": "p1"}),
+		newSecret("empty", nil),
+	)
+	h := &Heat{endpoint: endpoint, k8smgr: manage.NewK8sMgr(client, nil)}
+	ref := func(name string) *vmv1.AuthSpec {
+		return &vmv1.AuthSpec{Token: "spec", ProjectID: "spec", SecretRef: &vmv1.SecretRef{Name: name}}
+	}
+
+	tests := []struct {
+		name    string
+		auth    *vmv1.AuthSpec
+		want    gophercloud.AuthOptions
+		wantErr bool
+	}{
+		{
+			name: "token in spec",
+			auth: &vmv1.AuthSpec{Token: "spec", ProjectID: "p0"},
+			want: gophercloud.AuthOptions{IdentityEndpoint: endpoint, TokenID: "spec", TenantID: "p0"},
+		},
+		{
+			name: "token scoped by project id",
+			auth: ref("token"),
+			want: gophercloud.AuthOptions{IdentityEndpoint: "http://other:5000/v3", TokenID: "t1", TenantID: "p1"},
+		},
+		{
+			name: "token scoped by project name",
+			auth: ref("token-name"),
+			want: gophercloud.AuthOptions{IdentityEndpoint: endpoint, TokenID: "t1", TenantName: "demo"},
+		},
+		{
+			name: "application credential is not scoped",
+			auth: ref("appcred"),
+			want: gophercloud.AuthOptions{IdentityEndpoint: endpoint, ApplicationCredentialID: "a1", ApplicationCredentialSecret: "s1"},
+		},
+		{
+			name: "password with user domain",
+			auth: ref("password"),
+			want: gophercloud.AuthOptions{IdentityEndpoint: endpoint, Username: "admin", Password: "pw", DomainName: "Default", TenantName: "demo"},
+		},
+		{
+			name:    "password is missing",
+			auth:    ref("no-password"),
+			wantErr: true,
+		},
+		{
+			name:    "no credential",
+			auth:    ref("empty"),
+			wantErr: true,
+		},
+		{
+			name:    "secret not found",
+			auth:    ref("removed"),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		opts, err := h.authOptions(tt.auth, "test")
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error should be %v, but %v", tt.name, tt.wantErr, err)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(opts, tt.want) {
+			t.Errorf("%s: options should be %+v, but %+v", tt.name, tt.want, opts)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package controllers
+
+import (
+	"crypto/tls"
+	"encoding/pem"
+	"fmt"
+	"path"
+
+	vmv1 "easystack.io/vm-operator/pkg/api/v1"
+	"easystack.io/vm-operator/pkg/util"
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack"
+	"github.com/gophercloud/gophercloud/openstack/keymanager/v1/containers"
+	"github.com/gophercloud/gophercloud/openstack/keymanager/v1/secrets"
+	klog "k8s.io/klog/v2"
+)
+
+const (
+	// keys of kubernetes.io/tls secret
+	tlsCertKey = "tls.crt"
+	tlsKeyKey  = "tls.key"
+)
+
+// certificate of listener, the intermediates are split from chain of certificate
+type tlsCert struct {
+	Certificate   string
+	PrivateKey    string
+	Intermediates string
+}
+
+// the hash changed when certificate is rotated
+func (c *tlsCert) hash() int64 {
+	return util.Hashid(util.Str2bytes(c.Certificate + c.PrivateKey + c.Intermediates))
+}
+
+// KeyStore save certificates which are referenced by TERMINATED_HTTPS listener
+type KeyStore interface {
+	// Store save certificate by name in project of auth, and return the reference.
+	// the same reference is returned when name is stored before
+	Store(as *vmv1.AuthSpec, namespace, name string, cert *tlsCert) (string, error)
+	// Remove the certificate by reference in project of auth
+	Remove(as *vmv1.AuthSpec, namespace, ref string) error
+}
+
+// parse kubernetes.io/tls secret data
+func parseTlsCert(datas map[string]string) (*tlsCert, error) {
+	var (
+		crt   = datas[tlsCertKey]
+		key   = datas[tlsKeyKey]
+		cert  = &tlsCert{PrivateKey: key}
+		block *pem.Block
+		rest  = []byte(crt)
+	)
+	if crt == "" || key == "" {
+		return nil, fmt.Errorf("%s and %s are required", tlsCertKey, tlsKeyKey)
+	}
+	_, err := tls.X509KeyPair([]byte(crt), []byte(key))
+	if err != nil {
+		return nil, err
+	}
+	for {
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		if cert.Certificate == "" {
+			cert.Certificate = string(pem.EncodeToMemory(block))
+		} else {
+			cert.Intermediates += string(pem.EncodeToMemory(block))
+		}
+	}
+	return cert, nil
+}
+
+// Barbican store certificate as barbican certificate container
+type Barbican struct {
+	heat *Heat
+}
+
+// heat is used to fetch auth
+func NewBarbican(heat *Heat) *Barbican {
+	return &Barbican{
+		heat: heat,
+	}
+}
+
+// containers are owned by project which created them, so the same
+// auth is used to store and remove
+func (b *Barbican) getClient(as *vmv1.AuthSpec, namespace string) (*gophercloud.ServiceClient, error) {
+	opts, err := b.heat.authOptions(as, namespace)
+	if err != nil {
+		return nil, err
+	}
+	provider, err := openstack.AuthenticatedClient(opts)
+	if err != nil {
+		return nil, err
+	}
+	return openstack.NewKeyManagerV1(provider, gophercloud.EndpointOpts{})
+}
+
+func (b *Barbican) Store(as *vmv1.AuthSpec, namespace, name string, cert *tlsCert) (string, error) {
+	cli, err := b.getClient(as, namespace)
+	if err != nil {
+		return "", err
+	}
+	page, err := containers.List(cli, containers.ListOpts{Name: name}).AllPages()
+	if err != nil {
+		return "", err
+	}
+	exists, err := containers.ExtractContainers(page)
+	if err != nil {
+		return "", err
+	}
+	for _, v := range exists {
+		if v.Name == name {
+			klog.V(2).Infof("certificate container %s exists: %s", name, v.ContainerRef)
+			return v.ContainerRef, nil
+		}
+	}
+
+	var refs []containers.SecretRef
+	payloads := []struct {
+		name    string
+		typ     secrets.SecretType
+		payload string
+	}{
+		{"certificate", secrets.CertificateSecret, cert.Certificate},
+		{"private_key", secrets.PrivateSecret, cert.PrivateKey},
+		{"intermediates", secrets.CertificateSecret, cert.Intermediates},
+	}
+	for _, v := range payloads {
+		if v.payload == "" {
+			continue
+		}
+		secret, err := secrets.Create(cli, secrets.CreateOpts{
+			Name:               fmt.Sprintf("%s-%s", name, v.name),
+			Payload:            v.payload,
+			PayloadContentType: "text/plain",
+			SecretType:         v.typ,
+		}).Extract()
+		if err != nil {
+			// secrets which had been created are removed with container
+			b.removeSecrets(cli, refs)
+			return "", fmt.Errorf("create secret %s failed:%v", v.name, err)
+		}
+		refs = append(refs, containers.SecretRef{Name: v.name, SecretRef: secret.SecretRef})
+	}
+	container, err := containers.Create(cli, containers.CreateOpts{
+		Type:       containers.CertificateContainer,
+		Name:       name,
+		SecretRefs: refs,
+	}).Extract()
+	if err != nil {
+		b.removeSecrets(cli, refs)
+		return "", err
+	}
+	return container.ContainerRef, nil
+}
+
+// operator is used when credentials of auth can not be used, see deleteProvider
+func (b *Barbican) Remove(as *vmv1.AuthSpec, namespace, ref string) error {
+	cli, err := openstack.NewKeyManagerV1(b.heat.deleteProvider(as, namespace), gophercloud.EndpointOpts{})
+	if err != nil {
+		return err
+	}
+	id := path.Base(ref)
+	container, err := containers.Get(cli, id).Extract()
+	if err != nil {
+		if _, ok := err.(gophercloud.ErrDefault404); ok {
+			return nil
+		}
+		return err
+	}
+	err = containers.Delete(cli, id).ExtractErr()
+	if err != nil {
+		if _, ok := err.(gophercloud.ErrDefault404); !ok {
+			return err
+		}
+	}
+	b.removeSecrets(cli, container.SecretRefs)
+	return nil
+}
+
+// secret is leaked when remove failed, which is not referenced by anyone
+func (b *Barbican) removeSecrets(cli *gophercloud.ServiceClient, refs []containers.SecretRef) {
+	for _, v := range refs {
+		err := secrets.Delete(cli, path.Base(v.SecretRef)).ExtractErr()
+		if err != nil {
+			klog.Errorf("delete secret %s failed:%v", v.SecretRef, err)
+		}
+	}
+}
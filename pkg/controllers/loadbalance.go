@@ -5,15 +5,24 @@ import (
 	"fmt"
 	"github.com/tidwall/gjson"
 	"net"
+	"reflect"
 	"sort"
+	"strings"
 	"sync"
 
 	vmv1 "easystack.io/vm-operator/pkg/api/v1"
 	"easystack.io/vm-operator/pkg/manage"
+	"easystack.io/vm-operator/pkg/metrics"
 	"easystack.io/vm-operator/pkg/util"
 
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack"
+	octavialbs "github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/loadbalancers"
 	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/lbaas_v2/loadbalancers"
 	"github.com/gophercloud/gophercloud/pagination"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	klog "k8s.io/klog/v2"
 )
 
@@ -38,17 +47,26 @@ func (s sortIps) Less(i, j int) bool {
 }
 
 type LbResult struct {
+	// operating status
 	Stat string
-	Id   string
-	Lbid string
-	Ip   string
-	Name string
+	// provisioning status
+	ProvStat string
+	Id       string
+	Lbid     string
+	Ip       string
+	Name     string
 
 	//had sync or not
 	sync bool
 
 	//delete operat by user from openstack api
 	deleted bool
+
+	// operating status of pool members, fetched from status tree
+	members map[memberKey]string
+
+	// virtual machine which the load balance belong to
+	owner types.NamespacedName
 }
 
 func (s *LbResult) DeepCopy() *LbResult {
@@ -57,10 +75,40 @@ func (s *LbResult) DeepCopy() *LbResult {
 	tmp.Name = s.Name
 	tmp.Lbid = s.Lbid
 	tmp.Stat = s.Stat
+	tmp.ProvStat = s.ProvStat
 	tmp.Ip = s.Ip
+	tmp.owner = s.owner
+	if s.members != nil {
+		tmp.members = make(map[memberKey]string, len(s.members))
+		for k, v := range s.members {
+			tmp.members[k] = v
+		}
+	}
 	return tmp
 }
 
+type memberKey struct {
+	addr string
+	port int32
+}
+
+// status tree of load balance, which is same on neutron and octavia
+type statusTree struct {
+	Statuses struct {
+		Loadbalancer struct {
+			Listeners []struct {
+				Pools []struct {
+					Members []struct {
+						Address         string `json:"address"`
+						ProtocolPort    int32  `json:"protocol_port"`
+						OperatingStatus string `json:"operating_status"`
+					} `json:"members"`
+				} `json:"pools"`
+			} `json:"listeners"`
+		} `json:"loadbalancer"`
+	} `json:"statuses"`
+}
+
 func (s *LbResult) DeepCopyFrom(ls *loadbalancers.LoadBalancer) {
 
 	//TODO make sure which id, that will be used by floating ip
@@ -70,13 +118,42 @@ func (s *LbResult) DeepCopyFrom(ls *loadbalancers.LoadBalancer) {
 	s.Lbid = ls.ID
 	s.Ip = ls.VipAddress
 	s.Stat = ls.OperatingStatus
+	s.ProvStat = ls.ProvisioningStatus
+}
+
+// the load balance of neutron and octavia are same on fields used
+func extractLoadBalancers(page pagination.Page) ([]loadbalancers.LoadBalancer, error) {
+	if _, ok := page.(octavialbs.LoadBalancerPage); !ok {
+		return loadbalancers.ExtractLoadBalancers(page)
+	}
+	lists, err := octavialbs.ExtractLoadBalancers(page)
+	if err != nil {
+		return nil, err
+	}
+	lbs := make([]loadbalancers.LoadBalancer, 0, len(lists))
+	for _, v := range lists {
+		lbs = append(lbs, loadbalancers.LoadBalancer{
+			ID:                 v.ID,
+			Name:               v.Name,
+			VipPortID:          v.VipPortID,
+			VipAddress:         v.VipAddress,
+			VipSubnetID:        v.VipSubnetID,
+			ProvisioningStatus: v.ProvisioningStatus,
+			OperatingStatus:    v.OperatingStatus,
+		})
+	}
+	return lbs, nil
 }
 
 type LoadBalance struct {
-	mgr    *manage.OpenMgr
-	k8smgr *manage.K8sMgr
-	nova   *Nova
-	heat   *Heat
+	mgr      *manage.OpenMgr
+	k8smgr   *manage.K8sMgr
+	nova     *Nova
+	heat     *Heat
+	backend  *backends
+	keystore KeyStore
+	notify   *notifier
+	recorder record.EventRecorder
 
 	mu sync.RWMutex
 	//key: lbname
@@ -88,12 +165,16 @@ type LoadBalance struct {
 	linkname map[int64]string
 }
 
-func NewLoadBalance(heat *Heat, mgr *manage.OpenMgr, k8smgr *manage.K8sMgr, nova *Nova) *LoadBalance {
+func NewLoadBalance(heat *Heat, backend *backends, keystore KeyStore, mgr *manage.OpenMgr, k8smgr *manage.K8sMgr, nova *Nova, notify *notifier, recorder record.EventRecorder) *LoadBalance {
 	lb := &LoadBalance{
 		mgr:      mgr,
 		k8smgr:   k8smgr,
 		nova:     nova,
 		heat:     heat,
+		backend:  backend,
+		keystore: keystore,
+		notify:   notify,
+		recorder: recorder,
 		lbs:      make(map[string]*LbResult),
 		linkname: make(map[int64]string),
 	}
@@ -125,41 +206,160 @@ func (p *LoadBalance) GetIpByLink(link string) net.IP {
 }
 
 func (p *LoadBalance) addLbStore(page pagination.Page) {
-	lists, err := loadbalancers.ExtractLoadBalancers(page)
+	lists, err := extractLoadBalancers(page)
 	if err != nil {
 		klog.Errorf("loadbalancers extract page failed:%v", err)
 		return
 	}
+	var owners []types.NamespacedName
 	p.mu.Lock()
-	defer p.mu.Unlock()
 	exists := make(map[string]struct{}, len(p.lbs))
 	for _, lb := range lists {
-		v, ok := p.lbs[lb.Name]
-		if ok {
-			klog.V(3).Infof("callback update loadbalance: %v", lb)
-			v.DeepCopyFrom(&lb)
-			exists[lb.Name] = struct{}{}
-			v.deleted = false
-		}
-		v, ok = p.lbs[lb.ID]
-		if ok {
+		for _, key := range []string{lb.Name, lb.ID} {
+			v, ok := p.lbs[key]
+			if !ok {
+				continue
+			}
 			klog.V(3).Infof("callback update loadbalance: %v", lb)
+			if !v.sync || v.deleted || v.Stat != lb.OperatingStatus || v.ProvStat != lb.ProvisioningStatus || v.Ip != lb.VipAddress {
+				owners = append(owners, v.owner)
+			}
 			v.DeepCopyFrom(&lb)
-			exists[lb.ID] = struct{}{}
+			exists[key] = struct{}{}
 			v.deleted = false
 		}
 	}
 	for k, v := range p.lbs {
-		v.sync = true
 		if _, ok := exists[k]; !ok {
 			klog.V(2).Infof("loadbalance(%v) not found", v.Name)
+			if !v.deleted {
+				owners = append(owners, v.owner)
+			}
 			v.deleted = true
 		}
+		v.sync = true
 	}
+	metrics.SetCacheSize(manage.Lb.String(), len(p.lbs))
+	p.mu.Unlock()
+	owners = append(owners, p.syncMembers()...)
+	p.notify.notify(owners...)
 	return
 }
 
-func (p *LoadBalance) addLb(stat *vmv1.ResourceStatus, spec *vmv1.LoadBalanceSpec) {
+// fetch status of pool members, and return owners which members changed
+func (p *LoadBalance) syncMembers() (owners []types.NamespacedName) {
+	var lbids = make(map[string]string)
+	p.mu.RLock()
+	for k, v := range p.lbs {
+		if v.Lbid != "" && !v.deleted {
+			lbids[k] = v.Lbid
+		}
+	}
+	p.mu.RUnlock()
+	for k, lbid := range lbids {
+		members, err := p.memberStatus(lbid)
+		if err != nil {
+			klog.Errorf("fetch status of load balance %s failed:%v", lbid, err)
+			continue
+		}
+		p.mu.Lock()
+		v, ok := p.lbs[k]
+		if ok && !reflect.DeepEqual(v.members, members) {
+			klog.V(3).Infof("pool members of load balance %s changed: %v", lbid, members)
+			v.members = members
+			owners = append(owners, v.owner)
+		}
+		p.mu.Unlock()
+	}
+	return
+}
+
+func (p *LoadBalance) memberStatus(lbid string) (map[memberKey]string, error) {
+	var (
+		tree statusTree
+		err  error
+	)
+	p.mgr.WrapClient(func(provider *gophercloud.ProviderClient) {
+		var cli *gophercloud.ServiceClient
+		if p.mgr.LbApi() == manage.LbApiOctavia {
+			cli, err = openstack.NewLoadBalancerV2(provider, gophercloud.EndpointOpts{})
+			if err != nil {
+				return
+			}
+			err = octavialbs.GetStatuses(cli, lbid).ExtractInto(&tree)
+			return
+		}
+		cli, err = openstack.NewNetworkV2(provider, gophercloud.EndpointOpts{})
+		if err != nil {
+			return
+		}
+		err = loadbalancers.GetStatuses(cli, lbid).ExtractInto(&tree)
+	})
+	if err != nil {
+		return nil, err
+	}
+	members := make(map[memberKey]string)
+	for _, listen := range tree.Statuses.Loadbalancer.Listeners {
+		for _, pool := range listen.Pools {
+			for _, mem := range pool.Members {
+				members[memberKey{addr: mem.Address, port: mem.ProtocolPort}] = mem.OperatingStatus
+			}
+		}
+	}
+	return members, nil
+}
+
+// record status of pool members, the owner of member is the pod
+// found on link, or the nova member which has the ip
+func (p *LoadBalance) updateMembers(vm *vmv1.VirtualMachine, k8sres []*manage.Result) {
+	var (
+		stat    = vm.Status.NetStatus
+		pods    = make(map[string]string)
+		servers = make(map[string]string)
+		bykey   = stat.ServerStat.Id
+		members map[memberKey]string
+	)
+	for _, v := range k8sres {
+		pods[v.Ip.String()] = v.PodName
+	}
+	for _, v := range vm.Status.Members {
+		servers[v.Ip] = v.Id
+	}
+	if bykey == "" {
+		bykey = stat.StackName
+	}
+	p.mu.RLock()
+	if v, ok := p.lbs[bykey]; ok && !v.deleted {
+		members = v.members
+	}
+	p.mu.RUnlock()
+	if members == nil {
+		return
+	}
+	list := make([]*vmv1.LbMemberStat, 0, len(members))
+	for k, v := range members {
+		list = append(list, &vmv1.LbMemberStat{
+			Address:         k.addr,
+			Port:            k.port,
+			OperatingStatus: v,
+			PodName:         pods[k.addr],
+			MemberId:        servers[k.addr],
+		})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Address != list[j].Address {
+			return list[i].Address < list[j].Address
+		}
+		return list[i].Port < list[j].Port
+	})
+	vm.Status.LbMembers = list
+}
+
+func (p *LoadBalance) addLb(vm *vmv1.VirtualMachine) {
+	var (
+		stat = vm.Status.NetStatus
+		spec = vm.Spec.LoadBalance
+	)
 	if stat == nil || stat.StackName == "" {
 		return
 	}
@@ -179,11 +379,14 @@ func (p *LoadBalance) addLb(stat *vmv1.ResourceStatus, spec *vmv1.LoadBalanceSpe
 	_, ok := p.lbs[bykey]
 	if !ok {
 		p.lbs[bykey] = &LbResult{
-			Stat: stat.ServerStat.ResStat,
-			Lbid: stat.ServerStat.Id,
-			Ip:   stat.ServerStat.Ip,
-			Name: stat.ServerStat.ResName,
-			Id:   stat.ServerStat.CreateTime,
+			Stat:     stat.ServerStat.OperatingStatus,
+			ProvStat: stat.ServerStat.ProvisioningStatus,
+			Lbid:     stat.ServerStat.Id,
+			Ip:       stat.ServerStat.Ip,
+			Name:     stat.ServerStat.ResName,
+			Id:       stat.ServerStat.CreateTime,
+
+			owner: ownerOf(vm),
 		}
 		if spec.Link != "" {
 			id := util.Hashid(util.Str2bytes(spec.Link))
@@ -193,7 +396,8 @@ func (p *LoadBalance) addLb(stat *vmv1.ResourceStatus, spec *vmv1.LoadBalanceSpe
 	return
 }
 
-func (p *LoadBalance) update(stat *vmv1.ResourceStatus) {
+func (p *LoadBalance) update(vm *vmv1.VirtualMachine) {
+	stat := vm.Status.NetStatus
 	if stat == nil || stat.StackName == "" {
 		klog.Infof("lb update failed: not found resource name")
 		return
@@ -218,10 +422,16 @@ func (p *LoadBalance) update(stat *vmv1.ResourceStatus) {
 	klog.V(3).Infof("update load balance ResourceStatus:%v", v)
 	if v.deleted {
 		klog.V(2).Infof("load balance(%v) had been deleted", v.Name)
+		if stat.ServerStat.Id != "" {
+			p.recorder.Eventf(vm, corev1.EventTypeWarning, ReasonLbDeleted, "load balance %s(%s) had been deleted", stat.ServerStat.ResName, stat.ServerStat.Id)
+		}
 		stat.ServerStat = vmv1.ServerStat{}
+		vm.Status.LbMembers = nil
 		return
 	}
 	stat.ServerStat.ResStat = v.Stat
+	stat.ServerStat.OperatingStatus = v.Stat
+	stat.ServerStat.ProvisioningStatus = v.ProvStat
 	stat.ServerStat.Ip = v.Ip
 	stat.ServerStat.Id = v.Lbid
 	stat.ServerStat.ResName = v.Name
@@ -245,6 +455,11 @@ func (p *LoadBalance) Process(vm *vmv1.VirtualMachine) (reterr error) {
 	//sync floating ip forever, attach fip could be operator in web
 	if fipSpec == nil {
 		vm.Spec.Public = &vmv1.PublicSepc{}
+		defaultPip(vm.Spec.Public)
+	}
+	err := resolveLink(spec, vm.Namespace)
+	if err != nil {
+		return invalidSpec(err)
 	}
 	if spec.Link == "" {
 		fnova = true
@@ -257,7 +472,11 @@ func (p *LoadBalance) Process(vm *vmv1.VirtualMachine) (reterr error) {
 				p.mu.Lock()
 				delete(p.lbs, vm.Status.NetStatus.Name)
 				p.mu.Unlock()
-				reterr = p.heat.Process(manage.Lb, vm)
+				reterr = p.backend.delete(manage.Lb, vm)
+				// certificates are removed after listeners
+				if reterr == nil && vm.Status.NetStatus.StackName == "" {
+					reterr = p.cleanCerts(vm, nil)
+				}
 			}
 			if !fnova {
 				klog.V(2).Infof("remove link from k8s manager")
@@ -266,10 +485,8 @@ func (p *LoadBalance) Process(vm *vmv1.VirtualMachine) (reterr error) {
 		} else {
 			if reterr == nil {
 				if vm.Status.NetStatus != nil {
-					p.update(vm.Status.NetStatus)
-				}
-				if !fnova && len(k8sres) != 0 {
-					//TODO update members from k8sres
+					p.update(vm)
+					p.updateMembers(vm, k8sres)
 				}
 			}
 		}
@@ -277,39 +494,69 @@ func (p *LoadBalance) Process(vm *vmv1.VirtualMachine) (reterr error) {
 	if vm.DeletionTimestamp != nil {
 		return
 	}
-	err := validLbSpec(spec)
+	defaultLbSpec(spec)
+	err = validLbSpec(spec)
 	if err != nil {
-		return err
+		return invalidSpec(err)
 	}
-	if fnova {
+	err = validLbApi(spec, p.mgr.LbApi())
+	if err != nil {
+		return invalidSpec(err)
+	}
+	mixed := spec.Weights != nil
+	spec.MemberWeights = nil
+	if fnova || (mixed && spec.Weights.Server != nil) {
 		// Try find poolmembers from nova info
-		ips = p.nova.GetAllIps(vm)
-		if len(ips) == 0 {
-			klog.Infof("nova servers not ready, can not fetch members")
-			return nil
+		var novaips []string
+		if mixed {
+			novaips = serverIps(vm)
+		} else {
+			novaips = p.nova.GetAllIps(vm)
+			if len(novaips) == 0 {
+				klog.Infof("nova servers not ready, can not fetch members")
+				return nil
+			}
 		}
-		sort.Strings(ips)
-		klog.V(2).Infof("update server(nova) ip list:%v", ips)
-	} else {
+		sort.Strings(novaips)
+		klog.V(2).Infof("update server(nova) ip list:%v", novaips)
+		ips = append(ips, novaips...)
+		if mixed {
+			setMemberWeights(spec, novaips, *spec.Weights.Server)
+		}
+	}
+	if !fnova && (!mixed || spec.Weights.Pod != nil) {
 		// Try find poolmembers ip from link
-		if !p.k8smgr.LinkIsExist(spec.Link) {
-			lbip := net.ParseIP(spec.LbIp)
-			p.k8smgr.AddLinks(spec.Link, lbip, spec.Ports, spec.UseService)
+		// ip source and ports may be updated on exist link
+		lbip := net.ParseIP(spec.LbIp)
+		p.k8smgr.AddLinks(spec.Link, lbip, spec.Ports, spec.UseService, spec.ServiceType, spec.IpSource, ownerRef(vm))
+		k8sres, err = p.k8smgr.SecondIp(spec.Link)
+		if err == manage.ErrNotSynced {
+			// empty ips would remove all members of pool, wait notify once synced
+			klog.Infof("link %s is not synced, skip update members", spec.Link)
+			return nil
+		}
+		if spec.MemberMode == vmv1.MemberModeReady {
+			k8sres = readyResults(k8sres)
 		}
-		k8sres = p.k8smgr.SecondIp(spec.Link)
-		if len(k8sres) == 0 {
+		if len(k8sres) == 0 && len(ips) == 0 {
 			if stat == nil {
 				err = fmt.Errorf("not found ip on link and no stack found, skip")
 				return err
 			}
 			klog.V(2).Info("not found ip on link, but still update stack")
 		}
-	}
-	if !fnova {
+		var podips []string
 		for _, v := range k8sres {
-			ips = append(ips, v.Ip.String())
+			podips = append(podips, v.Ip.String())
+		}
+		klog.V(4).Infof("find pod ip list:%v", podips)
+		ips = append(ips, podips...)
+		if mixed {
+			setMemberWeights(spec, podips, *spec.Weights.Pod)
 		}
-		klog.V(4).Infof("find pod ip list:%v", ips)
+	}
+	if mixed {
+		ips = mixedIps(ips)
 	}
 	for i, _ := range spec.Ports {
 		spec.Ports[i].Ips = ips
@@ -325,11 +572,98 @@ func (p *LoadBalance) Process(vm *vmv1.VirtualMachine) (reterr error) {
 		resname = stat.StackName
 	}
 	spec.Name = resname
-	err = p.heat.Process(manage.Lb, vm)
+	spec.LbApi = p.mgr.LbApi()
+	used, err := p.ensureCerts(vm)
+	if err != nil {
+		return err
+	}
+	err = p.backend.process(manage.Lb, vm)
 	if err != nil {
 		return err
 	}
-	p.addLb(vm.Status.NetStatus, spec)
+	// old certificates are still used by listeners until updated
+	if vm.Status.NetStatus.Stat == Succeeded {
+		err = p.cleanCerts(vm, used)
+		if err != nil {
+			klog.Errorf("clean certificates failed:%v", err)
+		}
+	}
+	p.addLb(vm)
+	return nil
+}
+
+// store tls secrets of TERMINATED_HTTPS listens, and set the references on port map.
+// the name of certificate has hash of secret data, so the certificate is rotated
+// by new one when secret changed. return names of certificates used by listens.
+func (p *LoadBalance) ensureCerts(vm *vmv1.VirtualMachine) (map[string]struct{}, error) {
+	var (
+		stat = vm.Status.NetStatus
+		used = make(map[string]struct{})
+	)
+	ensure := func(secret string) (string, error) {
+		datas, err := p.k8smgr.GetSecret(vm.Namespace, secret)
+		if err != nil {
+			return "", err
+		}
+		cert, err := parseTlsCert(datas)
+		if err != nil {
+			return "", fmt.Errorf("parse tls secret %s/%s failed:%v", vm.Namespace, secret, err)
+		}
+		name := fmt.Sprintf("%s-%s-%s-%x", vm.Namespace, vm.Name, secret, uint64(cert.hash()))
+		used[name] = struct{}{}
+		if ref, ok := stat.Certificates[name]; ok {
+			return ref, nil
+		}
+		ref, err := p.keystore.Store(vm.Spec.Auth, vm.Namespace, name, cert)
+		if err != nil {
+			return "", fmt.Errorf("store certificate %s failed:%v", name, err)
+		}
+		klog.V(2).Infof("stored certificate %s: %s", name, ref)
+		if stat.Certificates == nil {
+			stat.Certificates = make(map[string]string)
+		}
+		stat.Certificates[name] = ref
+		p.recorder.Eventf(vm, corev1.EventTypeNormal, ReasonCertStored, "stored certificate of secret %s", secret)
+		return ref, nil
+	}
+	for _, pm := range vm.Spec.LoadBalance.Ports {
+		if pm.TlsSecret == "" {
+			continue
+		}
+		ref, err := ensure(pm.TlsSecret)
+		if err != nil {
+			return nil, err
+		}
+		pm.TlsContainerRef = ref
+		pm.SniContainerRefs = nil
+		for _, secret := range pm.SniSecrets {
+			ref, err = ensure(secret)
+			if err != nil {
+				return nil, err
+			}
+			pm.SniContainerRefs = append(pm.SniContainerRefs, ref)
+		}
+	}
+	return used, nil
+}
+
+// remove certificates which are not used
+func (p *LoadBalance) cleanCerts(vm *vmv1.VirtualMachine, used map[string]struct{}) error {
+	stat := vm.Status.NetStatus
+	for name, ref := range stat.Certificates {
+		if _, ok := used[name]; ok {
+			continue
+		}
+		klog.V(2).Infof("remove certificate %s: %s", name, ref)
+		err := p.keystore.Remove(vm.Spec.Auth, vm.Namespace, ref)
+		if err != nil {
+			return fmt.Errorf("remove certificate %s failed:%v", name, err)
+		}
+		delete(stat.Certificates, name)
+	}
+	if len(stat.Certificates) == 0 {
+		stat.Certificates = nil
+	}
 	return nil
 }
 
@@ -337,9 +671,11 @@ func (p *LoadBalance) Process(vm *vmv1.VirtualMachine) (reterr error) {
 //
 // such as listen0 (90 and tcp) is miss, and listen1 (80 and udp) is on
 // after render, listen0 is disappeard, and listen1 also is (80 and udp)
-
-// less -> much
-// much -> less
+//
+// so the listen is identified by protocol and port, which index is
+// recovered from last template, and members use the same order on all pools.
+// pool and health monitor are named by index of listen, so they are kept
+// with the listen, and monitor is not recreated when other listen removed.
 func reorderSpec(spec *vmv1.VirtualMachineSpec, stat *vmv1.ResourceStatus) {
 	if stat == nil || stat.Template == "" || len(spec.LoadBalance.Ports) == 0 {
 		return
@@ -348,7 +684,8 @@ func reorderSpec(spec *vmv1.VirtualMachineSpec, stat *vmv1.ResourceStatus) {
 		oldips     []string
 		currentips = map[string]struct{}{}
 		oldmaps    = make(map[string]struct{})
-		newports   []*vmv1.PortMap
+		oldlistens = make(map[int]string)
+		newips     []string
 
 		sorts sortIps
 	)
@@ -397,19 +734,24 @@ func reorderSpec(spec *vmv1.VirtualMachineSpec, stat *vmv1.ResourceStatus) {
 		i++
 	}
 
-	if len(oldips) == 0 || len(currentips) == 0 {
-		return
+	if len(oldips) != 0 && len(currentips) != 0 {
+		klog.V(2).Info("members old order: ", oldips)
+		newips = orderMembers(oldips, currentips)
+		klog.V(2).Info("members new order: ", newips)
 	}
-	klog.V(2).Info("members old order: ", oldips)
-	newips := orderMembers(oldips, currentips)
-	klog.V(2).Info("members new order: ", newips)
 
-	// TODO parse old listens, but it is not required.
-	// listens can not update now.
-	for _, dv := range spec.LoadBalance.Ports {
-		dv.Ips = nil
+	template.FindLbListens(util.Str2bytes(stat.Template), spec.LoadBalance.Name, func(index int, value *gjson.Result) {
+		if value.IsObject() {
+			oldlistens[index] = listenKey(value.Get("protocol").String(), int32(value.Get("protocol_port").Int()))
+		}
+	})
+	var newports []*vmv1.PortMap
+	for _, dv := range orderListens(spec.LoadBalance.Ports, oldlistens) {
 		tmp := dv.DeepCopy()
-		tmp.Ips = newips
+		// the removed listen is empty, which will not be rendered
+		if tmp.Port != 0 && newips != nil {
+			tmp.Ips = newips
+		}
 		klog.V(2).Infof("append portmap %v", tmp)
 		newports = append(newports, tmp)
 	}
@@ -417,33 +759,53 @@ func reorderSpec(spec *vmv1.VirtualMachineSpec, stat *vmv1.ResourceStatus) {
 	return
 }
 
-func orderListens(src []*vmv1.PortMap, dst map[string]*vmv1.PortMap, ips []string) (ss []*vmv1.PortMap) {
+// keep the index of listen same with last template.
+// the index of removed listen is hold by empty port map, so the listen
+// will not be replaced by others in one update, and new listen use the
+// index which not found in last template.
+func orderListens(src []*vmv1.PortMap, olds map[int]string) (ss []*vmv1.PortMap) {
 	var (
 		srcmap = make(map[string]*vmv1.PortMap)
+		used   = make(map[string]bool)
+		news   []*vmv1.PortMap
+		size   int
 	)
 	for _, v := range src {
-		key := portMapHashKey(v)
-		srcmap[key] = v
-	}
-	for _, srcv := range src {
-		key := portMapHashKey(srcv)
-		newpm := srcv.DeepCopy()
-		if _, ok := dst[key]; ok {
-			newpm.Ips = ips
-		} else {
-			newpm.Ips = nil
-			newpm.Port = 0
+		srcmap[listenKey(v.Protocol, v.Port)] = v
+	}
+	for index := range olds {
+		if index+1 > size {
+			size = index + 1
 		}
-		klog.V(2).Infof("reorder listen append portmap %v", newpm)
-		ss = append(ss, newpm)
 	}
-	//TODO dst value is reused
-	for dstk, dv := range dst {
-		if _, ok := srcmap[dstk]; !ok {
-			dv.Ips = ips
-			klog.V(2).Infof("reorder listen append portmap %v", dv)
-			ss = append(ss, dv)
+	ss = make([]*vmv1.PortMap, size)
+	for index, key := range olds {
+		if v, ok := srcmap[key]; ok && !used[key] {
+			ss[index] = v
+			used[key] = true
+		}
+	}
+	for _, v := range src {
+		if !used[listenKey(v.Protocol, v.Port)] {
+			news = append(news, v)
+		}
+	}
+	for index := range ss {
+		if ss[index] != nil {
+			continue
+		}
+		if _, ok := olds[index]; !ok && len(news) != 0 {
+			klog.V(2).Infof("reorder listen add portmap %v on index %d", news[0], index)
+			ss[index] = news[0]
+			news = news[1:]
+			continue
 		}
+		klog.V(2).Infof("reorder listen remove index %d", index)
+		ss[index] = &vmv1.PortMap{}
+	}
+	ss = append(ss, news...)
+	for len(ss) != 0 && ss[len(ss)-1].Port == 0 {
+		ss = ss[:len(ss)-1]
 	}
 	return
 }
@@ -474,34 +836,290 @@ func orderMembers(src []string, dst map[string]struct{}) (ss []string) {
 	return
 }
 
+// link is generated from reference, which is prior to link
+func resolveLink(spec *vmv1.LoadBalanceSpec, namespace string) error {
+	if spec.LinkRef == nil {
+		return nil
+	}
+	link, err := manage.RefLink(spec.LinkRef, namespace)
+	if err != nil {
+		return fmt.Errorf("link reference: %v", err)
+	}
+	spec.Link = link
+	return nil
+}
+
+// pods which are not ready are drained from pool
+func readyResults(res []*manage.Result) []*manage.Result {
+	var ready []*manage.Result
+	for _, v := range res {
+		if v.Ready {
+			ready = append(ready, v)
+		} else {
+			klog.V(2).Infof("pod %s(%s) is not ready, skip", v.PodName, v.Ip)
+		}
+	}
+	return ready
+}
+
+// ips of servers in mixed mode, which not wait building servers, and
+// servers may be scaled down to zero at the end of migration
+func serverIps(vm *vmv1.VirtualMachine) []string {
+	var ips []string
+	for _, v := range vm.Status.Members {
+		if v.Ip != "" {
+			ips = append(ips, v.Ip)
+		}
+	}
+	return ips
+}
+
+// members of servers and pods are alpha sort together
+func mixedIps(ips []string) []string {
+	var (
+		rets []string
+		seen = make(map[string]struct{})
+	)
+	for _, ip := range ips {
+		if _, ok := seen[ip]; ok {
+			continue
+		}
+		seen[ip] = struct{}{}
+		rets = append(rets, ip)
+	}
+	sort.Strings(rets)
+	return rets
+}
+
+// ip on both server and pod uses the weight of pod
+func setMemberWeights(spec *vmv1.LoadBalanceSpec, ips []string, weight int32) {
+	if spec.MemberWeights == nil {
+		spec.MemberWeights = make(map[string]int32)
+	}
+	for _, ip := range ips {
+		spec.MemberWeights[ip] = weight
+	}
+}
+
+func defaultLbSpec(spec *vmv1.LoadBalanceSpec) {
+	if spec.MemberMode == "" {
+		spec.MemberMode = vmv1.MemberModeAll
+	}
+	if spec.ServiceType == "" {
+		spec.ServiceType = vmv1.ServiceTypeExternalIP
+	}
+	for i, v := range spec.Ports {
+		if v.PodPort == 0 {
+			spec.Ports[i].PodPort = v.Port
+		}
+		if v.HealthCheck != nil {
+			defaultHealthCheck(v.HealthCheck)
+		}
+		v.Protocol = strings.ToUpper(v.Protocol)
+		v.Algorithm = strings.ToUpper(v.Algorithm)
+		if v.Algorithm == "" {
+			v.Algorithm = "ROUND_ROBIN"
+		}
+		if v.ConnectionLimit == 0 {
+			v.ConnectionLimit = -1
+		}
+		if v.SessionPersistence != nil {
+			v.SessionPersistence.Type = strings.ToUpper(v.SessionPersistence.Type)
+		}
+	}
+}
+
+// timeouts of listener are only supported by octavia
+func validLbApi(spec *vmv1.LoadBalanceSpec, lbapi string) error {
+	if lbapi == manage.LbApiOctavia {
+		return nil
+	}
+	for _, v := range spec.Ports {
+		if v.TimeoutClientData != 0 || v.TimeoutMemberData != 0 {
+			return fmt.Errorf("timeout of listen %s/%d is not supported by %s", v.Protocol, v.Port, lbapi)
+		}
+	}
+	return nil
+}
+
+func validWeights(spec *vmv1.LoadBalanceSpec) error {
+	w := spec.Weights
+	if w == nil {
+		return nil
+	}
+	if w.Server == nil && w.Pod == nil {
+		return fmt.Errorf("weight of server or pod is required")
+	}
+	if w.Pod != nil && spec.Link == "" {
+		return fmt.Errorf("weight of pod needs link")
+	}
+	for _, v := range []*int32{w.Server, w.Pod} {
+		if v != nil && (*v < 0 || *v > 256) {
+			return fmt.Errorf("weight(%d) should be in [0, 256]", *v)
+		}
+	}
+	return nil
+}
+
+func defaultHealthCheck(hc *vmv1.HealthCheck) {
+	hc.Type = strings.ToUpper(hc.Type)
+	if hc.Delay == 0 {
+		hc.Delay = 5
+	}
+	// timeout should not be bigger than delay
+	if hc.Timeout == 0 {
+		hc.Timeout = 3
+		if hc.Delay < hc.Timeout {
+			hc.Timeout = hc.Delay
+		}
+	}
+	if hc.MaxRetries == 0 {
+		hc.MaxRetries = 3
+	}
+	if hc.Type == "HTTP" || hc.Type == "HTTPS" {
+		if hc.UrlPath == "" {
+			hc.UrlPath = "/"
+		}
+		if hc.ExpectedCodes == "" {
+			hc.ExpectedCodes = "200"
+		}
+	}
+}
+
+func validHealthCheck(hc *vmv1.HealthCheck) error {
+	switch strings.ToUpper(hc.Type) {
+	case "TCP", "HTTP", "HTTPS", "PING":
+	default:
+		return fmt.Errorf("health check type should be TCP, HTTP, HTTPS or PING")
+	}
+	if hc.Delay < 0 || hc.Timeout < 0 {
+		return fmt.Errorf("health check delay and timeout should not be less than 0")
+	}
+	if hc.Timeout > hc.Delay {
+		return fmt.Errorf("health check timeout should not be bigger than delay")
+	}
+	if hc.MaxRetries < 0 || hc.MaxRetries > 10 {
+		return fmt.Errorf("health check max retries should be less than 10 and not less than 0")
+	}
+	if hc.UrlPath != "" && !strings.HasPrefix(hc.UrlPath, "/") {
+		return fmt.Errorf("health check url path should start with /")
+	}
+	return nil
+}
+
 func validLbSpec(spec *vmv1.LoadBalanceSpec) error {
 	if len(spec.Ports) == 0 {
 		return fmt.Errorf("not found port-protocol list info")
 	}
+	listens := make(map[string]struct{})
 	for _, port := range spec.Ports {
+		key := listenKey(port.Protocol, port.Port)
+		if _, ok := listens[key]; ok {
+			return fmt.Errorf("duplicate port %d and protocol %s", port.Port, port.Protocol)
+		}
+		listens[key] = struct{}{}
 		if port.Port > 65535 || port.Port <= 0 {
 			return fmt.Errorf("port should be less than 65535 and bigger than 0")
 		}
+		if port.PodPort > 65535 || port.PodPort < 0 {
+			return fmt.Errorf("pod port should be less than 65535 and not less than 0")
+		}
+		if port.HealthCheck != nil {
+			err := validHealthCheck(port.HealthCheck)
+			if err != nil {
+				return err
+			}
+		}
+		err := validPool(port)
+		if err != nil {
+			return err
+		}
+		err = validTls(port)
+		if err != nil {
+			return err
+		}
 	}
 	if spec.LbIp != "" {
 		if net.ParseIP(spec.LbIp) == nil {
 			return fmt.Errorf("parse lb ip(%v) faild", spec.LbIp)
 		}
 	}
-	for i, v := range spec.Ports {
-		if v.PodPort == 0 {
-			spec.Ports[i].PodPort = v.Port
+	switch spec.MemberMode {
+	case "", vmv1.MemberModeAll, vmv1.MemberModeReady:
+	default:
+		return fmt.Errorf("member mode should be %s or %s", vmv1.MemberModeAll, vmv1.MemberModeReady)
+	}
+	switch spec.ServiceType {
+	case "", vmv1.ServiceTypeExternalIP, vmv1.ServiceTypeLoadBalancer:
+	default:
+		return fmt.Errorf("service type should be %s or %s", vmv1.ServiceTypeExternalIP, vmv1.ServiceTypeLoadBalancer)
+	}
+	err := manage.ValidIpSource(spec.IpSource)
+	if err != nil {
+		return err
+	}
+	err = validWeights(spec)
+	if err != nil {
+		return err
+	}
+	if spec.Link != "" {
+		res := &manage.Resource{}
+		err := manage.ParseLink(spec.Link, res)
+		if err != nil {
+			return fmt.Errorf("parse link(%v) failed:%v", spec.Link, err)
+		}
+		if spec.IpSource != nil && spec.IpSource.Type == vmv1.IpSourceEndpointSlices && !res.IsResource(manage.Service) {
+			return fmt.Errorf("ip source %s is only used by service link", vmv1.IpSourceEndpointSlices)
 		}
 	}
 	return nil
 }
 
-func portMapHashKey(v *vmv1.PortMap) string {
-	var p int32
-	if v.Port == 0 {
-		p = v.PodPort
-	} else {
-		p = v.Port
+func validPool(pm *vmv1.PortMap) error {
+	switch strings.ToUpper(pm.Algorithm) {
+	case "", "ROUND_ROBIN", "LEAST_CONNECTIONS", "SOURCE_IP":
+	default:
+		return fmt.Errorf("algorithm should be ROUND_ROBIN, LEAST_CONNECTIONS or SOURCE_IP")
+	}
+	if pm.ConnectionLimit < -1 {
+		return fmt.Errorf("connection limit should not be less than -1")
+	}
+	if pm.TimeoutClientData < 0 || pm.TimeoutMemberData < 0 {
+		return fmt.Errorf("timeout should not be less than 0")
 	}
-	return fmt.Sprintf("%s%d", v.Protocol, p)
+	sp := pm.SessionPersistence
+	if sp == nil {
+		return nil
+	}
+	switch strings.ToUpper(sp.Type) {
+	case "SOURCE_IP", "HTTP_COOKIE":
+		if sp.CookieName != "" {
+			return fmt.Errorf("cookie name is only used by APP_COOKIE")
+		}
+	case "APP_COOKIE":
+		if sp.CookieName == "" {
+			return fmt.Errorf("cookie name is required by APP_COOKIE")
+		}
+	default:
+		return fmt.Errorf("session persistence should be SOURCE_IP, HTTP_COOKIE or APP_COOKIE")
+	}
+	return nil
+}
+
+func validTls(pm *vmv1.PortMap) error {
+	if strings.ToUpper(pm.Protocol) != "TERMINATED_HTTPS" {
+		if pm.TlsSecret != "" || len(pm.SniSecrets) != 0 {
+			return fmt.Errorf("tls secret is only used by TERMINATED_HTTPS")
+		}
+		return nil
+	}
+	if pm.TlsSecret == "" {
+		return fmt.Errorf("tls secret is required by TERMINATED_HTTPS")
+	}
+	return nil
+}
+
+// identity of listen
+func listenKey(protocol string, port int32) string {
+	return fmt.Sprintf("%s%d", strings.ToUpper(protocol), port)
 }
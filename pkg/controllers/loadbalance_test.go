@@ -0,0 +1,340 @@
+package controllers
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	vmv1 "easystack.io/vm-operator/pkg/api/v1"
+	"easystack.io/vm-operator/pkg/manage"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestReorderSpecListens(t *testing.T) {
+	stat := &vmv1.ResourceStatus{
+		Template: `{"resources":{
+			"lb-listen0":{"type":"OS::Neutron::LBaaS::Listener","properties":{"protocol":"TCP","protocol_port":80}},
+			"lb-listen1":{"type":"OS::Neutron::LBaaS::Listener","properties":{"protocol":"TCP","protocol_port":90}},
+			"lb-listen2":{"type":"OS::Neutron::LBaaS::Listener","properties":{"protocol":"UDP","protocol_port":53}},
+			"lb-member1-0":{"type":"OS::Neutron::LBaaS::PoolMember","properties":{"address":"10.0.0.2"}},
+			"lb-member1-1":{"type":"OS::Neutron::LBaaS::PoolMember","properties":{"address":"10.0.0.1"}}
+		}}`,
+	}
+	ips := []string{"10.0.0.1", "10.0.0.3"}
+	spec := &vmv1.VirtualMachineSpec{
+		LoadBalance: &vmv1.LoadBalanceSpec{
+			Name: "lb",
+			Ports: []*vmv1.PortMap{
+				{Port: 90, Protocol: "TCP", Algorithm: "SOURCE_IP", Ips: ips},
+				{Port: 443, Protocol: "TCP", Ips: ips},
+				{Port: 53, Protocol: "udp", Ips: ips},
+			},
+		},
+	}
+	reorderSpec(spec, stat)
+
+	var keys []string
+	for _, pm := range spec.LoadBalance.Ports {
+		keys = append(keys, listenKey(pm.Protocol, pm.Port))
+	}
+	want := []string{"0", "TCP90", "UDP53", "TCP443"}
+	if !reflect.DeepEqual(keys, want) {
+		t.Fatalf("listens should be %v, but %v", want, keys)
+	}
+	if spec.LoadBalance.Ports[1].Algorithm != "SOURCE_IP" {
+		t.Errorf("listen on index 1 should keep the changed algorithm")
+	}
+	if spec.LoadBalance.Ports[0].Ips != nil {
+		t.Errorf("removed listen should not have members")
+	}
+	wantips := []string{"", "10.0.0.1", "10.0.0.3"}
+	for _, pm := range spec.LoadBalance.Ports[1:] {
+		if !reflect.DeepEqual(pm.Ips, wantips) {
+			t.Errorf("members of %s should be %v, but %v", listenKey(pm.Protocol, pm.Port), wantips, pm.Ips)
+		}
+	}
+}
+
+func TestReorderSpecMonitor(t *testing.T) {
+	stat := &vmv1.ResourceStatus{
+		Template: `{"resources":{
+			"lb-listen0":{"type":"OS::Neutron::LBaaS::Listener","properties":{"protocol":"TCP","protocol_port":80}},
+			"lb-listen1":{"type":"OS::Neutron::LBaaS::Listener","properties":{"protocol":"HTTP","protocol_port":8080}},
+			"lb-monitor1":{"type":"OS::Neutron::LBaaS::HealthMonitor","properties":{"pool":{"get_resource":"lb-pool1"},"type":"HTTP"}},
+			"lb-member1-0":{"type":"OS::Neutron::LBaaS::PoolMember","properties":{"address":"10.0.0.1"}}
+		}}`,
+	}
+	hc := &vmv1.HealthCheck{Type: "HTTP", Delay: 2}
+	defaultHealthCheck(hc)
+	if hc.Timeout != 2 || validHealthCheck(hc) != nil {
+		t.Fatalf("timeout should not be bigger than delay, but %d", hc.Timeout)
+	}
+	// listen0 is removed, the listen with monitor keep index 1
+	spec := &vmv1.VirtualMachineSpec{
+		LoadBalance: &vmv1.LoadBalanceSpec{
+			Name: "lb",
+			Ports: []*vmv1.PortMap{
+				{Port: 8080, Protocol: "HTTP", HealthCheck: hc, Ips: []string{"10.0.0.1"}},
+			},
+		},
+	}
+	reorderSpec(spec, stat)
+	ports := spec.LoadBalance.Ports
+	if len(ports) != 2 || ports[0].Port != 0 || ports[1].HealthCheck == nil || ports[1].HealthCheck.Delay != 2 {
+		t.Fatalf("monitor should be kept on index 1, but %v", ports)
+	}
+}
+
+func TestOrderListensReuseIndex(t *testing.T) {
+	// index 1 had been removed on last update
+	olds := map[int]string{0: "TCP80", 2: "TCP90"}
+	src := []*vmv1.PortMap{
+		{Port: 90, Protocol: "TCP"},
+		{Port: 443, Protocol: "TCP"},
+	}
+	var keys []string
+	for _, pm := range orderListens(src, olds) {
+		keys = append(keys, listenKey(pm.Protocol, pm.Port))
+	}
+	want := []string{"0", "TCP443", "TCP90"}
+	if !reflect.DeepEqual(keys, want) {
+		t.Errorf("listens should be %v, but %v", want, keys)
+	}
+}
+
+type fakeKeyStore struct {
+	refs map[string]string
+}
+
+func (f *fakeKeyStore) Store(as *vmv1.AuthSpec, namespace, name string, cert *tlsCert) (string, error) {
+	ref := "container/" + name
+	f.refs[ref] = name
+	return ref, nil
+}
+
+func (f *fakeKeyStore) Remove(as *vmv1.AuthSpec, namespace, ref string) error {
+	delete(f.refs, ref)
+	return nil
+}
+
+// self signed certificate and key in pem
+func newTlsSecret(t *testing.T, name string) *unstructured.Unstructured {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyder, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crt := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keypem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyder})
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Secret",
+		"metadata": map[string]interface{}{
+			"namespace": "default",
+			"name":      name,
+		},
+		"type": "kubernetes.io/tls",
+		"data": map[string]interface{}{
+			tlsCertKey: base64.StdEncoding.EncodeToString(crt),
+			tlsKeyKey:  base64.StdEncoding.EncodeToString(keypem),
+		},
+	}}
+}
+
+func TestEnsureCertsRotate(t *testing.T) {
+	recorder := record.NewFakeRecorder(1024)
+	client := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), newTlsSecret(t, "tls"), newTlsSecret(t, "sni"))
+	keystore := &fakeKeyStore{refs: make(map[string]string)}
+	lb := &LoadBalance{
+		k8smgr:   manage.NewK8sMgr(client, recorder),
+		keystore: keystore,
+		recorder: recorder,
+	}
+	vm := &vmv1.VirtualMachine{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test"},
+		Spec: vmv1.VirtualMachineSpec{
+			LoadBalance: &vmv1.LoadBalanceSpec{
+				Ports: []*vmv1.PortMap{
+					{Port: 443, Protocol: "TERMINATED_HTTPS", TlsSecret: "tls", SniSecrets: []string{"sni"}},
+					{Port: 80, Protocol: "HTTP"},
+				},
+			},
+		},
+		Status: vmv1.VirtualMachineStatus{NetStatus: &vmv1.ResourceStatus{}},
+	}
+	used, err := lb.ensureCerts(vm)
+	if err != nil {
+		t.Fatalf("ensure certificates failed: %v", err)
+	}
+	pm := vm.Spec.LoadBalance.Ports[0]
+	if len(used) != 2 || pm.TlsContainerRef == "" || len(pm.SniContainerRefs) != 1 {
+		t.Fatalf("references are not expected: %v %v", pm.TlsContainerRef, pm.SniContainerRefs)
+	}
+	if vm.Spec.LoadBalance.Ports[1].TlsContainerRef != "" {
+		t.Errorf("http listen should not have certificate")
+	}
+	oldref := pm.TlsContainerRef
+
+	// rotate the default certificate
+	secretGvr := schema.GroupVersionResource{Version: "v1", Resource: "secrets"}
+	_, err = client.Resource(secretGvr).Namespace("default").Update(context.Background(), newTlsSecret(t, "tls"), metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	used, err = lb.ensureCerts(vm)
+	if err != nil {
+		t.Fatalf("ensure certificates failed: %v", err)
+	}
+	if pm.TlsContainerRef == oldref || len(keystore.refs) != 3 {
+		t.Fatalf("certificate should be rotated, refs: %v", keystore.refs)
+	}
+	err = lb.cleanCerts(vm, used)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := keystore.refs[oldref]; ok || len(vm.Status.NetStatus.Certificates) != 2 {
+		t.Errorf("old certificate should be removed, refs: %v", keystore.refs)
+	}
+
+	err = lb.cleanCerts(vm, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keystore.refs) != 0 || vm.Status.NetStatus.Certificates != nil {
+		t.Errorf("all certificates should be removed, refs: %v", keystore.refs)
+	}
+}
+
+func TestValidTls(t *testing.T) {
+	cases := []struct {
+		pm    *vmv1.PortMap
+		valid bool
+	}{
+		{&vmv1.PortMap{Protocol: "TERMINATED_HTTPS", TlsSecret: "tls"}, true},
+		{&vmv1.PortMap{Protocol: "TERMINATED_HTTPS"}, false},
+		{&vmv1.PortMap{Protocol: "HTTP", TlsSecret: "tls"}, false},
+		{&vmv1.PortMap{Protocol: "TCP"}, true},
+	}
+	for i, c := range cases {
+		err := validTls(c.pm)
+		if (err == nil) != c.valid {
+			t.Errorf("case %d: valid should be %v, but %v", i, c.valid, err)
+		}
+	}
+}
+
+func TestValidLbApi(t *testing.T) {
+	spec := &vmv1.LoadBalanceSpec{Ports: []*vmv1.PortMap{{Protocol: "TCP", Port: 80, TimeoutClientData: 1000}}}
+	if err := validLbApi(spec, manage.LbApiNeutron); err == nil {
+		t.Errorf("timeout should be rejected by neutron")
+	}
+	if err := validLbApi(spec, manage.LbApiOctavia); err != nil {
+		t.Errorf("timeout should be supported by octavia, but %v", err)
+	}
+}
+
+func TestUpdateMembers(t *testing.T) {
+	lb := &LoadBalance{
+		lbs: map[string]*LbResult{
+			"lb-1": {
+				Lbid: "lb-1",
+				members: map[memberKey]string{
+					{addr: "10.0.0.2", port: 8080}: "ERROR",
+					{addr: "10.0.0.1", port: 8080}: "ONLINE",
+				},
+			},
+		},
+	}
+	vm := &vmv1.VirtualMachine{
+		Status: vmv1.VirtualMachineStatus{
+			NetStatus: &vmv1.ResourceStatus{
+				ServerStat: vmv1.ServerStat{Id: "lb-1"},
+			},
+		},
+	}
+	k8sres := []*manage.Result{
+		{Ip: net.ParseIP("10.0.0.1"), PodName: "pod-1"},
+		{Ip: net.ParseIP("10.0.0.2"), PodName: "pod-2"},
+	}
+	lb.updateMembers(vm, k8sres)
+	want := []*vmv1.LbMemberStat{
+		{Address: "10.0.0.1", Port: 8080, OperatingStatus: "ONLINE", PodName: "pod-1"},
+		{Address: "10.0.0.2", Port: 8080, OperatingStatus: "ERROR", PodName: "pod-2"},
+	}
+	if !reflect.DeepEqual(vm.Status.LbMembers, want) {
+		t.Errorf("members should be %v, but %v", want, vm.Status.LbMembers)
+	}
+}
+
+func TestValidWeights(t *testing.T) {
+	weight := func(v int32) *int32 { return &v }
+	link := "/api/v1/namespaces/default/pods?labelSelector=app=web"
+	cases := []struct {
+		spec  *vmv1.LoadBalanceSpec
+		valid bool
+	}{
+		{&vmv1.LoadBalanceSpec{}, true},
+		{&vmv1.LoadBalanceSpec{Link: link, Weights: &vmv1.MemberWeights{Server: weight(1), Pod: weight(0)}}, true},
+		{&vmv1.LoadBalanceSpec{Weights: &vmv1.MemberWeights{Server: weight(1)}}, true},
+		{&vmv1.LoadBalanceSpec{Link: link, Weights: &vmv1.MemberWeights{}}, false},
+		{&vmv1.LoadBalanceSpec{Weights: &vmv1.MemberWeights{Pod: weight(1)}}, false},
+		{&vmv1.LoadBalanceSpec{Link: link, Weights: &vmv1.MemberWeights{Pod: weight(257)}}, false},
+	}
+	for i, c := range cases {
+		err := validWeights(c.spec)
+		if (err == nil) != c.valid {
+			t.Errorf("case %d: valid should be %v, but %v", i, c.valid, err)
+		}
+	}
+}
+
+func TestMemberWeights(t *testing.T) {
+	spec := &vmv1.LoadBalanceSpec{}
+	setMemberWeights(spec, []string{"10.0.0.2", "10.0.0.1"}, 3)
+	setMemberWeights(spec, []string{"10.0.0.1", "10.0.1.5"}, 7)
+	want := map[string]int32{"10.0.0.1": 7, "10.0.0.2": 3, "10.0.1.5": 7}
+	if !reflect.DeepEqual(spec.MemberWeights, want) {
+		t.Errorf("weights should be %v, but %v", want, spec.MemberWeights)
+	}
+	vm := &vmv1.VirtualMachine{}
+	if ips := serverIps(vm); len(ips) != 0 {
+		t.Errorf("servers scaled down should be empty, but %v", ips)
+	}
+	vm.Status.Members = []*vmv1.ServerStat{{Ip: "10.0.0.2"}, {ResStat: ServerBuildStat}}
+	if ips := serverIps(vm); !reflect.DeepEqual(ips, []string{"10.0.0.2"}) {
+		t.Errorf("building server should be skipped, but %v", ips)
+	}
+	ips := mixedIps([]string{"10.0.0.2", "10.0.0.1", "10.0.0.1", "10.0.1.5"})
+	if !reflect.DeepEqual(ips, []string{"10.0.0.1", "10.0.0.2", "10.0.1.5"}) {
+		t.Errorf("unexpected mixed ips: %v", ips)
+	}
+}
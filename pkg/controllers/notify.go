@@ -0,0 +1,74 @@
+package controllers
+
+import (
+	vmv1 "easystack.io/vm-operator/pkg/api/v1"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	klog "k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+const (
+	notifyBufferSize = 1024
+)
+
+// notifier enqueue the owner virtual machine when openstack resource
+// which cached by callback changed
+type notifier struct {
+	ch chan event.GenericEvent
+}
+
+func newNotifier() *notifier {
+	return &notifier{
+		ch: make(chan event.GenericEvent, notifyBufferSize),
+	}
+}
+
+// never block the callback of openstack manager,
+// the dropped event will be fixed by resync
+func (n *notifier) notify(owners ...types.NamespacedName) {
+	for _, owner := range owners {
+		if owner.Name == "" {
+			continue
+		}
+		ev := event.GenericEvent{
+			Object: &vmv1.VirtualMachine{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: owner.Namespace,
+					Name:      owner.Name,
+				},
+			},
+		}
+		select {
+		case n.ch <- ev:
+			klog.V(3).Infof("notify virtual machine %s", owner.String())
+		default:
+			klog.Warningf("notify channel is full, drop event of %s", owner.String())
+		}
+	}
+}
+
+func (n *notifier) source() source.Source {
+	return &source.Channel{Source: n.ch}
+}
+
+// reference of virtual machine, which used by event
+func ownerRef(vm *vmv1.VirtualMachine) *corev1.ObjectReference {
+	return &corev1.ObjectReference{
+		APIVersion: vmv1.GroupVersion.String(),
+		Kind:       "VirtualMachine",
+		Namespace:  vm.Namespace,
+		Name:       vm.Name,
+		UID:        vm.UID,
+	}
+}
+
+func ownerOf(vm *vmv1.VirtualMachine) types.NamespacedName {
+	return types.NamespacedName{
+		Namespace: vm.Namespace,
+		Name:      vm.Name,
+	}
+}
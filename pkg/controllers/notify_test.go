@@ -0,0 +1,120 @@
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/lbaas_v2/loadbalancers"
+	"github.com/gophercloud/gophercloud/pagination"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+// names of virtual machines in notify channel
+func notified(n *notifier) []string {
+	var names []string
+	for {
+		select {
+		case ev := <-n.ch:
+			names = append(names, ev.Object.GetNamespace()+"/"+ev.Object.GetName())
+		default:
+			return names
+		}
+	}
+}
+
+func TestNotifyFull(t *testing.T) {
+	n := &notifier{ch: make(chan event.GenericEvent, 1)}
+	done := make(chan struct{})
+	go func() {
+		n.notify(types.NamespacedName{Namespace: "test", Name: "vm1"},
+			types.NamespacedName{},
+			types.NamespacedName{Namespace: "test", Name: "vm2"})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("notify should not block when channel is full")
+	}
+	if names := notified(n); len(names) != 1 || names[0] != "test/vm1" {
+		t.Errorf("only test/vm1 should be notified, but %v", names)
+	}
+}
+
+func TestStoreVmsNotify(t *testing.T) {
+	p := &Nova{
+		vms: map[string]map[string]*VmResult{
+			"vm": {"1": {Id: "1", Name: "vm", Stat: ServerRunStat}},
+		},
+		listed: make(map[string]bool),
+		owners: map[string]types.NamespacedName{"vm": {Namespace: "test", Name: "vm1"}},
+		notify: newNotifier(),
+	}
+	newPage := func(stat string) pagination.Page {
+		var list []interface{}
+		if stat != "" {
+			list = append(list, map[string]interface{}{"id": "1", "name": "vm", "status": stat})
+		}
+		return servers.ServerPage{LinkedPageBase: pagination.LinkedPageBase{PageResult: pagination.PageResult{
+			Result: gophercloud.Result{Body: map[string]interface{}{"servers": list}},
+		}}}
+	}
+
+	// the first list marks cache synced
+	p.storeVms(newPage(ServerRunStat), time.Now())
+	notified(p.notify)
+	p.storeVms(newPage(ServerRunStat), time.Now())
+	if names := notified(p.notify); len(names) != 0 {
+		t.Errorf("unchanged server should not notify, but %v", names)
+	}
+	p.storeVms(newPage(ServerStopStat), time.Now())
+	if names := notified(p.notify); len(names) != 1 || names[0] != "test/vm1" {
+		t.Errorf("owner should be notified when status changed, but %v", names)
+	}
+	// removed server
+	p.storeVms(newPage(""), time.Now())
+	if names := notified(p.notify); len(names) != 1 || names[0] != "test/vm1" {
+		t.Errorf("owner should be notified when server removed, but %v", names)
+	}
+}
+
+func TestAddLbStoreNotify(t *testing.T) {
+	p := &LoadBalance{
+		lbs: map[string]*LbResult{
+			"lb": {Name: "lb", Stat: LbOnline, ProvStat: "ACTIVE", Ip: "10.0.0.10", owner: types.NamespacedName{Namespace: "test", Name: "vm1"}},
+		},
+		notify: newNotifier(),
+	}
+	newPage := func(lbs ...map[string]interface{}) pagination.Page {
+		list := make([]interface{}, 0, len(lbs))
+		for _, v := range lbs {
+			list = append(list, v)
+		}
+		return loadbalancers.LoadBalancerPage{LinkedPageBase: pagination.LinkedPageBase{PageResult: pagination.PageResult{
+			Result: gophercloud.Result{Body: map[string]interface{}{"loadbalancers": list}},
+		}}}
+	}
+	// id is empty, members are not fetched
+	lb := map[string]interface{}{"name": "lb", "operating_status": LbOnline, "provisioning_status": "ACTIVE", "vip_address": "10.0.0.10"}
+
+	p.addLbStore(newPage(lb))
+	notified(p.notify)
+	p.addLbStore(newPage(lb))
+	if names := notified(p.notify); len(names) != 0 {
+		t.Errorf("unchanged load balance should not notify, but %v", names)
+	}
+	lb["provisioning_status"] = "ERROR"
+	p.addLbStore(newPage(lb))
+	if names := notified(p.notify); len(names) != 1 || names[0] != "test/vm1" {
+		t.Errorf("owner should be notified when status changed, but %v", names)
+	}
+	// deleted load balance is notified once
+	p.addLbStore(newPage())
+	p.addLbStore(newPage())
+	if names := notified(p.notify); len(names) != 1 || names[0] != "test/vm1" {
+		t.Errorf("owner should be notified once when load balance deleted, but %v", names)
+	}
+}
@@ -2,14 +2,26 @@ package controllers
 
 import (
 	"fmt"
+	"sort"
+	"strconv"
 	"sync"
+	"time"
 
 	vmv1 "easystack.io/vm-operator/pkg/api/v1"
 	"easystack.io/vm-operator/pkg/manage"
+	"easystack.io/vm-operator/pkg/metrics"
+	"easystack.io/vm-operator/pkg/template"
 	"easystack.io/vm-operator/pkg/util"
 
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack"
+	"github.com/gophercloud/gophercloud/openstack/blockstorage/v3/volumes"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/startstop"
 	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
 	"github.com/gophercloud/gophercloud/pagination"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	klog "k8s.io/klog/v2"
 )
 
@@ -18,6 +30,17 @@ const (
 	ServerStopStat  = "SHUTOFF"
 	ServerBuildStat = "BUILD"
 	ServerErrStat   = "ERROR"
+
+	Processing = "Processing"
+
+	// metadata key on nova server, which value is member index
+	indexMetaKey = "mixapp-index"
+	// metadata key on nova server, which value is name of virtual machine,
+	// and selected by scale subresource
+	ownerMetaKey = "mixapp.easystack.io/owner"
+
+	// rebuild volume backed server is supported since compute api 2.93
+	rebuildMicroversion = "2.93"
 )
 
 type VmResult struct {
@@ -26,6 +49,12 @@ type VmResult struct {
 	Id        string                       `json:"id"`
 	Ip4addres map[string]string            `json:"-"`
 	Addresses map[string][]servers.Address `json:"addresses,omitempty"`
+	Metadata  map[string]string            `json:"metadata,omitempty"`
+
+	//had sync or not after action sent
+	sync bool
+	// time of action sent, list started before it is stale
+	actionAt time.Time
 }
 
 func (s *VmResult) DeepCopy() *VmResult {
@@ -39,6 +68,12 @@ func (s *VmResult) DeepCopy() *VmResult {
 	for k, v := range s.Ip4addres {
 		tmp.Ip4addres[k] = v
 	}
+	if s.Metadata != nil {
+		tmp.Metadata = make(map[string]string, len(s.Metadata))
+		for k, v := range s.Metadata {
+			tmp.Metadata[k] = v
+		}
+	}
 
 	for k, v := range s.Addresses {
 		var sas = make([]servers.Address, len(v))
@@ -52,6 +87,13 @@ func (s *VmResult) DeepCopyInto(netname string, vm *vmv1.ServerStat) {
 	vm.Id = s.Id
 	vm.ResName = s.Name
 	vm.ResStat = s.Stat
+	if v, ok := s.Metadata[indexMetaKey]; ok {
+		index, err := strconv.ParseInt(v, 10, 32)
+		if err == nil {
+			idx := int32(index)
+			vm.Index = &idx
+		}
+	}
 	for name, addr := range s.Ip4addres {
 		if netname == name {
 			vm.Ip = addr
@@ -66,6 +108,7 @@ func (s *VmResult) DeepCopyFrom(ls *VmResult) {
 	s.Id = ls.Id
 	s.Name = ls.Name
 	s.Stat = ls.Stat
+	s.Metadata = ls.Metadata
 	for name, addrs := range ls.Addresses {
 		for _, val := range addrs {
 			if val.Version == 4 {
@@ -76,13 +119,23 @@ func (s *VmResult) DeepCopyFrom(ls *VmResult) {
 }
 
 type Nova struct {
-	mgr  *manage.OpenMgr
-	heat *Heat
+	mgr      *manage.OpenMgr
+	heat     *Heat
+	backend  *backends
+	notify   *notifier
+	recorder record.EventRecorder
 
 	mu sync.RWMutex
 	// key: the name which cut suffix [-x]
 	// value: vm-id : VmResults
 	vms map[string]map[string]*VmResult
+
+	// key: the name which had been listed at least once
+	listed map[string]bool
+
+	// key: the name which cut suffix [-x]
+	// value: virtual machine which servers belong to
+	owners map[string]types.NamespacedName
 }
 
 func (p *Nova) GetAllIps(vm *vmv1.VirtualMachine) []string {
@@ -104,18 +157,39 @@ func (p *Nova) GetAllIps(vm *vmv1.VirtualMachine) []string {
 	return ips
 }
 
-func NewNova(heat *Heat, mgr *manage.OpenMgr) *Nova {
+// GetActiveIps return ips of ACTIVE members, which not wait building members
+func (p *Nova) GetActiveIps(vm *vmv1.VirtualMachine) []string {
+	var ips []string
+	for _, v := range vm.Status.Members {
+		if v.Ip != "" && v.ResStat == ServerRunStat {
+			ips = append(ips, v.Ip)
+		}
+	}
+	return ips
+}
+
+func NewNova(heat *Heat, backend *backends, mgr *manage.OpenMgr, notify *notifier, recorder record.EventRecorder) *Nova {
 	vm := &Nova{
-		mgr:  mgr,
-		heat: heat,
-		vms:  make(map[string]map[string]*VmResult),
+		mgr:      mgr,
+		heat:     heat,
+		backend:  backend,
+		notify:   notify,
+		recorder: recorder,
+		vms:      make(map[string]map[string]*VmResult),
+		listed:   make(map[string]bool),
+		owners:   make(map[string]types.NamespacedName),
 	}
 	mgr.Regist(manage.Vm, vm.addVmStore)
+	heat.RegistReOrderFunc(template.Vm, reorderServer)
 	return vm
 }
 
 func (p *Nova) addVmStore(page pagination.Page) {
+	p.storeVms(page, p.mgr.ListStart(manage.Vm))
+}
 
+// start is the time when list started
+func (p *Nova) storeVms(page pagination.Page, start time.Time) {
 	var svs []*VmResult
 	err := servers.ExtractServersInto(page, &svs)
 	if err != nil {
@@ -123,25 +197,89 @@ func (p *Nova) addVmStore(page pagination.Page) {
 		return
 	}
 
-	p.mu.RLock()
-	defer p.mu.RUnlock()
+	var (
+		changed = make(map[string]struct{})
+		exists  = make(map[string]struct{})
+	)
+	p.mu.Lock()
 	for _, sv := range svs {
 		v, ok := p.vms[sv.Name]
 		if ok {
 			klog.V(3).Infof("callback update nova:%v", sv)
+			exists[sv.Id] = struct{}{}
 			result, ok := v[sv.Id]
 			if ok {
+				if !result.sync || result.Stat != sv.Stat {
+					changed[sv.Name] = struct{}{}
+				}
 				result.DeepCopyFrom(sv)
 			} else {
+				changed[sv.Name] = struct{}{}
 				v[sv.Id] = sv.DeepCopy()
 			}
 		}
 	}
+	for name, v := range p.vms {
+		for id, result := range v {
+			// server had been removed, such as scale down
+			if _, ok := exists[id]; !ok {
+				klog.V(2).Infof("nova server(%v) not found", id)
+				delete(v, id)
+				changed[name] = struct{}{}
+				continue
+			}
+			// the list is started before action sent, wait next list
+			if start.Before(result.actionAt) {
+				klog.V(3).Infof("nova server(%v) listed before action, not synced", id)
+				continue
+			}
+			result.sync = true
+		}
+		p.listed[name] = true
+	}
+	metrics.SetCacheSize(manage.Vm.String(), len(p.vms))
+	var owners []types.NamespacedName
+	for name := range changed {
+		owners = append(owners, p.owners[name])
+	}
+	p.mu.Unlock()
+	p.notify.notify(owners...)
 	return
 }
 
-func (p *Nova) addVm(stat *vmv1.VirtualMachineStatus) {
-	if stat == nil || stat.VmStatus == nil || stat.VmStatus.StackName == "" {
+// get the copy of server from cache, the second return is synced or not
+func (p *Nova) getVm(resname, id string) (*VmResult, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	svs, ok := p.vms[resname]
+	if !ok {
+		return nil, false
+	}
+	v, ok := svs[id]
+	if !ok {
+		return nil, false
+	}
+	return v.DeepCopy(), v.sync
+}
+
+// mark server not synced after action api returned, stat should be
+// fetched again by list which is started after it
+func (p *Nova) listenById(resname, id string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	svs, ok := p.vms[resname]
+	if !ok {
+		return
+	}
+	if v, ok := svs[id]; ok {
+		v.sync = false
+		v.actionAt = time.Now()
+	}
+}
+
+func (p *Nova) addVm(vm *vmv1.VirtualMachine) {
+	stat := &vm.Status
+	if stat.VmStatus == nil || stat.VmStatus.StackName == "" {
 		return
 	}
 	resname := stat.VmStatus.StackName
@@ -152,11 +290,16 @@ func (p *Nova) addVm(stat *vmv1.VirtualMachineStatus) {
 		klog.V(2).Infof("add listen nova by name:%v", resname)
 		p.vms[resname] = make(map[string]*VmResult)
 	}
+	p.owners[resname] = ownerOf(vm)
 	return
 }
 
-func (p *Nova) update(stat *vmv1.VirtualMachineStatus, netspec *vmv1.ServerSpec) {
-	if stat == nil || stat.VmStatus == nil || stat.VmStatus.StackName == "" {
+func (p *Nova) update(vm *vmv1.VirtualMachine) {
+	var (
+		stat    = &vm.Status
+		netspec = vm.Spec.Server
+	)
+	if stat.VmStatus == nil || stat.VmStatus.StackName == "" {
 		return
 	}
 	resname := stat.VmStatus.StackName
@@ -171,20 +314,49 @@ func (p *Nova) update(stat *vmv1.VirtualMachineStatus, netspec *vmv1.ServerSpec)
 	if !ok {
 		return
 	}
-	for _, vm := range svs {
-		index, ok := memmaps[vm.Id]
-		klog.V(3).Infof("update nova ResourceStatus: %v", vm)
+	if p.listed[resname] {
+		// remove members which not found on nova
+		var mems []*vmv1.ServerStat
+		for _, mem := range stat.Members {
+			if _, ok := svs[mem.Id]; ok {
+				mems = append(mems, mem)
+			}
+		}
+		stat.Members = mems
+		memmaps = make(map[string]int)
+		for i, mem := range stat.Members {
+			memmaps[mem.Id] = i
+		}
+	}
+	for _, sv := range svs {
+		var (
+			mem     *vmv1.ServerStat
+			prestat string
+		)
+		index, ok := memmaps[sv.Id]
+		klog.V(3).Infof("update nova ResourceStatus: %v", sv)
 		if ok {
-			vm.DeepCopyInto(netspec.Subnet.NetworkName, stat.Members[index])
+			mem = stat.Members[index]
+			prestat = mem.ResStat
 		} else {
-			newmem := &vmv1.ServerStat{}
-			vm.DeepCopyInto(netspec.Subnet.NetworkName, newmem)
-			vmstat = append(vmstat, newmem)
+			mem = &vmv1.ServerStat{}
+			vmstat = append(vmstat, mem)
+		}
+		sv.DeepCopyInto(netspec.Subnet.NetworkName, mem)
+		if mem.ResStat == ServerErrStat && prestat != ServerErrStat {
+			p.recorder.Eventf(vm, corev1.EventTypeWarning, ReasonMemberError, "member %s(%s) is in %s stat", mem.ResName, mem.Id, ServerErrStat)
 		}
 	}
 	if len(vmstat) != 0 {
 		stat.Members = append(stat.Members, vmstat...)
 	}
+	sort.SliceStable(stat.Members, func(i, j int) bool {
+		if stat.Members[i].Index == nil || stat.Members[j].Index == nil {
+			return stat.Members[j].Index == nil && stat.Members[i].Index != nil
+		}
+		return *stat.Members[i].Index < *stat.Members[j].Index
+	})
+	stat.Replicas = int32(len(stat.Members))
 }
 
 func (p *Nova) Process(vm *vmv1.VirtualMachine) (reterr error) {
@@ -196,23 +368,24 @@ func (p *Nova) Process(vm *vmv1.VirtualMachine) (reterr error) {
 		return nil
 	}
 
-	if spec.Name == "" {
-		spec.Name = "vm"
-	}
+	defaultVmSpec(spec)
 	defer func() {
 		//Remove stack if pod link not exist
 		if vm.DeletionTimestamp != nil {
 			if vm.Status.VmStatus != nil {
 				klog.V(2).Infof("remove nova resource")
-				reterr = p.heat.DeleteStack(vm.Status.VmStatus)
+				resname := vm.Status.VmStatus.StackName
+				reterr = p.backend.delete(manage.Vm, vm)
 				p.mu.Lock()
-				delete(p.vms, vm.Status.VmStatus.StackName)
+				delete(p.vms, resname)
+				delete(p.listed, resname)
+				delete(p.owners, resname)
 				p.mu.Unlock()
 			}
 		} else {
 			if reterr == nil {
 				if vm.Status.VmStatus != nil {
-					p.update(&vm.Status, vm.Spec.Server)
+					p.update(vm)
 				}
 			}
 		}
@@ -222,7 +395,7 @@ func (p *Nova) Process(vm *vmv1.VirtualMachine) (reterr error) {
 	}
 	err := validVmSpec(spec)
 	if err != nil {
-		return err
+		return invalidSpec(err)
 	}
 	// 1. prefixName used as filter prefix key
 	// 2. rand string to dict same name
@@ -235,17 +408,294 @@ func (p *Nova) Process(vm *vmv1.VirtualMachine) (reterr error) {
 		resname = stat.StackName
 	}
 	spec.Name = resname
-	err = p.heat.Process(manage.Vm, vm)
+	spec.Owner = vm.Name
+	vm.Status.Selector = fmt.Sprintf("%s=%s", ownerMetaKey, vm.Name)
+	err = p.backend.process(manage.Vm, vm)
 	if err != nil {
 		return err
 	}
-	p.addVm(&vm.Status)
+	p.addVm(vm)
+	return nil
+}
+
+// Action power off, power on or rebuild members by assemblyPhase.
+// The action is succeeded only when all members report expected stat
+// from nova cache, which is synced after action sent.
+func (p *Nova) Action(vm *vmv1.VirtualMachine) error {
+	var (
+		phase = vm.Spec.AssemblyPhase
+		stat  = vm.Status.VmStatus
+		want  string
+		done  = true
+	)
+	switch phase {
+	case vmv1.Stop:
+		want = ServerStopStat
+	case vmv1.Start, vmv1.Recreate:
+		want = ServerRunStat
+	default:
+		return nil
+	}
+	if vm.Spec.Server == nil || stat == nil || stat.StackName == "" {
+		return nil
+	}
+	act := vm.Status.ServerAction
+	if act == nil || act.Phase != phase {
+		act = &vmv1.ActionStatus{
+			Phase: phase,
+		}
+		vm.Status.ServerAction = act
+	}
+	// recreate is not idempotent, do it only once
+	if phase == vmv1.Recreate && act.Stat == Succeeded {
+		return nil
+	}
+	if len(vm.Status.Members) == 0 {
+		return fmt.Errorf("not found members, can not %s", phase)
+	}
+	defer func() {
+		act.LastUpdateTime = time.Now().Format(time.RFC3339)
+	}()
+	for _, mem := range vm.Status.Members {
+		if mem.Id == "" {
+			continue
+		}
+		res, synced := p.getVm(stat.StackName, mem.Id)
+		if res == nil || !synced {
+			done = false
+			continue
+		}
+		if res.Stat == ServerErrStat {
+			act.Stat = Failed
+			act.Message = fmt.Sprintf("member %s is in %s stat", mem.Id, ServerErrStat)
+			return fmt.Errorf(act.Message)
+		}
+		if phase == vmv1.Recreate && !hasString(act.Members, mem.Id) {
+			err := p.rebuild(mem.Id, vm.Spec.Server)
+			if err != nil {
+				act.Stat = Failed
+				act.Message = err.Error()
+				return err
+			}
+			act.Members = append(act.Members, mem.Id)
+			p.listenById(stat.StackName, mem.Id)
+			done = false
+			continue
+		}
+		if res.Stat == want {
+			continue
+		}
+		done = false
+		if phase == vmv1.Recreate {
+			continue
+		}
+		// only send action on stable stat, others wait next sync
+		if (phase == vmv1.Stop && res.Stat == ServerRunStat) ||
+			(phase == vmv1.Start && res.Stat == ServerStopStat) {
+			err := p.startstop(mem.Id, phase)
+			if err != nil {
+				act.Stat = Failed
+				act.Message = err.Error()
+				return err
+			}
+			p.listenById(stat.StackName, mem.Id)
+		}
+	}
+	act.Message = ""
+	if done {
+		klog.V(2).Infof("%s members on %s done", phase, stat.StackName)
+		act.Stat = Succeeded
+	} else {
+		act.Stat = Processing
+	}
 	return nil
 }
 
+func (p *Nova) startstop(id string, phase vmv1.AssemblyPhaseType) error {
+	var err error
+	p.mgr.WrapClient(func(cli *gophercloud.ProviderClient) {
+		novacli, rerr := openstack.NewComputeV2(cli, gophercloud.EndpointOpts{})
+		if rerr != nil {
+			err = rerr
+			return
+		}
+		klog.V(2).Infof("%s server id(%v)", phase, id)
+		if phase == vmv1.Stop {
+			err = startstop.Stop(novacli, id).ExtractErr()
+		} else {
+			err = startstop.Start(novacli, id).ExtractErr()
+		}
+	})
+	if err != nil {
+		// server is in task stat, should wait next sync
+		if _, ok := err.(gophercloud.ErrDefault409); ok {
+			return nil
+		}
+		klog.Errorf("%s server %s failed:%v", phase, id, err)
+	}
+	return err
+}
+
+// rebuild server with the image which server used, the image of volume
+// backed server is found on its root volume, and fallback to boot image
+// on spec if not found. Rebuild volume backed server needs compute api 2.93.
+func (p *Nova) rebuild(id string, spec *vmv1.ServerSpec) error {
+	var err error
+	p.mgr.WrapClient(func(cli *gophercloud.ProviderClient) {
+		c := &directClient{provider: cli}
+		novacli, rerr := c.computeV2()
+		if rerr != nil {
+			err = rerr
+			return
+		}
+		novacli.Microversion = rebuildMicroversion
+		sv, rerr := servers.Get(novacli, id).Extract()
+		if rerr != nil {
+			err = rerr
+			return
+		}
+		image, rerr := serverImage(c, sv, spec.BootImage)
+		if rerr != nil {
+			err = fmt.Errorf("can not recreate server %s: %v", id, rerr)
+			return
+		}
+		klog.V(2).Infof("rebuild server id(%v) by image(%v)", id, image)
+		err = servers.Rebuild(novacli, id, servers.RebuildOpts{
+			ImageRef:  image,
+			AdminPass: spec.AdminPass,
+		}).Err
+		if err != nil && len(sv.AttachedVolumes) != 0 {
+			err = fmt.Errorf("rebuild volume backed server %s needs compute api %s: %v", id, rebuildMicroversion, err)
+		}
+	})
+	if err != nil {
+		klog.Errorf("rebuild server %s failed:%v", id, err)
+	}
+	return err
+}
+
+// serverImage find the image which server booted from
+func serverImage(c *directClient, sv *servers.Server, bootImage string) (string, error) {
+	if imgid, ok := sv.Image["id"].(string); ok && imgid != "" {
+		return imgid, nil
+	}
+	if len(sv.AttachedVolumes) != 0 {
+		volcli, err := c.volumeV3()
+		if err != nil {
+			return "", err
+		}
+		for _, att := range sv.AttachedVolumes {
+			vol, err := volumes.Get(volcli, att.ID).Extract()
+			if err != nil {
+				return "", err
+			}
+			if vol.Bootable == "true" && vol.VolumeImageMetadata["image_id"] != "" {
+				return vol.VolumeImageMetadata["image_id"], nil
+			}
+		}
+	}
+	if bootImage == "" {
+		return "", fmt.Errorf("not found image of root volume or boot image")
+	}
+	return c.imageID(bootImage)
+}
+
+// NOTE: member index should be stable when scale
+//
+// scale down will remove members on deleteMembers first, then the tail.
+// scale up will use the lowest index which is not used and not in deleteMembers.
+func reorderServer(spec *vmv1.VirtualMachineSpec, stat *vmv1.ResourceStatus) {
+	if spec.Server == nil {
+		return
+	}
+	var (
+		server   = spec.Server
+		nodes    []int32
+		dels     = make(map[int32]struct{})
+		nodemaps = make(map[int32]struct{})
+	)
+	for _, v := range server.DeleteMembers {
+		dels[v] = struct{}{}
+	}
+	if stat != nil && stat.Template != "" {
+		template.FindVmNodes(util.Str2bytes(stat.Template), func(index int) {
+			idx := int32(index)
+			if _, ok := dels[idx]; ok {
+				klog.V(2).Infof("remove member index %d", idx)
+				return
+			}
+			nodes = append(nodes, idx)
+		})
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i] < nodes[j]
+	})
+	if int32(len(nodes)) > server.Replicas {
+		nodes = nodes[:server.Replicas]
+	}
+	for _, v := range nodes {
+		nodemaps[v] = struct{}{}
+	}
+	for i := int32(0); int32(len(nodes)) < server.Replicas; i++ {
+		if _, ok := nodemaps[i]; ok {
+			continue
+		}
+		if _, ok := dels[i]; ok {
+			continue
+		}
+		nodes = append(nodes, i)
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i] < nodes[j]
+	})
+	klog.V(3).Infof("server member index list: %v", nodes)
+	server.Nodes = nodes
+}
+
+func hasString(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func defaultVmSpec(spec *vmv1.ServerSpec) {
+	if spec.Name == "" {
+		spec.Name = "vm"
+	}
+}
+
 func validVmSpec(spec *vmv1.ServerSpec) error {
 	if spec.BootImage == "" && spec.BootVolumeId == "" {
 		return fmt.Errorf("Boot image or boot volume must not nil both!")
 	}
+	if spec.BootVolumeId == "" && spec.BootVolume == nil {
+		return fmt.Errorf("boot volume must be set when use boot image")
+	}
+	if spec.Replicas < 0 {
+		return fmt.Errorf("replicas should not be less than 0")
+	}
+	if spec.Expose != nil {
+		return validExpose(spec.Expose)
+	}
+	return nil
+}
+
+func validExpose(expose *vmv1.ExposeSpec) error {
+	if len(expose.Ports) == 0 {
+		return fmt.Errorf("ports are required by expose")
+	}
+	for _, port := range expose.Ports {
+		if port.Port > 65535 || port.Port <= 0 {
+			return fmt.Errorf("expose port should be less than 65535 and bigger than 0")
+		}
+		switch port.Protocol {
+		case "", string(corev1.ProtocolTCP), string(corev1.ProtocolUDP), string(corev1.ProtocolSCTP):
+		default:
+			return fmt.Errorf("expose protocol should be TCP, UDP or SCTP")
+		}
+	}
 	return nil
 }
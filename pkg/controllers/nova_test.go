@@ -0,0 +1,142 @@
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	vmv1 "easystack.io/vm-operator/pkg/api/v1"
+	"easystack.io/vm-operator/pkg/fake"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
+	"github.com/gophercloud/gophercloud/pagination"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestStoreVmsAfterAction(t *testing.T) {
+	p := &Nova{
+		vms:    map[string]map[string]*VmResult{"vm": {"1": {Id: "1", Name: "vm", Stat: ServerStopStat}}},
+		listed: make(map[string]bool),
+		owners: make(map[string]types.NamespacedName),
+		notify: newNotifier(),
+	}
+	page := servers.ServerPage{LinkedPageBase: pagination.LinkedPageBase{PageResult: pagination.PageResult{
+		Result: gophercloud.Result{Body: map[string]interface{}{
+			"servers": []interface{}{
+				map[string]interface{}{"id": "1", "name": "vm", "status": ServerStopStat},
+			},
+		}},
+	}}}
+
+	// list is in flight when action sent
+	start := time.Now().Add(-time.Second)
+	p.listenById("vm", "1")
+	p.storeVms(page, start)
+	if _, synced := p.getVm("vm", "1"); synced {
+		t.Errorf("server should not be synced by list started before action")
+	}
+	p.storeVms(page, time.Now())
+	if _, synced := p.getVm("vm", "1"); !synced {
+		t.Errorf("server should be synced by list started after action")
+	}
+}
+
+// act on members like reconcile until the action is done
+func actionUntil(t *testing.T, server *Server, vm *vmv1.VirtualMachine, phase vmv1.AssemblyPhaseType) (*vmv1.VirtualMachine, []string) {
+	var stats []string
+	vm.Spec.AssemblyPhase = phase
+	deadline := time.Now().Add(waitTimeout)
+	for time.Now().Before(deadline) {
+		obj := vm.DeepCopy()
+		server.ServerRecocile(obj)
+		server.Process(obj)
+		vm = obj
+		if act := vm.Status.ServerAction; act != nil && act.Phase == phase {
+			if len(stats) == 0 || stats[len(stats)-1] != act.Stat {
+				stats = append(stats, act.Stat)
+			}
+			if act.Stat == Succeeded || act.Stat == Failed {
+				return vm, stats
+			}
+		}
+		time.Sleep(waitInterval)
+	}
+	t.Fatalf("wait %s timeout, action: %v", phase, vm.Status.ServerAction)
+	return nil, nil
+}
+
+func TestServerAction(t *testing.T) {
+	op, server, stop := newFakeServer(t)
+	defer stop()
+
+	vm := &vmv1.VirtualMachine{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   "default",
+			Name:        "action",
+			Annotations: map[string]string{BackendAnnotation: DirectBackend},
+		},
+		Spec: vmv1.VirtualMachineSpec{
+			Auth: &vmv1.AuthSpec{
+				ProjectID: fake.ProjectID,
+				Token:     fake.Token,
+			},
+			Server: &vmv1.ServerSpec{
+				Replicas:  2,
+				BootImage: "image",
+				BootVolume: &vmv1.VolumeSpec{
+					VolumeSize:       10,
+					VolumeDeleteByVm: true,
+				},
+				Flavor: "flavor",
+				Subnet: &vmv1.SubnetSpec{
+					NetworkName: "private",
+					SubnetId:    "subnet",
+				},
+			},
+			AssemblyPhase: vmv1.Creating,
+		},
+	}
+	defaultVm(vm)
+
+	vm = processUntil(t, server, vm, func(vm *vmv1.VirtualMachine) bool {
+		return vm.Status.Phase == PhaseReady
+	})
+	expect := func(phase vmv1.AssemblyPhaseType, stats []string, want string) {
+		if len(stats) != 2 || stats[0] != Processing || stats[1] != Succeeded {
+			t.Errorf("%s should be processing then succeeded, but %v: %v", phase, stats, vm.Status.ServerAction)
+		}
+		for _, sv := range op.Servers() {
+			if sv.Status != want {
+				t.Errorf("server %s should be %s after %s, but %s", sv.ID, want, phase, sv.Status)
+			}
+		}
+	}
+
+	var stats []string
+	vm, stats = actionUntil(t, server, vm, vmv1.Stop)
+	expect(vmv1.Stop, stats, ServerStopStat)
+	vm, stats = actionUntil(t, server, vm, vmv1.Start)
+	expect(vmv1.Start, stats, ServerRunStat)
+
+	// volume backed members are rebuilt by image of root volume
+	ids := make(map[string]bool)
+	for _, sv := range op.Servers() {
+		ids[sv.ID] = true
+	}
+	vm, stats = actionUntil(t, server, vm, vmv1.Recreate)
+	expect(vmv1.Recreate, stats, ServerRunStat)
+	if act := vm.Status.ServerAction; len(act.Members) != 2 {
+		t.Errorf("all members should be rebuilt once, but %v", act.Members)
+	}
+	for _, sv := range op.Servers() {
+		if !ids[sv.ID] {
+			t.Errorf("server %s should be rebuilt in place", sv.ID)
+		}
+	}
+	// recreate is done only once
+	vm, stats = actionUntil(t, server, vm, vmv1.Recreate)
+	if len(stats) != 1 || stats[0] != Succeeded {
+		t.Errorf("recreate should not be done again, but %v", stats)
+	}
+}
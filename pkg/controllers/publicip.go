@@ -2,14 +2,19 @@ package controllers
 
 import (
 	"fmt"
+	"net"
 	"sync"
 
 	vmv1 "easystack.io/vm-operator/pkg/api/v1"
 	"easystack.io/vm-operator/pkg/manage"
+	"easystack.io/vm-operator/pkg/metrics"
 	"easystack.io/vm-operator/pkg/util"
 
 	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/layer3/floatingips"
 	"github.com/gophercloud/gophercloud/pagination"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	klog "k8s.io/klog/v2"
 )
 
@@ -23,6 +28,8 @@ type FipResult struct {
 	unbind bool
 	//had sync or not
 	sync bool
+	// virtual machine which the floating ip belong to
+	owner types.NamespacedName
 }
 
 func (s *FipResult) DeepCopyFrom(ls *floatingips.FloatingIP) {
@@ -38,16 +45,19 @@ func (s *FipResult) DeepCopy() *FipResult {
 	tmp.Status = s.Status
 	tmp.Ip = s.Ip
 	tmp.PortId = s.PortId
+	tmp.owner = s.owner
 	return tmp
 }
 
 // Equal floatingip resource on openstack
 type Floatip struct {
-	mgr    *manage.OpenMgr
-	k8smgr *manage.K8sMgr
-	Lb     *LoadBalance
-	heat   *Heat
-	portop *port
+	mgr      *manage.OpenMgr
+	k8smgr   *manage.K8sMgr
+	Lb       *LoadBalance
+	backend  *backends
+	portop   *port
+	notify   *notifier
+	recorder record.EventRecorder
 
 	fmu sync.RWMutex
 
@@ -62,16 +72,18 @@ type Floatip struct {
 	statics map[string]string
 }
 
-func NewFloatip(heat *Heat, mgr *manage.OpenMgr, k8smgr *manage.K8sMgr, Lb *LoadBalance) *Floatip {
+func NewFloatip(backend *backends, mgr *manage.OpenMgr, k8smgr *manage.K8sMgr, Lb *LoadBalance, notify *notifier, recorder record.EventRecorder) *Floatip {
 	fip := &Floatip{
-		mgr:     mgr,
-		k8smgr:  k8smgr,
-		Lb:      Lb,
-		heat:    heat,
-		portop:  newPort(mgr),
-		fmu:     sync.RWMutex{},
-		caches:  make(map[string]*FipResult),
-		statics: make(map[string]string),
+		mgr:      mgr,
+		k8smgr:   k8smgr,
+		Lb:       Lb,
+		backend:  backend,
+		portop:   newPort(mgr),
+		notify:   notify,
+		recorder: recorder,
+		fmu:      sync.RWMutex{},
+		caches:   make(map[string]*FipResult),
+		statics:  make(map[string]string),
 	}
 	mgr.Regist(manage.Fip, fip.addFipStore)
 	return fip
@@ -84,13 +96,16 @@ func (p *Floatip) addFipStore(pages pagination.Page) {
 		klog.Errorf("floatingips extract page failed:%v", err)
 		return
 	}
+	var owners []types.NamespacedName
 	p.fmu.Lock()
-	defer p.fmu.Unlock()
 	exists := make(map[string]struct{}, len(p.caches))
 	for _, fip := range lists {
 		v, ok := p.caches[fip.PortID]
 		if ok {
 			klog.V(3).Infof("callback update floating ip stat: %v", fip)
+			if !v.sync || v.unbind || v.Status != fip.Status || v.Ip != fip.FloatingIP {
+				owners = append(owners, v.owner)
+			}
 			v.DeepCopyFrom(&fip)
 			exists[fip.PortID] = struct{}{}
 			v.unbind = false
@@ -101,11 +116,17 @@ func (p *Floatip) addFipStore(pages pagination.Page) {
 		}
 	}
 	for k, v := range p.caches {
-		v.sync = true
 		if _, ok := exists[k]; !ok {
+			if !v.unbind {
+				owners = append(owners, v.owner)
+			}
 			v.unbind = true
 		}
+		v.sync = true
 	}
+	metrics.SetCacheSize(manage.Fip.String(), len(p.caches))
+	p.fmu.Unlock()
+	p.notify.notify(owners...)
 	return
 }
 
@@ -125,7 +146,7 @@ func (p *Floatip) findFloatingId(ip string) string {
 }
 
 // add listen by portid
-func (p *Floatip) listenByPortId(portid string, stat *vmv1.ResourceStatus) string {
+func (p *Floatip) listenByPortId(portid string, stat *vmv1.ResourceStatus, owner types.NamespacedName) string {
 	var fipres *FipResult
 	if stat != nil {
 		fipres = &FipResult{
@@ -136,8 +157,9 @@ func (p *Floatip) listenByPortId(portid string, stat *vmv1.ResourceStatus) strin
 	} else {
 		fipres = new(FipResult)
 	}
-	p.fmu.RLock()
-	defer p.fmu.RUnlock()
+	fipres.owner = owner
+	p.fmu.Lock()
+	defer p.fmu.Unlock()
 	_, ok := p.caches[portid]
 	if !ok {
 		klog.V(2).Infof("listen floating ip on portid: %v", portid)
@@ -147,10 +169,12 @@ func (p *Floatip) listenByPortId(portid string, stat *vmv1.ResourceStatus) strin
 }
 
 // update stat resStat from cache
-func (p *Floatip) update(spec *vmv1.PublicSepc, stat *vmv1.ResourceStatus) {
+func (p *Floatip) update(vm *vmv1.VirtualMachine) {
 	var (
-		v  *FipResult
-		ok bool
+		spec = vm.Spec.Public
+		stat = vm.Status.PubStatus
+		v    *FipResult
+		ok   bool
 	)
 	p.fmu.RLock()
 	defer p.fmu.RUnlock()
@@ -164,6 +188,9 @@ func (p *Floatip) update(spec *vmv1.PublicSepc, stat *vmv1.ResourceStatus) {
 	klog.V(3).Infof("update floating ip ResourceStatus %v", v)
 
 	if v.unbind {
+		if stat.ServerStat.Ip != "" {
+			p.recorder.Eventf(vm, corev1.EventTypeWarning, ReasonFipUnbind, "floating ip %s had been unbound from port %s", stat.ServerStat.Ip, spec.PortId)
+		}
 		stat.ServerStat = vmv1.ServerStat{}
 		klog.V(2).Infof("portid(%v) had unbinded frpm floating ip!", spec.PortId)
 		return
@@ -173,6 +200,15 @@ func (p *Floatip) update(spec *vmv1.PublicSepc, stat *vmv1.ResourceStatus) {
 	stat.ServerStat.Id = v.ID
 }
 
+// floating ip of load balance is ingress of service on link
+func (p *Floatip) setLinkIp(vm *vmv1.VirtualMachine) {
+	lb := vm.Spec.LoadBalance
+	if lb == nil || lb.Link == "" || vm.Spec.Public.Link != "" || vm.Status.PubStatus == nil {
+		return
+	}
+	p.k8smgr.SetPublicIp(lb.Link, net.ParseIP(vm.Status.PubStatus.ServerStat.Ip))
+}
+
 func (p *Floatip) Process(vm *vmv1.VirtualMachine) (reterr error) {
 	var (
 		spec                     = vm.Spec.Public
@@ -185,6 +221,7 @@ func (p *Floatip) Process(vm *vmv1.VirtualMachine) (reterr error) {
 	if spec == nil {
 		return nil
 	}
+	defaultPip(spec)
 
 	defer func() {
 		//Remove stack if pod link not exist
@@ -198,11 +235,12 @@ func (p *Floatip) Process(vm *vmv1.VirtualMachine) (reterr error) {
 				p.fmu.Lock()
 				delete(p.caches, id)
 				p.fmu.Unlock()
-				reterr = p.heat.Process(manage.Fip, vm)
+				reterr = p.backend.delete(manage.Fip, vm)
 			}
 		} else {
 			if reterr == nil && id != "" {
-				p.update(spec, vm.Status.PubStatus)
+				p.update(vm)
+				p.setLinkIp(vm)
 			}
 		}
 	}()
@@ -223,8 +261,9 @@ func (p *Floatip) Process(vm *vmv1.VirtualMachine) (reterr error) {
 	}
 	err := validPip(spec)
 	if err != nil {
-		return err
+		return invalidSpec(err)
 	}
+	resetPip(spec)
 
 	if spec.Link == "" {
 		// Try find {portId,fixip} from loadbalance info
@@ -277,7 +316,7 @@ func (p *Floatip) Process(vm *vmv1.VirtualMachine) (reterr error) {
 	// 1. floating ip id (which only need when create FloatingIPAssociation)
 	// 2. port id
 	// 3. lb ip (fix address ip)
-	p.listenByPortId(spec.PortId, stat)
+	p.listenByPortId(spec.PortId, stat, ownerOf(vm))
 	if spec.Address == nil {
 		justCheckSync = true
 	}
@@ -300,7 +339,7 @@ func (p *Floatip) Process(vm *vmv1.VirtualMachine) (reterr error) {
 		}
 	}
 
-	return p.heat.Process(manage.Fip, vm)
+	return p.backend.process(manage.Fip, vm)
 }
 
 func (p *Floatip) Stat(vm *vmv1.VirtualMachine) *vmv1.ResourceStatus {
@@ -310,15 +349,37 @@ func (p *Floatip) Stat(vm *vmv1.VirtualMachine) *vmv1.ResourceStatus {
 	return vm.Status.PubStatus
 }
 
+func defaultPip(pi *vmv1.PublicSepc) {
+	if pi.Address == nil {
+		pi.Address = &vmv1.Address{}
+	}
+}
+
 func validPip(pi *vmv1.PublicSepc) error {
 	if pi.Address != nil {
 		if pi.Address.Allocate == true && pi.Address.Ip != "" {
 			return fmt.Errorf("address allocate and ip must can not both setted.")
 		}
+		if pi.Address.Ip != "" && net.ParseIP(pi.Address.Ip) == nil {
+			return fmt.Errorf("parse address ip(%v) failed", pi.Address.Ip)
+		}
 	}
+	if pi.Link != "" {
+		k8res := &manage.Resource{}
+		err := manage.ParseLink(pi.Link, k8res)
+		if err != nil {
+			return fmt.Errorf("parse link(%v) failed:%v", pi.Link, err)
+		}
+		if !k8res.IsResource(manage.Pod) {
+			return fmt.Errorf("floating Ip only support pod link!")
+		}
+	}
+	return nil
+}
 
+// those are generated by operator, should not be setted by user
+func resetPip(pi *vmv1.PublicSepc) {
 	pi.Name = ""
 	pi.PortId = ""
 	pi.FixIp = ""
-	return nil
 }
@@ -7,8 +7,13 @@ import (
 
 	vmv1 "easystack.io/vm-operator/pkg/api/v1"
 	"easystack.io/vm-operator/pkg/manage"
+	"easystack.io/vm-operator/pkg/metrics"
 	"easystack.io/vm-operator/pkg/template"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	klog "k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
 const (
@@ -21,19 +26,30 @@ type Server struct {
 	fip            *Floatip
 	k8smgr         *manage.K8sMgr
 	opmgr          *manage.OpenMgr
+	notify         *notifier
+	recorder       record.EventRecorder
 	k8sync, opsync time.Duration
 	enablelead     bool
 }
 
-func NewServer(engine *template.Template, tmpdir string, k8smgr *manage.K8sMgr, enableleader bool, k8sync, opsync time.Duration) *Server {
-	opmgr := manage.NewOpMgr()
-	heat := NewHeat(engine, tmpdir, opmgr)
-	nova := NewNova(heat, opmgr)
-	lb := NewLoadBalance(heat, opmgr, k8smgr, nova)
-	fip := NewFloatip(heat, opmgr, k8smgr, lb)
+// backend is used by new resources which not set backend annotation,
+// and lbapi is neutron, octavia or auto
+func NewServer(engine *template.Template, tmpdir, backend, lbapi string, k8smgr *manage.K8sMgr, recorder record.EventRecorder, enableleader bool, k8sync, opsync time.Duration) *Server {
+	opmgr := manage.NewOpMgr(lbapi)
+	notify := newNotifier()
+	heat := NewHeat(engine, tmpdir, opmgr, k8smgr, notify, recorder)
+	bs := newBackends(backend, heat, NewDirect(heat, recorder))
+	nova := NewNova(heat, bs, opmgr, notify, recorder)
+	lb := NewLoadBalance(heat, bs, NewBarbican(heat), opmgr, k8smgr, nova, notify, recorder)
+	fip := NewFloatip(bs, opmgr, k8smgr, lb, notify, recorder)
+	k8smgr.Regist(func(owner *corev1.ObjectReference) {
+		notify.notify(types.NamespacedName{Namespace: owner.Namespace, Name: owner.Name})
+	})
 	return &Server{
 		k8smgr:     k8smgr,
 		opmgr:      opmgr,
+		notify:     notify,
+		recorder:   recorder,
 		nova:       nova,
 		k8sync:     k8sync,
 		opsync:     opsync,
@@ -43,36 +59,126 @@ func NewServer(engine *template.Template, tmpdir string, k8smgr *manage.K8sMgr,
 	}
 }
 
+// ServerRecocile stop, start or recreate members by assemblyPhase
 func (m *Server) ServerRecocile(vm *vmv1.VirtualMachine) {
-	//todo
+	if vm.Spec.Auth == nil {
+		return
+	}
+	err := m.nova.Action(vm)
+	if err != nil {
+		klog.Errorf("%s members failed:%v", vm.Spec.AssemblyPhase, err)
+	}
 }
 
+// Process sync openstack resources, and update conditions by status
 func (m *Server) Process(vm *vmv1.VirtualMachine) error {
+	op, err := m.process(vm)
+	setStackMetrics(vm)
+	setConditions(vm)
+	updateCondition(vm, op, err)
+	if op == OpCheck {
+		return nil
+	}
+	return err
+}
+
+func (m *Server) process(vm *vmv1.VirtualMachine) (string, error) {
 	var (
 		err error
 	)
 	if vm.Spec.Auth == nil {
-		updateCondition(&vm.Status, OpCheck, fmt.Errorf("not found auth info"))
-		return nil
+		return OpCheck, fmt.Errorf("not found auth info")
 	}
+	if vm.Spec.Auth.Token == "" && vm.Spec.Auth.SecretRef == nil {
+		return OpCheck, fmt.Errorf("not found token or secretRef in auth")
+	}
+	start := time.Now()
 	err = m.nova.Process(vm)
+	metrics.ObserveReconcile(manage.Vm.String(), start, err)
+	if err != nil {
+		return manage.Vm.String(), err
+	}
+	err = m.expose(vm)
 	if err != nil {
-		updateCondition(&vm.Status, manage.Vm.String(), err)
-		return err
+		return manage.Vm.String(), err
 	}
+	start = time.Now()
 	err = m.lb.Process(vm)
+	metrics.ObserveReconcile(manage.Lb.String(), start, err)
 	if err != nil {
-		updateCondition(&vm.Status, manage.Lb.String(), err)
-		return err
+		return manage.Lb.String(), err
 	}
+	start = time.Now()
 	err = m.fip.Process(vm)
+	metrics.ObserveReconcile(manage.Fip.String(), start, err)
+	if err != nil {
+		return manage.Fip.String(), err
+	}
+	return "", nil
+}
+
+// sync service of members, the old one is removed when name changed
+// or expose is removed
+func (m *Server) expose(vm *vmv1.VirtualMachine) error {
+	var (
+		spec = vm.Spec.Server
+		name string
+	)
+	if spec != nil && spec.Expose != nil && vm.DeletionTimestamp == nil {
+		name = spec.Expose.Name
+		if name == "" {
+			name = vm.Name
+		}
+	}
+	if old := vm.Status.ExposeService; old != "" && old != name {
+		klog.Infof("remove service %s/%s which expose members", vm.Namespace, old)
+		err := m.k8smgr.Unexpose(ownerRef(vm), old)
+		if _, ok := err.(*manage.NotOwnedError); ok {
+			// taken over by others, leave it
+			m.recorder.Eventf(vm, corev1.EventTypeWarning, ReasonExposeConflict, "skip removing: %v", err)
+			err = nil
+		}
+		if err != nil {
+			return fmt.Errorf("remove service %s failed:%v", old, err)
+		}
+		vm.Status.ExposeService = ""
+	}
+	if name == "" {
+		return nil
+	}
+	err := m.k8smgr.Expose(ownerRef(vm), name, spec.Expose.Ports, m.nova.GetActiveIps(vm))
+	if _, ok := err.(*manage.NotOwnedError); ok {
+		m.recorder.Eventf(vm, corev1.EventTypeWarning, ReasonExposeConflict, "%v", err)
+		return invalidSpec(fmt.Errorf("expose members failed:%v", err))
+	}
 	if err != nil {
-		updateCondition(&vm.Status, manage.Fip.String(), err)
-		return err
+		return fmt.Errorf("expose members failed:%v", err)
 	}
+	vm.Status.ExposeService = name
 	return nil
 }
 
+// record stack status of virtual machine
+func setStackMetrics(vm *vmv1.VirtualMachine) {
+	key := ownerOf(vm).String()
+	for kind, stat := range map[manage.OpResource]*vmv1.ResourceStatus{
+		manage.Vm:  vm.Status.VmStatus,
+		manage.Lb:  vm.Status.NetStatus,
+		manage.Fip: vm.Status.PubStatus,
+	} {
+		var status string
+		if stat != nil && stat.StackID != "" {
+			status = stat.Stat
+		}
+		metrics.SetStackStatus(key, kind.String(), status)
+	}
+}
+
+// Source is fed when openstack resources of virtual machine changed
+func (m *Server) Source() source.Source {
+	return m.notify.source()
+}
+
 func (m *Server) NeedLeaderElection() bool {
 	return m.enablelead
 }
@@ -91,22 +197,3 @@ func (m *Server) Start(ctx context.Context) error {
 	}()
 	return nil
 }
-
-func updateCondition(stat *vmv1.VirtualMachineStatus, op string, err error) {
-	if err == nil {
-		return
-	}
-	resstr := op
-	for _, cond := range stat.Conditions {
-		if cond.Type == resstr {
-			if cond.Reason == err.Error() {
-				return
-			}
-		}
-	}
-	stat.Conditions = append(stat.Conditions, &vmv1.Condition{
-		LastUpdateTime: time.Now().Format(time.RFC3339),
-		Reason:         err.Error(),
-		Type:           resstr,
-	})
-}
@@ -0,0 +1,337 @@
+package controllers
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	vmv1 "easystack.io/vm-operator/pkg/api/v1"
+	"easystack.io/vm-operator/pkg/fake"
+	"easystack.io/vm-operator/pkg/manage"
+	"easystack.io/vm-operator/pkg/template"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	waitTimeout  = 10 * time.Second
+	waitInterval = 20 * time.Millisecond
+)
+
+func newFakeServer(t *testing.T, setups ...func(op *fake.OpenStack)) (*fake.OpenStack, *Server, func()) {
+	op := fake.NewOpenStack()
+	for _, setup := range setups {
+		setup(op)
+	}
+	for k, v := range op.Env() {
+		os.Setenv(k, v)
+	}
+	engine := template.NewTemplate()
+	engine.AddTempFileMust(template.Vm, "../template/files/vm.tpl")
+	engine.AddTempFileMust(template.Lb, "../template/files/loadbalance.tpl")
+	engine.AddTempFileMust(template.Fip, "../template/files/fip.tpl")
+
+	recorder := record.NewFakeRecorder(1024)
+	k8smgr := manage.NewK8sMgr(dynamicfake.NewSimpleDynamicClient(runtime.NewScheme()), recorder)
+	server := NewServer(engine, os.TempDir(), HeatBackend, manage.LbApiAuto, k8smgr, recorder, false, time.Hour, waitInterval)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	err := server.Start(ctx)
+	if err != nil {
+		t.Fatalf("start server failed: %v", err)
+	}
+	return op, server, func() {
+		cancel()
+		op.Close()
+	}
+}
+
+// process virtual machine like reconcile, spec is always fetched from apiserver
+func processUntil(t *testing.T, server *Server, vm *vmv1.VirtualMachine, fn func(vm *vmv1.VirtualMachine) bool) *vmv1.VirtualMachine {
+	spec := vm.Spec.DeepCopy()
+	deadline := time.Now().Add(waitTimeout)
+	for time.Now().Before(deadline) {
+		obj := vm.DeepCopy()
+		spec.DeepCopyInto(&obj.Spec)
+		server.Process(obj)
+		vm = obj
+		if fn(vm) {
+			return vm
+		}
+		time.Sleep(waitInterval)
+	}
+	t.Fatalf("wait timeout, phase: %s, conditions: %v", vm.Status.Phase, vm.Status.Conditions)
+	return nil
+}
+
+func TestServerProcess(t *testing.T) {
+	op, server, stop := newFakeServer(t)
+	defer stop()
+
+	vm := &vmv1.VirtualMachine{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      "test",
+		},
+		Spec: vmv1.VirtualMachineSpec{
+			Auth: &vmv1.AuthSpec{
+				ProjectID: fake.ProjectID,
+				Token:     fake.Token,
+			},
+			Server: &vmv1.ServerSpec{
+				Replicas:  2,
+				BootImage: "image",
+				BootVolume: &vmv1.VolumeSpec{
+					VolumeSize: 10,
+				},
+				Flavor: "flavor",
+				Subnet: &vmv1.SubnetSpec{
+					NetworkName: "private",
+					SubnetId:    "subnet",
+				},
+			},
+			LoadBalance: &vmv1.LoadBalanceSpec{
+				Subnet: &vmv1.SubnetSpec{
+					SubnetId: "subnet",
+				},
+				Ports: []*vmv1.PortMap{{Port: 80, Protocol: "TCP"}},
+			},
+			Public: &vmv1.PublicSepc{
+				Address: &vmv1.Address{Allocate: true},
+			},
+			AssemblyPhase: vmv1.Creating,
+		},
+	}
+	defaultVm(vm)
+
+	vm = processUntil(t, server, vm, func(vm *vmv1.VirtualMachine) bool {
+		return vm.Status.Phase == PhaseReady
+	})
+	if len(vm.Status.Members) != 2 {
+		t.Errorf("members should be 2, but %v", vm.Status.Members)
+	}
+	for i, mem := range vm.Status.Members {
+		if mem.Index == nil || *mem.Index != int32(i) || mem.Ip == "" {
+			t.Errorf("member %d is not expected: %v", i, mem)
+		}
+	}
+	// members are selected by scale subresource
+	if vm.Status.Selector != ownerMetaKey+"=test" {
+		t.Errorf("selector is not expected: %s", vm.Status.Selector)
+	}
+	for _, sv := range op.Servers() {
+		if sv.Metadata[ownerMetaKey] != "test" {
+			t.Errorf("owner should be in metadata of server %s: %v", sv.ID, sv.Metadata)
+		}
+	}
+	if len(op.LoadBalancers()) != 1 || len(op.FloatingIPs()) != 1 {
+		t.Errorf("should create one load balance and floating ip")
+	}
+	if vm.Status.PubStatus.ServerStat.Ip != op.FloatingIPs()[0].FloatingIP {
+		t.Errorf("floating ip should be %s, but %v", op.FloatingIPs()[0].FloatingIP, vm.Status.PubStatus.ServerStat)
+	}
+
+	// member status is synced from status tree of load balance
+	vm = processUntil(t, server, vm, func(vm *vmv1.VirtualMachine) bool {
+		return len(vm.Status.LbMembers) == 2
+	})
+	for _, mem := range vm.Status.LbMembers {
+		if mem.OperatingStatus != LbOnline || mem.MemberId == "" || mem.Port != 80 {
+			t.Errorf("pool member is not expected: %v", mem)
+		}
+	}
+	failed := vm.Status.LbMembers[0].Address
+	op.SetMemberStatus(failed, "ERROR")
+	vm = processUntil(t, server, vm, func(vm *vmv1.VirtualMachine) bool {
+		return vm.Status.LbMembers[0].OperatingStatus == "ERROR"
+	})
+	if vm.Status.LbMembers[1].OperatingStatus != LbOnline {
+		t.Errorf("pool member %s should be online", vm.Status.LbMembers[1].Address)
+	}
+
+	// scale down and remove the first member
+	vm.Spec.Server.Replicas = 1
+	vm.Spec.Server.DeleteMembers = []int32{0}
+	vm = processUntil(t, server, vm, func(vm *vmv1.VirtualMachine) bool {
+		return vm.Status.Phase == PhaseReady && len(vm.Status.Members) == 1
+	})
+	if *vm.Status.Members[0].Index != 1 {
+		t.Errorf("member index should be 1, but %d", *vm.Status.Members[0].Index)
+	}
+
+	// stack failed
+	op.FailStack(vm.Status.VmStatus.StackName, "Resource CREATE failed: quota exceeded")
+	vm.Spec.Server.Replicas = 2
+	vm = processUntil(t, server, vm, func(vm *vmv1.VirtualMachine) bool {
+		return vm.Status.Phase == PhaseFailed
+	})
+
+	// secret of auth is removed with namespace, stacks are deleted by operator
+	vm.Spec.Auth = &vmv1.AuthSpec{SecretRef: &vmv1.SecretRef{Name: "removed"}}
+	now := metav1.Now()
+	vm.DeletionTimestamp = &now
+	processUntil(t, server, vm, func(vm *vmv1.VirtualMachine) bool {
+		return len(op.Stacks()) == 0
+	})
+	if len(op.Servers()) != 0 || len(op.LoadBalancers()) != 0 || len(op.FloatingIPs()) != 0 {
+		t.Errorf("resources should be removed with stacks")
+	}
+}
+
+func TestServerProcessDirect(t *testing.T) {
+	op, server, stop := newFakeServer(t)
+	defer stop()
+
+	vm := &vmv1.VirtualMachine{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   "default",
+			Name:        "direct",
+			Annotations: map[string]string{BackendAnnotation: DirectBackend},
+		},
+		Spec: vmv1.VirtualMachineSpec{
+			Auth: &vmv1.AuthSpec{
+				ProjectID: fake.ProjectID,
+				Token:     fake.Token,
+			},
+			Server: &vmv1.ServerSpec{
+				Replicas:  2,
+				BootImage: "image",
+				BootVolume: &vmv1.VolumeSpec{
+					VolumeSize:       10,
+					VolumeDeleteByVm: true,
+				},
+				Flavor: "flavor",
+				Subnet: &vmv1.SubnetSpec{
+					NetworkName: "private",
+					SubnetId:    "subnet",
+				},
+			},
+			LoadBalance: &vmv1.LoadBalanceSpec{
+				Subnet: &vmv1.SubnetSpec{
+					SubnetId: "subnet",
+				},
+				Ports: []*vmv1.PortMap{{Port: 80, Protocol: "TCP"}},
+			},
+			Public: &vmv1.PublicSepc{
+				Address: &vmv1.Address{Allocate: true},
+				Subnet:  &vmv1.SubnetSpec{NetworkId: "public"},
+			},
+			AssemblyPhase: vmv1.Creating,
+		},
+	}
+	defaultVm(vm)
+
+	vm = processUntil(t, server, vm, func(vm *vmv1.VirtualMachine) bool {
+		return vm.Status.Phase == PhaseReady
+	})
+	if len(op.Stacks()) != 0 {
+		t.Errorf("stack should not be created by direct backend")
+	}
+	if vm.Status.VmStatus.Backend != DirectBackend || vm.Status.VmStatus.Stat != Succeeded {
+		t.Errorf("nova status is not expected: %v", vm.Status.VmStatus)
+	}
+	if len(vm.Status.Members) != 2 || len(op.Volumes()) != 2 {
+		t.Errorf("should create 2 members and volumes, but %v", vm.Status.Members)
+	}
+	if len(op.LoadBalancers()) != 1 || len(op.FloatingIPs()) != 1 {
+		t.Errorf("should create one load balance and floating ip")
+	}
+
+	// broken server and removed load balance are recreated
+	broken := op.Servers()[0].ID
+	op.SetServerStatus(broken, ServerErrStat)
+	op.DeleteLoadBalancer(op.LoadBalancers()[0].ID)
+	vm = processUntil(t, server, vm, func(vm *vmv1.VirtualMachine) bool {
+		if vm.Status.Phase != PhaseReady || len(op.Servers()) != 2 || len(op.LoadBalancers()) != 1 {
+			return false
+		}
+		for _, sv := range op.Servers() {
+			if sv.ID == broken || sv.Status != ServerRunStat {
+				return false
+			}
+		}
+		return true
+	})
+
+	vm.Spec.Server.Replicas = 1
+	vm.Spec.Server.DeleteMembers = []int32{0}
+	vm = processUntil(t, server, vm, func(vm *vmv1.VirtualMachine) bool {
+		return vm.Status.Phase == PhaseReady && len(vm.Status.Members) == 1
+	})
+	if *vm.Status.Members[0].Index != 1 || len(op.Volumes()) != 1 {
+		t.Errorf("member index should be 1, but %d", *vm.Status.Members[0].Index)
+	}
+
+	now := metav1.Now()
+	vm.DeletionTimestamp = &now
+	processUntil(t, server, vm, func(vm *vmv1.VirtualMachine) bool {
+		return len(op.Servers()) == 0 && len(op.LoadBalancers()) == 0 && len(op.FloatingIPs()) == 0
+	})
+	if len(op.Volumes()) != 0 || len(op.Ports()) != 0 {
+		t.Errorf("volumes and ports should be removed: %v %v", op.Volumes(), op.Ports())
+	}
+}
+
+func TestServerProcessOctavia(t *testing.T) {
+	op, server, stop := newFakeServer(t, (*fake.OpenStack).EnableOctavia)
+	defer stop()
+
+	for _, backend := range []string{HeatBackend, DirectBackend} {
+		vm := &vmv1.VirtualMachine{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace:   "default",
+				Name:        "octavia-" + backend,
+				Annotations: map[string]string{BackendAnnotation: backend},
+			},
+			Spec: vmv1.VirtualMachineSpec{
+				Auth: &vmv1.AuthSpec{
+					ProjectID: fake.ProjectID,
+					Token:     fake.Token,
+				},
+				Server: &vmv1.ServerSpec{
+					Replicas:  1,
+					BootImage: "image",
+					BootVolume: &vmv1.VolumeSpec{
+						VolumeSize:       10,
+						VolumeDeleteByVm: true,
+					},
+					Flavor: "flavor",
+					Subnet: &vmv1.SubnetSpec{
+						NetworkName: "private",
+						SubnetId:    "subnet",
+					},
+				},
+				LoadBalance: &vmv1.LoadBalanceSpec{
+					Subnet: &vmv1.SubnetSpec{
+						SubnetId: "subnet",
+					},
+					Ports: []*vmv1.PortMap{{Port: 80, Protocol: "TCP", TimeoutClientData: 1000}},
+				},
+				AssemblyPhase: vmv1.Creating,
+			},
+		}
+		defaultVm(vm)
+
+		vm = processUntil(t, server, vm, func(vm *vmv1.VirtualMachine) bool {
+			return vm.Status.Phase == PhaseReady
+		})
+		stat := vm.Status.NetStatus
+		if !strings.Contains(stat.Template, "OS::Octavia::LoadBalancer") {
+			t.Errorf("%s: load balance should be created by octavia: %s", backend, stat.Template)
+		}
+		if stat.ServerStat.ProvisioningStatus != "ACTIVE" || stat.ServerStat.OperatingStatus != LbOnline {
+			t.Errorf("%s: status of load balance is not expected: %v", backend, stat.ServerStat)
+		}
+
+		now := metav1.Now()
+		vm.DeletionTimestamp = &now
+		processUntil(t, server, vm, func(vm *vmv1.VirtualMachine) bool {
+			return len(op.Servers()) == 0 && len(op.LoadBalancers()) == 0
+		})
+	}
+}
@@ -0,0 +1,303 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	vmv1 "easystack.io/vm-operator/pkg/api/v1"
+	"easystack.io/vm-operator/pkg/manage"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	cli "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	klog "k8s.io/klog/v2"
+)
+
+const (
+	// annotations of LoadBalancer service, auth secret and subnet are required
+	SvcAuthSecretAnnotation   = "mixapp.easystack.io/auth-secret"
+	SvcSubnetAnnotation       = "mixapp.easystack.io/subnet-id"
+	SvcFloatNetworkAnnotation = "mixapp.easystack.io/floating-network-id"
+	SvcFloatIpAnnotation      = "mixapp.easystack.io/floating-ip"
+	svcVmPrefix               = "svc-"
+)
+
+var (
+	serviceGvk = corev1.SchemeGroupVersion.WithKind("Service")
+	// same with expose, v1 is served since kubernetes 1.21
+	endpointSliceGvk = schema.GroupVersionKind{Group: "discovery.k8s.io", Version: "v1", Kind: "EndpointSlice"}
+)
+
+// ServiceReconciler provision load balance for LoadBalancer service of
+// our class, by virtual machine which is owned by the service.
+// service is unstructured, because loadBalancerClass is not in client-go
+type ServiceReconciler struct {
+	cli.Client
+	ctx      context.Context
+	scheme   *runtime.Scheme
+	recorder record.EventRecorder
+}
+
+func NewService(mgr ctrl.Manager, recorder record.EventRecorder) *ServiceReconciler {
+	sr := &ServiceReconciler{
+		Client:   mgr.GetClient(),
+		ctx:      context.Background(),
+		scheme:   mgr.GetScheme(),
+		recorder: recorder,
+	}
+	err := sr.probe(mgr)
+	if err != nil {
+		panic(err)
+	}
+	return sr
+}
+
+func (r *ServiceReconciler) probe(mgr ctrl.Manager) error {
+	svc := &unstructured.Unstructured{}
+	svc.SetGroupVersionKind(serviceGvk)
+	slice := &unstructured.Unstructured{}
+	slice.SetGroupVersionKind(endpointSliceGvk)
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("service").
+		For(svc, builder.WithPredicates(predicate.NewPredicateFuncs(func(obj cli.Object) bool {
+			return !isLinkService(obj)
+		}))).
+		Owns(&vmv1.VirtualMachine{}).
+		// named target ports are resolved by endpoint slices
+		Watches(&source.Kind{Type: slice}, handler.EnqueueRequestsFromMapFunc(sliceToService)).
+		Complete(r)
+}
+
+func sliceToService(obj cli.Object) []reconcile.Request {
+	name := obj.GetLabels()[manage.EndpointSliceServiceName]
+	if name == "" {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{Namespace: obj.GetNamespace(), Name: name}}}
+}
+
+// services of link are synced by k8s manager
+func isLinkService(obj cli.Object) bool {
+	_, ok := obj.GetLabels()[manage.ServiceOwnerName]
+	return ok
+}
+
+// +kubebuilder:rbac:groups="",resources=services,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=services/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=discovery.k8s.io,resources=endpointslices,verbs=get;list;watch
+func (r *ServiceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	svc := &unstructured.Unstructured{}
+	svc.SetGroupVersionKind(serviceGvk)
+	err := r.Get(ctx, req.NamespacedName, svc)
+	if err != nil {
+		if apierrs.IsNotFound(err) {
+			// virtual machine is removed by garbage collector
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+	// requeued by endpoint slices
+	if isLinkService(svc) {
+		return ctrl.Result{}, nil
+	}
+	vmname := types.NamespacedName{Namespace: svc.GetNamespace(), Name: svcVmPrefix + svc.GetName()}
+	if !isLbService(svc) || svc.GetDeletionTimestamp() != nil {
+		return ctrl.Result{}, r.removeVm(svc, vmname)
+	}
+
+	slices := &unstructured.UnstructuredList{}
+	slices.SetGroupVersionKind(endpointSliceGvk.GroupVersion().WithKind("EndpointSliceList"))
+	err = r.List(ctx, slices, cli.InNamespace(svc.GetNamespace()), cli.MatchingLabels{manage.EndpointSliceServiceName: svc.GetName()})
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("list endpoint slices of service %s failed:%v", req.String(), err)
+	}
+	spec, err := serviceVmSpec(svc, slices.Items)
+	if err != nil {
+		klog.Errorf("service %s is invalid: %v", req.String(), err)
+		r.recorder.Eventf(svc, corev1.EventTypeWarning, ReasonInvalidService, "invalid load balancer service: %v", err)
+		return ctrl.Result{}, nil
+	}
+	vm := &vmv1.VirtualMachine{}
+	err = r.Get(ctx, vmname, vm)
+	if err != nil && !apierrs.IsNotFound(err) {
+		return ctrl.Result{}, err
+	}
+	// virtual machine with same name is not taken over
+	if err == nil && !metav1.IsControlledBy(vm, svc) {
+		klog.Errorf("virtual machine %s is not owned by service %s, skip", vmname.String(), req.String())
+		r.recorder.Eventf(svc, corev1.EventTypeWarning, ReasonVmConflict, "virtual machine %s exists and is not owned by service", vmname.Name)
+		return ctrl.Result{}, nil
+	}
+	vm.Namespace = vmname.Namespace
+	vm.Name = vmname.Name
+	op, err := controllerutil.CreateOrUpdate(ctx, r.Client, vm, func() error {
+		// defaulted same with webhook, avoid update on every reconcile
+		vm.Spec = *spec
+		defaultVm(vm)
+		return controllerutil.SetControllerReference(svc, vm, r.scheme)
+	})
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("sync virtual machine %s failed:%v", vmname.String(), err)
+	}
+	if op != controllerutil.OperationResultNone {
+		klog.Infof("virtual machine %s of service %s is %s", vmname.String(), req.String(), op)
+	}
+	return ctrl.Result{}, r.updateIngress(svc, vm)
+}
+
+// virtual machine is removed when service is not LoadBalancer of our class
+func (r *ServiceReconciler) removeVm(svc *unstructured.Unstructured, vmname types.NamespacedName) error {
+	vm := &vmv1.VirtualMachine{}
+	err := r.Get(r.ctx, vmname, vm)
+	if err != nil {
+		return cli.IgnoreNotFound(err)
+	}
+	if !metav1.IsControlledBy(vm, svc) || vm.DeletionTimestamp != nil {
+		return nil
+	}
+	klog.Infof("service %s/%s is not load balancer, remove %s", svc.GetNamespace(), svc.GetName(), vmname.String())
+	return cli.IgnoreNotFound(r.Delete(r.ctx, vm))
+}
+
+// ingress are lb ip and floating ip in status of virtual machine
+func (r *ServiceReconciler) updateIngress(svc *unstructured.Unstructured, vm *vmv1.VirtualMachine) error {
+	var ingress []interface{}
+	for _, stat := range []*vmv1.ResourceStatus{vm.Status.NetStatus, vm.Status.PubStatus} {
+		if stat == nil || stat.ServerStat.Ip == "" {
+			continue
+		}
+		ingress = append(ingress, map[string]interface{}{"ip": stat.ServerStat.Ip})
+	}
+	exist, _, _ := unstructured.NestedSlice(svc.Object, "status", "loadBalancer", "ingress")
+	if reflect.DeepEqual(exist, ingress) {
+		return nil
+	}
+	klog.V(2).Infof("update ingress of service %s/%s: %v", svc.GetNamespace(), svc.GetName(), ingress)
+	patch := cli.MergeFrom(svc.DeepCopy())
+	err := unstructured.SetNestedSlice(svc.Object, ingress, "status", "loadBalancer", "ingress")
+	if err != nil {
+		return err
+	}
+	return r.Status().Patch(r.ctx, svc, patch)
+}
+
+func isLbService(svc *unstructured.Unstructured) bool {
+	typ, _, _ := unstructured.NestedString(svc.Object, "spec", "type")
+	class, _, _ := unstructured.NestedString(svc.Object, "spec", "loadBalancerClass")
+	return typ == string(corev1.ServiceTypeLoadBalancer) && class == manage.LoadBalancerClass
+}
+
+// members are ready addresses in endpoint slices of service, and
+// target ports are resolved by endpoint slices, so selectorless service
+// and named target port are same with endpoints
+func serviceVmSpec(svc *unstructured.Unstructured, slices []unstructured.Unstructured) (*vmv1.VirtualMachineSpec, error) {
+	var (
+		annotations = svc.GetAnnotations()
+		ports       []*vmv1.PortMap
+	)
+	if annotations[SvcAuthSecretAnnotation] == "" || annotations[SvcSubnetAnnotation] == "" {
+		return nil, fmt.Errorf("annotation %s and %s are required", SvcAuthSecretAnnotation, SvcSubnetAnnotation)
+	}
+	svcports, _, _ := unstructured.NestedSlice(svc.Object, "spec", "ports")
+	for _, v := range svcports {
+		port, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		pm := &vmv1.PortMap{Protocol: string(corev1.ProtocolTCP)}
+		if proto, ok := port["protocol"].(string); ok && proto != "" {
+			pm.Protocol = proto
+		}
+		if num, ok := port["port"].(int64); ok {
+			pm.Port = int32(num)
+		}
+		switch target := port["targetPort"].(type) {
+		case int64:
+			pm.PodPort = int32(target)
+		case string:
+			name, _ := port["name"].(string)
+			podport, err := slicePort(slices, name, pm.Protocol)
+			if err != nil {
+				return nil, fmt.Errorf("target port %s of port %d: %v", target, pm.Port, err)
+			}
+			pm.PodPort = podport
+		}
+		ports = append(ports, pm)
+	}
+	if len(ports) == 0 {
+		return nil, fmt.Errorf("not found ports")
+	}
+	lbip, _, _ := unstructured.NestedString(svc.Object, "spec", "loadBalancerIP")
+
+	spec := &vmv1.VirtualMachineSpec{
+		Auth: &vmv1.AuthSpec{
+			SecretRef: &vmv1.SecretRef{Name: annotations[SvcAuthSecretAnnotation]},
+		},
+		AssemblyPhase: vmv1.Creating,
+		LoadBalance: &vmv1.LoadBalanceSpec{
+			Subnet: &vmv1.SubnetSpec{SubnetId: annotations[SvcSubnetAnnotation]},
+			Ports:  ports,
+			LbIp:   lbip,
+			LinkRef: &vmv1.LinkRef{
+				APIVersion: "v1",
+				Kind:       serviceGvk.Kind,
+				Name:       svc.GetName(),
+			},
+			MemberMode: vmv1.MemberModeReady,
+			IpSource:   &vmv1.IpSource{Type: vmv1.IpSourceEndpointSlices},
+		},
+	}
+	if ip := annotations[SvcFloatIpAnnotation]; ip != "" {
+		spec.Public = &vmv1.PublicSepc{Address: &vmv1.Address{Ip: ip}}
+	} else if network := annotations[SvcFloatNetworkAnnotation]; network != "" {
+		spec.Public = &vmv1.PublicSepc{
+			Subnet:  &vmv1.SubnetSpec{NetworkId: network},
+			Address: &vmv1.Address{Allocate: true},
+		}
+	}
+	return spec, nil
+}
+
+// port of endpoint slices which named after service port, the named port
+// should be same number on all pods, because it is the port of pool
+func slicePort(slices []unstructured.Unstructured, name, protocol string) (int32, error) {
+	var found int64
+	for _, slice := range slices {
+		ports, _, _ := unstructured.NestedSlice(slice.Object, "ports")
+		for _, v := range ports {
+			port, ok := v.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			pname, _ := port["name"].(string)
+			pproto, _ := port["protocol"].(string)
+			num, ok := port["port"].(int64)
+			if !ok || pname != name || (pproto != "" && pproto != protocol) {
+				continue
+			}
+			if found != 0 && found != num {
+				return 0, fmt.Errorf("resolved to different ports %d and %d", found, num)
+			}
+			found = num
+		}
+	}
+	if found == 0 {
+		return 0, fmt.Errorf("not resolved by endpoint slices")
+	}
+	return int32(found), nil
+}
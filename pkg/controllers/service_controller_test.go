@@ -0,0 +1,221 @@
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	vmv1 "easystack.io/vm-operator/pkg/api/v1"
+	"easystack.io/vm-operator/pkg/manage"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestServiceReconcile(t *testing.T) {
+	scheme := runtime.NewScheme()
+	clientgoscheme.AddToScheme(scheme)
+	vmv1.AddToScheme(scheme)
+	// discovery v1 is not in client-go scheme of this version
+	scheme.AddKnownTypeWithName(endpointSliceGvk, &unstructured.Unstructured{})
+	scheme.AddKnownTypeWithName(endpointSliceGvk.GroupVersion().WithKind("EndpointSliceList"), &unstructured.UnstructuredList{})
+
+	svc := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Service",
+		"metadata": map[string]interface{}{
+			"namespace": "default",
+			"name":      "web",
+			"uid":       "svc-uid",
+			"annotations": map[string]interface{}{
+				SvcAuthSecretAnnotation:   "auth",
+				SvcSubnetAnnotation:       "subnet-1",
+				SvcFloatNetworkAnnotation: "public",
+			},
+		},
+		"spec": map[string]interface{}{
+			"type":              "LoadBalancer",
+			"loadBalancerClass": manage.LoadBalancerClass,
+			"selector":          map[string]interface{}{"app": "web"},
+			"ports": []interface{}{
+				map[string]interface{}{"name": "http", "port": int64(80), "targetPort": int64(8080), "protocol": "TCP"},
+				map[string]interface{}{"name": "https", "port": int64(443), "targetPort": "https", "protocol": "TCP"},
+			},
+		},
+	}}
+	slice := newEndpointSlice("web-1", "web", map[string]int64{"http": 8080, "https": 8443})
+	client := fake.NewFakeClientWithScheme(scheme, svc, slice)
+	recorder := record.NewFakeRecorder(16)
+	r := &ServiceReconciler{
+		Client:   client,
+		ctx:      context.Background(),
+		scheme:   scheme,
+		recorder: recorder,
+	}
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "web"}}
+	if _, err := r.Reconcile(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+
+	vm := &vmv1.VirtualMachine{}
+	vmname := types.NamespacedName{Namespace: "default", Name: "svc-web"}
+	if err := client.Get(context.Background(), vmname, vm); err != nil {
+		t.Fatalf("virtual machine should be created: %v", err)
+	}
+	lb := vm.Spec.LoadBalance
+	if lb.Link != "/api/v1/namespaces/default/services/web" || lb.MemberMode != vmv1.MemberModeReady ||
+		lb.IpSource.Type != vmv1.IpSourceEndpointSlices {
+		t.Errorf("unexpected load balance spec: %+v", lb)
+	}
+	if len(lb.Ports) != 2 || lb.Ports[0].PodPort != 8080 || lb.Ports[1].Port != 443 || lb.Ports[1].PodPort != 8443 {
+		t.Errorf("unexpected ports: %v %v", lb.Ports[0], lb.Ports[1])
+	}
+	if vm.Spec.Public == nil || !vm.Spec.Public.Address.Allocate {
+		t.Errorf("floating ip should be allocated")
+	}
+	if refs := vm.OwnerReferences; len(refs) != 1 || refs[0].UID != "svc-uid" {
+		t.Errorf("virtual machine should be owned by service, but %v", refs)
+	}
+
+	// ingress is updated from status of virtual machine
+	vm.Status.NetStatus = &vmv1.ResourceStatus{ServerStat: vmv1.ServerStat{Ip: "10.0.0.10"}}
+	vm.Status.PubStatus = &vmv1.ResourceStatus{ServerStat: vmv1.ServerStat{Ip: "172.24.0.10"}}
+	if err := client.Update(context.Background(), vm); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Reconcile(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Get(context.Background(), req.NamespacedName, svc); err != nil {
+		t.Fatal(err)
+	}
+	ingress, _, _ := unstructured.NestedSlice(svc.Object, "status", "loadBalancer", "ingress")
+	want := []interface{}{
+		map[string]interface{}{"ip": "10.0.0.10"},
+		map[string]interface{}{"ip": "172.24.0.10"},
+	}
+	if !reflect.DeepEqual(ingress, want) {
+		t.Errorf("ingress should be %v, but %v", want, ingress)
+	}
+
+	// not load balancer any more
+	unstructured.SetNestedField(svc.Object, "ClusterIP", "spec", "type")
+	if err := client.Update(context.Background(), svc); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Reconcile(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Get(context.Background(), vmname, vm); err == nil {
+		t.Errorf("virtual machine should be removed")
+	}
+
+	// virtual machine with same name is not taken over
+	other := &vmv1.VirtualMachine{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "svc-web"},
+		Spec:       vmv1.VirtualMachineSpec{AssemblyPhase: vmv1.Stop},
+	}
+	if err := client.Create(context.Background(), other); err != nil {
+		t.Fatal(err)
+	}
+	unstructured.SetNestedField(svc.Object, "LoadBalancer", "spec", "type")
+	if err := client.Update(context.Background(), svc); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Reconcile(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+	vm = &vmv1.VirtualMachine{}
+	if err := client.Get(context.Background(), vmname, vm); err != nil {
+		t.Fatal(err)
+	}
+	if vm.Spec.AssemblyPhase != vmv1.Stop || vm.Spec.LoadBalance != nil || len(vm.OwnerReferences) != 0 {
+		t.Errorf("virtual machine not owned by service should not be updated: %+v", vm.Spec)
+	}
+	select {
+	case event := <-recorder.Events:
+		if !strings.Contains(event, ReasonVmConflict) {
+			t.Errorf("unexpected event: %s", event)
+		}
+	default:
+		t.Errorf("conflict should be recorded on service")
+	}
+}
+
+// endpoint slice of service in default namespace with named ports
+func newEndpointSlice(name, service string, ports map[string]int64) *unstructured.Unstructured {
+	var list []interface{}
+	for k, v := range ports {
+		list = append(list, map[string]interface{}{"name": k, "port": v, "protocol": "TCP"})
+	}
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "discovery.k8s.io/v1",
+		"kind":       "EndpointSlice",
+		"metadata": map[string]interface{}{
+			"namespace": "default",
+			"name":      name,
+			"labels":    map[string]interface{}{manage.EndpointSliceServiceName: service},
+		},
+		"addressType": "IPv4",
+		"ports":       list,
+	}}
+}
+
+func TestServiceVmSpecTargetPort(t *testing.T) {
+	// selectorless service
+	svc := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"name": "web",
+			"annotations": map[string]interface{}{
+				SvcAuthSecretAnnotation: "auth",
+				SvcSubnetAnnotation:     "subnet-1",
+			},
+		},
+		"spec": map[string]interface{}{
+			"ports": []interface{}{map[string]interface{}{"name": "web", "port": int64(80), "targetPort": "http"}},
+		},
+	}}
+	tests := []struct {
+		name    string
+		slices  []unstructured.Unstructured
+		want    int32
+		wantErr bool
+	}{
+		{
+			name:   "resolved by slices",
+			slices: []unstructured.Unstructured{*newEndpointSlice("web-1", "web", map[string]int64{"web": 8080, "metrics": 9090})},
+			want:   8080,
+		},
+		{
+			name:   "same port in slices",
+			slices: []unstructured.Unstructured{*newEndpointSlice("web-1", "web", map[string]int64{"web": 8080}), *newEndpointSlice("web-2", "web", map[string]int64{"web": 8080})},
+			want:   8080,
+		},
+		{
+			name:    "no endpoints",
+			wantErr: true,
+		},
+		{
+			name:    "different ports in slices",
+			slices:  []unstructured.Unstructured{*newEndpointSlice("web-1", "web", map[string]int64{"web": 8080}), *newEndpointSlice("web-2", "web", map[string]int64{"web": 8081})},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		spec, err := serviceVmSpec(svc, tt.slices)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error should be %v, but %v", tt.name, tt.wantErr, err)
+			continue
+		}
+		if !tt.wantErr && spec.LoadBalance.Ports[0].PodPort != tt.want {
+			t.Errorf("%s: target port should be %d, but %d", tt.name, tt.want, spec.LoadBalance.Ports[0].PodPort)
+		}
+	}
+}
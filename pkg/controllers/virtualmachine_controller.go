@@ -22,16 +22,26 @@ import (
 	"reflect"
 
 	vmv1 "easystack.io/vm-operator/pkg/api/v1"
+	"easystack.io/vm-operator/pkg/metrics"
 
+	corev1 "k8s.io/api/core/v1"
 	apierrs "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/util/retry"
 	ctrl "sigs.k8s.io/controller-runtime"
 	cli "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	klog "k8s.io/klog/v2"
 )
 
+const (
+	authSecretIndex = ".spec.auth.secretRef.name"
+	tlsSecretIndex  = ".spec.loadbalance.port_map.tls_secret"
+)
+
 // VirtualMachineReconciler reconciles a VirtualMachine object
 type VirtualMachineReconciler struct {
 	cli.Client
@@ -60,13 +70,85 @@ func (r *VirtualMachineReconciler) probe(mgr ctrl.Manager) error {
 	if err != nil {
 		return err
 	}
+	err = mgr.GetFieldIndexer().IndexField(r.ctx, &vmv1.VirtualMachine{}, authSecretIndex, authSecretOf)
+	if err != nil {
+		return err
+	}
+	err = mgr.GetFieldIndexer().IndexField(r.ctx, &vmv1.VirtualMachine{}, tlsSecretIndex, tlsSecretsOf)
+	if err != nil {
+		return err
+	}
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&vmv1.VirtualMachine{}).
+		Watches(&source.Kind{Type: &corev1.Secret{}}, handler.EnqueueRequestsFromMapFunc(r.secretToVms)).
+		Watches(r.server.Source(), &handler.EnqueueRequestForObject{}).
 		Complete(r)
 }
 
+func authSecretOf(obj cli.Object) []string {
+	vm, ok := obj.(*vmv1.VirtualMachine)
+	if !ok || vm.Spec.Auth == nil || vm.Spec.Auth.SecretRef == nil {
+		return nil
+	}
+	return []string{vm.Spec.Auth.SecretRef.Name}
+}
+
+func tlsSecretsOf(obj cli.Object) []string {
+	vm, ok := obj.(*vmv1.VirtualMachine)
+	if !ok || vm.Spec.LoadBalance == nil {
+		return nil
+	}
+	var names []string
+	for _, pm := range vm.Spec.LoadBalance.Ports {
+		if pm.TlsSecret != "" {
+			names = append(names, pm.TlsSecret)
+		}
+		names = append(names, pm.SniSecrets...)
+	}
+	return names
+}
+
+// find virtual machines which auth or listeners reference the secret
+func (r *VirtualMachineReconciler) secretToVms(obj cli.Object) []reconcile.Request {
+	var (
+		reqs []reconcile.Request
+		seen = make(map[string]struct{})
+	)
+	for _, index := range []string{authSecretIndex, tlsSecretIndex} {
+		var vms vmv1.VirtualMachineList
+		err := r.List(r.ctx, &vms, cli.InNamespace(obj.GetNamespace()), cli.MatchingFields{index: obj.GetName()})
+		if err != nil {
+			klog.Errorf("list virtual machine by secret %s/%s failed:%v", obj.GetNamespace(), obj.GetName(), err)
+			return nil
+		}
+		for _, vm := range vms.Items {
+			if _, ok := seen[vm.Name]; ok {
+				continue
+			}
+			seen[vm.Name] = struct{}{}
+			klog.V(2).Infof("secret %s/%s changed, reconcile %s/%s", obj.GetNamespace(), obj.GetName(), vm.Namespace, vm.Name)
+			reqs = append(reqs, reconcile.Request{
+				NamespacedName: types.NamespacedName{
+					Namespace: vm.Namespace,
+					Name:      vm.Name,
+				},
+			})
+		}
+	}
+	return reqs
+}
+
 // +kubebuilder:rbac:groups=mixapp.easystack.io,resources=virtualmachines,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=mixapp.easystack.io,resources=virtualmachines/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch
+// +kubebuilder:rbac:groups=apps,resources=deployments;statefulsets;daemonsets;replicasets,verbs=get;list;watch
+// +kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=replicationcontrollers,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=services,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=services/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=discovery.k8s.io,resources=endpointslices,verbs=get;list;watch;create;update;patch;delete
 func (r *VirtualMachineReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	var (
 		vm  vmv1.VirtualMachine
@@ -78,6 +160,7 @@ func (r *VirtualMachineReconciler) Reconcile(ctx context.Context, req ctrl.Reque
 		if apierrs.IsNotFound(err) {
 			// Delete event
 			klog.Infof("object %s had deleted", req.String())
+			metrics.DeleteStackStatus(req.String())
 			return ctrl.Result{}, nil
 		}
 		klog.Errorf("get object %s failed:%s", req.String(), err)
@@ -112,8 +195,7 @@ func (r *VirtualMachineReconciler) Reconcile(ctx context.Context, req ctrl.Reque
 				return ctrl.Result{}, nil
 			}
 		default:
-			updateCondition(&newvmobj.Status, OpCheck, fmt.Errorf("not found assemblyPhase in spec"))
-			return ctrl.Result{}, nil
+			updateCondition(newvmobj, OpCheck, fmt.Errorf("not found assemblyPhase in spec"))
 		}
 	}
 	if &newvmobj.Status == nil {
@@ -0,0 +1,92 @@
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+
+	vmv1 "easystack.io/vm-operator/pkg/api/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	cli "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// list virtual machines by field indexes like the cache
+type indexedClient struct {
+	cli.Client
+	vms     []vmv1.VirtualMachine
+	indexes map[string]cli.IndexerFunc
+}
+
+func (c *indexedClient) List(ctx context.Context, list cli.ObjectList, opts ...cli.ListOption) error {
+	lo := (&cli.ListOptions{}).ApplyOptions(opts)
+	out := list.(*vmv1.VirtualMachineList)
+	for i := range c.vms {
+		vm := &c.vms[i]
+		if lo.Namespace != "" && vm.Namespace != lo.Namespace {
+			continue
+		}
+		matched := true
+		for index, fn := range c.indexes {
+			if lo.FieldSelector == nil {
+				break
+			}
+			want, ok := lo.FieldSelector.RequiresExactMatch(index)
+			if !ok {
+				continue
+			}
+			matched = false
+			for _, v := range fn(vm) {
+				if v == want {
+					matched = true
+				}
+			}
+		}
+		if matched {
+			out.Items = append(out.Items, *vm)
+		}
+	}
+	return nil
+}
+
+func TestSecretToVms(t *testing.T) {
+	newVm := func(namespace, name string, auth string, tls ...string) vmv1.VirtualMachine {
+		vm := vmv1.VirtualMachine{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name}}
+		if auth != "" {
+			vm.Spec.Auth = &vmv1.AuthSpec{SecretRef: &vmv1.SecretRef{Name: auth}}
+		}
+		if len(tls) != 0 {
+			vm.Spec.LoadBalance = &vmv1.LoadBalanceSpec{Ports: []*vmv1.PortMap{{Port: 443, TlsSecret: tls[0], SniSecrets: tls[1:]}}}
+		}
+		return vm
+	}
+	r := &VirtualMachineReconciler{
+		ctx: context.Background(),
+		Client: &indexedClient{
+			vms: []vmv1.VirtualMachine{
+				newVm("test", "auth", "openrc"),
+				newVm("test", "both", "openrc", "cert"),
+				newVm("test", "sni", "other", "cert2", "openrc"),
+				newVm("test", "none", "other"),
+				newVm("other", "auth", "openrc"),
+			},
+			indexes: map[string]cli.IndexerFunc{
+				authSecretIndex: authSecretOf,
+				tlsSecretIndex:  tlsSecretsOf,
+			},
+		},
+	}
+	secret := &metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{Namespace: "test", Name: "openrc"}}
+
+	var names []string
+	for _, req := range r.secretToVms(secret) {
+		if req.Namespace != "test" {
+			t.Errorf("virtual machine in other namespace should not be requeued: %v", req)
+		}
+		names = append(names, req.Name)
+	}
+	sort.Strings(names)
+	if want := []string{"auth", "both", "sni"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("requeued virtual machines should be %v, but %v", want, names)
+	}
+}
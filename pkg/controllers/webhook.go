@@ -0,0 +1,143 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	vmv1 "easystack.io/vm-operator/pkg/api/v1"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	klog "k8s.io/klog/v2"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+const (
+	mutatePath   = "/mutate-mixapp-easystack-io-v1-virtualmachine"
+	validatePath = "/validate-mixapp-easystack-io-v1-virtualmachine"
+)
+
+// +kubebuilder:webhook:path=/mutate-mixapp-easystack-io-v1-virtualmachine,mutating=true,failurePolicy=fail,groups=mixapp.easystack.io,resources=virtualmachines,verbs=create;update,versions=v1,name=mvirtualmachine.mixapp.easystack.io
+// +kubebuilder:webhook:path=/validate-mixapp-easystack-io-v1-virtualmachine,mutating=false,failurePolicy=fail,groups=mixapp.easystack.io,resources=virtualmachines,verbs=create;update,versions=v1,name=vvirtualmachine.mixapp.easystack.io
+
+// NewWebhook register defaulting and validating webhook on manager
+func NewWebhook(mgr ctrl.Manager) {
+	server := mgr.GetWebhookServer()
+	server.Register(mutatePath, &webhook.Admission{Handler: &vmDefaulter{}})
+	server.Register(validatePath, &webhook.Admission{Handler: &vmValidator{}})
+}
+
+type vmDefaulter struct {
+	decoder *admission.Decoder
+}
+
+func (d *vmDefaulter) InjectDecoder(decoder *admission.Decoder) error {
+	d.decoder = decoder
+	return nil
+}
+
+func (d *vmDefaulter) Handle(ctx context.Context, req admission.Request) admission.Response {
+	vm := &vmv1.VirtualMachine{}
+	err := d.decoder.Decode(req, vm)
+	if err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	if vm.DeletionTimestamp != nil {
+		return admission.Allowed("")
+	}
+	defaultVm(vm)
+	bs, err := json.Marshal(vm)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	return admission.PatchResponseFromRaw(req.Object.Raw, bs)
+}
+
+type vmValidator struct {
+	decoder *admission.Decoder
+}
+
+func (v *vmValidator) InjectDecoder(decoder *admission.Decoder) error {
+	v.decoder = decoder
+	return nil
+}
+
+func (v *vmValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	if req.Operation != admissionv1.Create && req.Operation != admissionv1.Update {
+		return admission.Allowed("")
+	}
+	vm := &vmv1.VirtualMachine{}
+	err := v.decoder.Decode(req, vm)
+	if err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	if vm.DeletionTimestamp != nil {
+		return admission.Allowed("")
+	}
+	err = validVm(vm)
+	if err != nil {
+		klog.V(2).Infof("deny virtual machine %s/%s: %v", req.Namespace, req.Name, err)
+		return admission.Denied(err.Error())
+	}
+	return admission.Allowed("")
+}
+
+func defaultVm(vm *vmv1.VirtualMachine) {
+	spec := &vm.Spec
+	if spec.Server != nil {
+		defaultVmSpec(spec.Server)
+	}
+	if spec.LoadBalance != nil {
+		// invalid reference is denied by validVm
+		resolveLink(spec.LoadBalance, vm.Namespace)
+		defaultLbSpec(spec.LoadBalance)
+		if spec.Public == nil {
+			spec.Public = &vmv1.PublicSepc{}
+		}
+	}
+	if spec.Public != nil {
+		defaultPip(spec.Public)
+	}
+}
+
+func validVm(vm *vmv1.VirtualMachine) error {
+	spec := &vm.Spec
+	if spec.Auth == nil {
+		return fmt.Errorf("not found auth info")
+	}
+	if spec.Auth.Token == "" && spec.Auth.SecretRef == nil {
+		return fmt.Errorf("not found token or secretRef in auth")
+	}
+	if name, ok := vm.Annotations[BackendAnnotation]; ok {
+		err := validBackend(name)
+		if err != nil {
+			return err
+		}
+	}
+	if spec.Server != nil {
+		err := validVmSpec(spec.Server)
+		if err != nil {
+			return fmt.Errorf("server: %v", err)
+		}
+	}
+	if spec.LoadBalance != nil {
+		err := resolveLink(spec.LoadBalance, vm.Namespace)
+		if err != nil {
+			return fmt.Errorf("loadbalance: %v", err)
+		}
+		err = validLbSpec(spec.LoadBalance)
+		if err != nil {
+			return fmt.Errorf("loadbalance: %v", err)
+		}
+	}
+	if spec.Public != nil {
+		err := validPip(spec.Public)
+		if err != nil {
+			return fmt.Errorf("publicip: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package controllers
+
+import (
+	"testing"
+
+	vmv1 "easystack.io/vm-operator/pkg/api/v1"
+)
+
+func TestValidVm(t *testing.T) {
+	var cases = []struct {
+		name  string
+		spec  vmv1.VirtualMachineSpec
+		valid bool
+	}{
+		{
+			name: "no auth",
+			spec: vmv1.VirtualMachineSpec{},
+		},
+		{
+			name: "secret auth",
+			spec: vmv1.VirtualMachineSpec{
+				Auth: &vmv1.AuthSpec{SecretRef: &vmv1.SecretRef{Name: "auth"}},
+			},
+			valid: true,
+		},
+		{
+			name: "no boot image",
+			spec: vmv1.VirtualMachineSpec{
+				Auth:   &vmv1.AuthSpec{Token: "token"},
+				Server: &vmv1.ServerSpec{Replicas: 1},
+			},
+		},
+		{
+			name: "bad port",
+			spec: vmv1.VirtualMachineSpec{
+				Auth: &vmv1.AuthSpec{Token: "token"},
+				LoadBalance: &vmv1.LoadBalanceSpec{
+					Ports: []*vmv1.PortMap{{Port: 70000, Protocol: "TCP"}},
+				},
+			},
+		},
+		{
+			name: "bad link",
+			spec: vmv1.VirtualMachineSpec{
+				Auth: &vmv1.AuthSpec{Token: "token"},
+				LoadBalance: &vmv1.LoadBalanceSpec{
+					Ports: []*vmv1.PortMap{{Port: 80, Protocol: "TCP"}},
+					Link:  "/apis/apps/v1/deployments/pause",
+				},
+			},
+		},
+		{
+			name: "address conflict",
+			spec: vmv1.VirtualMachineSpec{
+				Auth: &vmv1.AuthSpec{Token: "token"},
+				Public: &vmv1.PublicSepc{
+					Address: &vmv1.Address{Allocate: true, Ip: "1.1.1.1"},
+				},
+			},
+		},
+		{
+			name: "link loadbalance",
+			spec: vmv1.VirtualMachineSpec{
+				Auth: &vmv1.AuthSpec{Token: "token"},
+				LoadBalance: &vmv1.LoadBalanceSpec{
+					Ports: []*vmv1.PortMap{{Port: 80, Protocol: "TCP"}},
+					LbIp:  "1.1.1.1",
+					Link:  "/apis/apps/v1/namespaces/test/deployments/pause",
+				},
+			},
+			valid: true,
+		},
+	}
+	for _, c := range cases {
+		vm := &vmv1.VirtualMachine{Spec: c.spec}
+		defaultVm(vm)
+		err := validVm(vm)
+		if (err == nil) != c.valid {
+			t.Errorf("case %s: expect valid %v, but err is %v", c.name, c.valid, err)
+		}
+	}
+}
+
+func TestDefaultVm(t *testing.T) {
+	vm := &vmv1.VirtualMachine{
+		Spec: vmv1.VirtualMachineSpec{
+			Server: &vmv1.ServerSpec{},
+			LoadBalance: &vmv1.LoadBalanceSpec{
+				Ports: []*vmv1.PortMap{{Port: 80, Protocol: "TCP"}},
+			},
+		},
+	}
+	defaultVm(vm)
+	if vm.Spec.Server.Name != "vm" {
+		t.Errorf("server name should be vm, but %s", vm.Spec.Server.Name)
+	}
+	if vm.Spec.LoadBalance.Ports[0].PodPort != 80 {
+		t.Errorf("pod port should be 80, but %d", vm.Spec.LoadBalance.Ports[0].PodPort)
+	}
+	if vm.Spec.Public == nil || vm.Spec.Public.Address == nil {
+		t.Errorf("public should be defaulted")
+	}
+}
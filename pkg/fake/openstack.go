@@ -0,0 +1,1196 @@
+package fake
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	ProjectID = "fake-project"
+	Token     = "fake-token"
+
+	StackCreateInProgress = "CREATE_IN_PROGRESS"
+	StackCreateComplete   = "CREATE_COMPLETE"
+	StackCreateFailed     = "CREATE_FAILED"
+	StackUpdateInProgress = "UPDATE_IN_PROGRESS"
+	StackUpdateComplete   = "UPDATE_COMPLETE"
+	StackUpdateFailed     = "UPDATE_FAILED"
+
+	resServer      = "OS::Nova::Server"
+	resPort        = "OS::Neutron::Port"
+	resLb          = "OS::Neutron::LBaaS::LoadBalancer"
+	resOctaviaLb   = "OS::Octavia::LoadBalancer"
+	resMember      = "OS::Neutron::LBaaS::PoolMember"
+	resOctaviaMem  = "OS::Octavia::PoolMember"
+	resFip         = "OS::Neutron::FloatingIP"
+	resFipAssocate = "OS::Neutron::FloatingIPAssociation"
+)
+
+// the order which resources created in stack
+var resOrder = map[string]int{
+	resPort:        0,
+	resLb:          1,
+	resOctaviaLb:   1,
+	resMember:      2,
+	resOctaviaMem:  2,
+	resServer:      2,
+	resFip:         3,
+	resFipAssocate: 4,
+}
+
+type Stack struct {
+	ID           string `json:"id"`
+	Name         string `json:"stack_name"`
+	Status       string `json:"stack_status"`
+	StatusReason string `json:"stack_status_reason"`
+
+	template map[string]interface{}
+	// key: resource name in template
+	resources map[string]*resource
+	// failed reason on next transition
+	fail string
+}
+
+type resource struct {
+	Type string
+	ID   string
+}
+
+type Address struct {
+	Version int    `json:"version"`
+	Addr    string `json:"addr"`
+}
+
+type Server struct {
+	ID        string               `json:"id"`
+	Name      string               `json:"name"`
+	Status    string               `json:"status"`
+	Metadata  map[string]string    `json:"metadata"`
+	Addresses map[string][]Address `json:"addresses"`
+	Image     map[string]string    `json:"image"`
+
+	AttachedVolumes []AttachedVolume `json:"os-extended-volumes:volumes_attached"`
+}
+
+type AttachedVolume struct {
+	ID string `json:"id"`
+}
+
+type FixedIP struct {
+	SubnetID  string `json:"subnet_id"`
+	IPAddress string `json:"ip_address"`
+}
+
+type Port struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	NetworkID   string    `json:"network_id"`
+	DeviceOwner string    `json:"device_owner"`
+	FixedIPs    []FixedIP `json:"fixed_ips"`
+}
+
+type LoadBalancer struct {
+	ID                 string `json:"id"`
+	Name               string `json:"name"`
+	VipAddress         string `json:"vip_address"`
+	VipPortID          string `json:"vip_port_id"`
+	VipSubnetID        string `json:"vip_subnet_id"`
+	ProvisioningStatus string `json:"provisioning_status"`
+	OperatingStatus    string `json:"operating_status"`
+}
+
+type Member struct {
+	ID              string `json:"id"`
+	Address         string `json:"address"`
+	ProtocolPort    int    `json:"protocol_port"`
+	OperatingStatus string `json:"operating_status"`
+
+	// load balance id
+	lb string
+}
+
+type Volume struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Size   int    `json:"size"`
+	Status string `json:"status"`
+
+	Bootable            string            `json:"bootable"`
+	VolumeImageMetadata map[string]string `json:"volume_image_metadata,omitempty"`
+}
+
+type FloatingIP struct {
+	ID                string `json:"id"`
+	FloatingIP        string `json:"floating_ip_address"`
+	FixedIP           string `json:"fixed_ip_address"`
+	PortID            string `json:"port_id"`
+	FloatingNetworkID string `json:"floating_network_id"`
+	Status            string `json:"status"`
+}
+
+// OpenStack is an in-process keystone, nova, cinder, glance, neutron, octavia and heat.
+// The stack in progress will be completed on next stack list,
+// and resources in template are created at that time.
+// Resources created by api directly are ready at once.
+type OpenStack struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	seq     int
+	stacks  map[string]*Stack
+	servers map[string]*Server
+	ports   map[string]*Port
+	lbs     map[string]*LoadBalancer
+	fips    map[string]*FloatingIP
+	volumes map[string]*Volume
+	// listeners, pools and members of load balance
+	// key: id, value: parent id
+	lbaas map[string]string
+	// key: server id, value: volumes which delete on termination
+	bdms map[string][]string
+	// pool members, key: id
+	members map[string]*Member
+	// load-balancer service is in catalog
+	octavia bool
+	// failed reason of stacks which created later
+	failNew string
+}
+
+func NewOpenStack() *OpenStack {
+	o := &OpenStack{
+		stacks:  make(map[string]*Stack),
+		servers: make(map[string]*Server),
+		ports:   make(map[string]*Port),
+		lbs:     make(map[string]*LoadBalancer),
+		fips:    make(map[string]*FloatingIP),
+		volumes: make(map[string]*Volume),
+		lbaas:   make(map[string]string),
+		bdms:    make(map[string][]string),
+		members: make(map[string]*Member),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v3/auth/tokens", o.token)
+	mux.HandleFunc("/compute/v2.1/", o.compute)
+	mux.HandleFunc("/network/v2.0/", o.network)
+	mux.HandleFunc("/load-balancer/v2.0/", o.loadBalancer)
+	mux.HandleFunc("/volume/v3/", o.volume)
+	mux.HandleFunc("/image/v2/", o.image)
+	mux.HandleFunc("/orchestration/v1/", o.orchestration)
+	o.Server = httptest.NewServer(mux)
+	return o
+}
+
+// EnableOctavia add load-balancer service in catalog
+func (o *OpenStack) EnableOctavia() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.octavia = true
+}
+
+// Env is openrc of admin, which used by operator
+func (o *OpenStack) Env() map[string]string {
+	return map[string]string{
+		"OS_AUTH_URL":     o.URL + "/v3/",
+		"OS_USERNAME":     "admin",
+		"OS_PASSWORD":     "password",
+		"OS_PROJECT_ID":   ProjectID,
+		"OS_DOMAIN_NAME":  "Default",
+		"OS_REGION_NAME":  "RegionOne",
+		"OS_PROJECT_NAME": "admin",
+	}
+}
+
+func (o *OpenStack) Stacks() []Stack {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	var ret []Stack
+	for _, v := range o.stacks {
+		ret = append(ret, *v)
+	}
+	return ret
+}
+
+func (o *OpenStack) Servers() []Server {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	var ret []Server
+	for _, v := range o.servers {
+		ret = append(ret, *v)
+	}
+	return ret
+}
+
+func (o *OpenStack) LoadBalancers() []LoadBalancer {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	var ret []LoadBalancer
+	for _, v := range o.lbs {
+		ret = append(ret, *v)
+	}
+	return ret
+}
+
+func (o *OpenStack) Volumes() []Volume {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	var ret []Volume
+	for _, v := range o.volumes {
+		ret = append(ret, *v)
+	}
+	return ret
+}
+
+func (o *OpenStack) Ports() []Port {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	var ret []Port
+	for _, v := range o.ports {
+		ret = append(ret, *v)
+	}
+	return ret
+}
+
+func (o *OpenStack) FloatingIPs() []FloatingIP {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	var ret []FloatingIP
+	for _, v := range o.fips {
+		ret = append(ret, *v)
+	}
+	return ret
+}
+
+// FailStack make the stack failed with reason on next transition
+func (o *OpenStack) FailStack(name, reason string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, v := range o.stacks {
+		if v.Name == name {
+			v.fail = reason
+		}
+	}
+}
+
+// FailNewStacks make stacks which created later failed with reason,
+// the empty reason stops it
+func (o *OpenStack) FailNewStacks(reason string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.failNew = reason
+}
+
+func (o *OpenStack) SetServerStatus(id, status string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if v, ok := o.servers[id]; ok {
+		v.Status = status
+	}
+}
+
+// SetMemberStatus set operating status of pool members by address
+func (o *OpenStack) SetMemberStatus(address, status string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, v := range o.members {
+		if v.Address == address {
+			v.OperatingStatus = status
+		}
+	}
+}
+
+// DeleteLoadBalancer remove load balance out of heat, such as by user
+func (o *OpenStack) DeleteLoadBalancer(id string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if lb, ok := o.lbs[id]; ok {
+		delete(o.ports, lb.VipPortID)
+		delete(o.lbs, id)
+	}
+}
+
+func (o *OpenStack) newID(prefix string) string {
+	o.seq++
+	return fmt.Sprintf("%s-%08d", prefix, o.seq)
+}
+
+func (o *OpenStack) newIP(prefix string) string {
+	o.seq++
+	return fmt.Sprintf("%s.%d.%d", prefix, o.seq/250, o.seq%250+2)
+}
+
+func (o *OpenStack) token(w http.ResponseWriter, r *http.Request) {
+	var code = http.StatusCreated
+	switch r.Method {
+	case http.MethodPost:
+	case http.MethodGet:
+		// validate token
+		code = http.StatusOK
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	endpoint := func(ty, url string) map[string]interface{} {
+		return map[string]interface{}{
+			"type": ty,
+			"name": ty,
+			"endpoints": []map[string]string{{
+				"id":        ty,
+				"interface": "public",
+				"region":    "RegionOne",
+				"region_id": "RegionOne",
+				"url":       url,
+			}},
+		}
+	}
+	catalog := []map[string]interface{}{
+		endpoint("identity", o.URL+"/v3/"),
+		endpoint("compute", o.URL+"/compute/v2.1/"),
+		endpoint("network", o.URL+"/network/"),
+		endpoint("volumev3", o.URL+"/volume/v3/"+ProjectID+"/"),
+		endpoint("image", o.URL+"/image/"),
+		endpoint("orchestration", o.URL+"/orchestration/v1/"+ProjectID+"/"),
+	}
+	o.mu.Lock()
+	if o.octavia {
+		catalog = append(catalog, endpoint("load-balancer", o.URL+"/load-balancer/"))
+	}
+	o.mu.Unlock()
+	w.Header().Set("X-Subject-Token", Token)
+	writeJSON(w, code, map[string]interface{}{
+		"token": map[string]interface{}{
+			"methods":    []string{"password"},
+			"expires_at": time.Now().Add(time.Hour).UTC().Format(time.RFC3339),
+			"user": map[string]interface{}{
+				"id":     "admin",
+				"name":   "admin",
+				"domain": map[string]string{"id": "default", "name": "Default"},
+			},
+			"project": map[string]interface{}{
+				"id":     ProjectID,
+				"name":   "admin",
+				"domain": map[string]string{"id": "default", "name": "Default"},
+			},
+			"catalog": catalog,
+		},
+	})
+}
+
+func (o *OpenStack) compute(w http.ResponseWriter, r *http.Request) {
+	paths := splitPath(r.URL.Path, "/compute/v2.1/")
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	switch {
+	case len(paths) == 2 && paths[0] == "flavors" && paths[1] == "detail":
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"flavors": []map[string]interface{}{{"id": "flavor-1", "name": "flavor", "ram": 1024, "vcpus": 1, "disk": 0}},
+		})
+	case len(paths) == 1 && paths[0] == "servers" && r.Method == http.MethodPost:
+		o.createServer(w, r)
+	case len(paths) == 2 && paths[0] == "servers" && r.Method == http.MethodDelete:
+		if _, ok := o.servers[paths[1]]; !ok {
+			writeJSON(w, http.StatusNotFound, nil)
+			return
+		}
+		o.deleteServer(paths[1])
+		w.WriteHeader(http.StatusNoContent)
+	case len(paths) == 2 && paths[0] == "servers" && paths[1] == "detail":
+		var list = make([]*Server, 0, len(o.servers))
+		for _, v := range o.servers {
+			list = append(list, v)
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"servers": list})
+		// rebuilding is reported once, then done
+		for _, v := range list {
+			if v.Status == "REBUILD" {
+				v.Status = "ACTIVE"
+			}
+		}
+	case len(paths) == 2 && paths[0] == "servers":
+		v, ok := o.servers[paths[1]]
+		if !ok {
+			writeJSON(w, http.StatusNotFound, nil)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"server": v})
+	case len(paths) == 3 && paths[0] == "servers" && paths[2] == "action":
+		v, ok := o.servers[paths[1]]
+		if !ok {
+			writeJSON(w, http.StatusNotFound, nil)
+			return
+		}
+		var body map[string]interface{}
+		readJSON(r, &body)
+		switch {
+		case hasKey(body, "os-stop"):
+			v.Status = "SHUTOFF"
+		case hasKey(body, "os-start"):
+			v.Status = "ACTIVE"
+		case hasKey(body, "rebuild"):
+			rebuild, _ := body["rebuild"].(map[string]interface{})
+			if image, _ := rebuild["imageRef"].(string); image == "" {
+				writeJSON(w, http.StatusBadRequest, nil)
+				return
+			}
+			// volume backed server is rebuilt since 2.93
+			if len(v.AttachedVolumes) != 0 && computeMinor(r) < 93 {
+				writeJSON(w, http.StatusBadRequest, nil)
+				return
+			}
+			v.Status = "REBUILD"
+			writeJSON(w, http.StatusAccepted, map[string]interface{}{"server": v})
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		writeJSON(w, http.StatusNotFound, nil)
+	}
+}
+
+func (o *OpenStack) network(w http.ResponseWriter, r *http.Request) {
+	paths := splitPath(r.URL.Path, "/network/v2.0/")
+	path := strings.Join(paths, "/")
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if r.Method != http.MethodGet {
+		o.networkWrite(w, r, paths)
+		return
+	}
+	switch path {
+	case "networks", "security-groups":
+		// network and security group are found by name
+		name := r.URL.Query().Get("name")
+		key := strings.Replace(path, "-", "_", 1)
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			key: []map[string]string{{"id": "net-" + name, "name": name}},
+		})
+	case "ports":
+		var list = make([]*Port, 0, len(o.ports))
+		for _, v := range o.ports {
+			list = append(list, v)
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"ports": list})
+	case "floatingips":
+		var list = make([]*FloatingIP, 0, len(o.fips))
+		for _, v := range o.fips {
+			list = append(list, v)
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"floatingips": list})
+	default:
+		switch {
+		case len(paths) == 2 && paths[0] == "ports":
+			if v, ok := o.ports[paths[1]]; ok {
+				writeJSON(w, http.StatusOK, map[string]interface{}{"port": v})
+				return
+			}
+			writeJSON(w, http.StatusNotFound, nil)
+		case len(paths) == 2 && paths[0] == "floatingips":
+			if v, ok := o.fips[paths[1]]; ok {
+				writeJSON(w, http.StatusOK, map[string]interface{}{"floatingip": v})
+				return
+			}
+			writeJSON(w, http.StatusNotFound, nil)
+		default:
+			o.getLoadBalancer(w, paths)
+		}
+	}
+}
+
+// octavia api is same with lbaas of neutron on resources used
+func (o *OpenStack) loadBalancer(w http.ResponseWriter, r *http.Request) {
+	paths := splitPath(r.URL.Path, "/load-balancer/v2.0/")
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if !o.octavia {
+		writeJSON(w, http.StatusNotFound, nil)
+		return
+	}
+	if r.Method != http.MethodGet {
+		o.networkWrite(w, r, paths)
+		return
+	}
+	o.getLoadBalancer(w, paths)
+}
+
+func (o *OpenStack) getLoadBalancer(w http.ResponseWriter, paths []string) {
+	switch {
+	case strings.Join(paths, "/") == "lbaas/loadbalancers":
+		var list = make([]*LoadBalancer, 0, len(o.lbs))
+		for _, v := range o.lbs {
+			list = append(list, v)
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"loadbalancers": list})
+	case len(paths) == 3 && paths[1] == "loadbalancers":
+		if v, ok := o.lbs[paths[2]]; ok {
+			writeJSON(w, http.StatusOK, map[string]interface{}{"loadbalancer": v})
+			return
+		}
+		writeJSON(w, http.StatusNotFound, nil)
+	case len(paths) == 3 && (paths[1] == "listeners" || paths[1] == "pools" || paths[1] == "healthmonitors"):
+		if _, ok := o.lbaas[paths[2]]; ok {
+			kind := strings.TrimSuffix(paths[1], "s")
+			writeJSON(w, http.StatusOK, map[string]interface{}{kind: map[string]string{"id": paths[2]}})
+			return
+		}
+		writeJSON(w, http.StatusNotFound, nil)
+	case len(paths) == 5 && paths[1] == "pools" && paths[3] == "members":
+		if v, ok := o.members[paths[4]]; ok && o.lbaas[paths[4]] == paths[2] {
+			writeJSON(w, http.StatusOK, map[string]interface{}{"member": v})
+			return
+		}
+		writeJSON(w, http.StatusNotFound, nil)
+	case len(paths) == 4 && paths[1] == "loadbalancers" && paths[3] == "statuses":
+		lb, ok := o.lbs[paths[2]]
+		if !ok {
+			writeJSON(w, http.StatusNotFound, nil)
+			return
+		}
+		// all members are in one pool
+		var members = make([]*Member, 0)
+		for _, v := range o.members {
+			if v.lb == lb.ID {
+				members = append(members, v)
+			}
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"statuses": map[string]interface{}{
+			"loadbalancer": map[string]interface{}{
+				"id":                  lb.ID,
+				"provisioning_status": lb.ProvisioningStatus,
+				"operating_status":    lb.OperatingStatus,
+				"listeners": []interface{}{map[string]interface{}{
+					"pools": []interface{}{map[string]interface{}{
+						"members": members,
+					}},
+				}},
+			},
+		}})
+	default:
+		writeJSON(w, http.StatusNotFound, nil)
+	}
+}
+
+// create, update and delete network resources
+func (o *OpenStack) networkWrite(w http.ResponseWriter, r *http.Request, paths []string) {
+	var body map[string]map[string]interface{}
+	readJSON(r, &body)
+	if len(paths) > 0 && paths[0] == "lbaas" {
+		paths = paths[1:]
+	}
+	switch {
+	case len(paths) == 1 && r.Method == http.MethodPost:
+		switch paths[0] {
+		case "ports":
+			props := body["port"]
+			port := &Port{
+				ID:        o.newID("port"),
+				Name:      str(props, "name"),
+				NetworkID: str(props, "network_id"),
+				FixedIPs:  []FixedIP{{IPAddress: o.newIP("10.0")}},
+			}
+			o.ports[port.ID] = port
+			writeJSON(w, http.StatusCreated, map[string]interface{}{"port": port})
+		case "loadbalancers":
+			props := body["loadbalancer"]
+			lb := o.newLoadBalancer(str(props, "name"), str(props, "vip_subnet_id"), str(props, "vip_address"))
+			writeJSON(w, http.StatusCreated, map[string]interface{}{"loadbalancer": lb})
+		case "listeners", "pools", "healthmonitors":
+			kind := strings.TrimSuffix(paths[0], "s")
+			props := body[kind]
+			parent := str(props, "loadbalancer_id") + str(props, "listener_id") + str(props, "pool_id")
+			if _, ok := o.lbaas[parent]; !ok && o.lbs[parent] == nil {
+				writeJSON(w, http.StatusNotFound, nil)
+				return
+			}
+			id := o.newID(kind)
+			o.lbaas[id] = parent
+			writeJSON(w, http.StatusCreated, map[string]interface{}{kind: map[string]string{"id": id}})
+		case "floatingips":
+			props := body["floatingip"]
+			fip := &FloatingIP{
+				ID:                o.newID("fip"),
+				FloatingIP:        o.newIP("172.24"),
+				FloatingNetworkID: str(props, "floating_network_id"),
+				PortID:            str(props, "port_id"),
+				FixedIP:           str(props, "fixed_ip_address"),
+				Status:            "ACTIVE",
+			}
+			o.fips[fip.ID] = fip
+			writeJSON(w, http.StatusCreated, map[string]interface{}{"floatingip": fip})
+		default:
+			writeJSON(w, http.StatusNotFound, nil)
+		}
+	case len(paths) == 3 && paths[0] == "pools" && paths[2] == "members" && r.Method == http.MethodPost:
+		if _, ok := o.lbaas[paths[1]]; !ok {
+			writeJSON(w, http.StatusNotFound, nil)
+			return
+		}
+		id := o.newID("member")
+		o.lbaas[id] = paths[1]
+		props := body["member"]
+		o.members[id] = &Member{
+			ID:              id,
+			Address:         str(props, "address"),
+			ProtocolPort:    toInt(props["protocol_port"]),
+			OperatingStatus: "ONLINE",
+			lb:              o.parentLb(paths[1]),
+		}
+		writeJSON(w, http.StatusCreated, map[string]interface{}{"member": map[string]string{"id": id}})
+	case len(paths) == 2 && paths[0] == "floatingips" && r.Method == http.MethodPut:
+		fip, ok := o.fips[paths[1]]
+		if !ok {
+			writeJSON(w, http.StatusNotFound, nil)
+			return
+		}
+		props := body["floatingip"]
+		fip.PortID = str(props, "port_id")
+		fip.FixedIP = str(props, "fixed_ip_address")
+		writeJSON(w, http.StatusOK, map[string]interface{}{"floatingip": fip})
+	case r.Method == http.MethodDelete && len(paths) >= 2:
+		id := paths[len(paths)-1]
+		var ok bool
+		switch paths[0] {
+		case "ports":
+			_, ok = o.ports[id]
+			delete(o.ports, id)
+		case "floatingips":
+			_, ok = o.fips[id]
+			delete(o.fips, id)
+		case "loadbalancers":
+			if lb, exist := o.lbs[id]; exist {
+				ok = true
+				delete(o.ports, lb.VipPortID)
+				delete(o.lbs, id)
+			}
+		default:
+			_, ok = o.lbaas[id]
+			delete(o.lbaas, id)
+			delete(o.members, id)
+		}
+		if !ok {
+			writeJSON(w, http.StatusNotFound, nil)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeJSON(w, http.StatusNotFound, nil)
+	}
+}
+
+func (o *OpenStack) volume(w http.ResponseWriter, r *http.Request) {
+	paths := splitPath(r.URL.Path, "/volume/v3/")
+	if len(paths) < 2 || paths[1] != "volumes" {
+		writeJSON(w, http.StatusNotFound, nil)
+		return
+	}
+	paths = paths[2:]
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	switch {
+	case len(paths) == 0 && r.Method == http.MethodPost:
+		var body struct {
+			Volume struct {
+				Volume
+				ImageRef string `json:"imageRef"`
+			} `json:"volume"`
+		}
+		readJSON(r, &body)
+		v := &body.Volume.Volume
+		v.ID = o.newID("volume")
+		v.Status = "available"
+		v.Bootable = "false"
+		if image := body.Volume.ImageRef; image != "" {
+			v.Bootable = "true"
+			v.VolumeImageMetadata = map[string]string{"image_id": image}
+		}
+		o.volumes[v.ID] = v
+		writeJSON(w, http.StatusAccepted, map[string]interface{}{"volume": v})
+	case len(paths) == 1:
+		v, ok := o.volumes[paths[0]]
+		if !ok {
+			writeJSON(w, http.StatusNotFound, nil)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, map[string]interface{}{"volume": v})
+		case http.MethodDelete:
+			if v.Status == "in-use" {
+				writeJSON(w, http.StatusBadRequest, nil)
+				return
+			}
+			delete(o.volumes, v.ID)
+			w.WriteHeader(http.StatusAccepted)
+		}
+	default:
+		writeJSON(w, http.StatusNotFound, nil)
+	}
+}
+
+// image is found by name
+func (o *OpenStack) image(w http.ResponseWriter, r *http.Request) {
+	paths := splitPath(r.URL.Path, "/image/v2/")
+	if len(paths) != 1 || paths[0] != "images" {
+		writeJSON(w, http.StatusNotFound, nil)
+		return
+	}
+	name := r.URL.Query().Get("name")
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"images": []map[string]string{{"id": "image-" + name, "name": name, "status": "active"}},
+	})
+}
+
+func (o *OpenStack) orchestration(w http.ResponseWriter, r *http.Request) {
+	paths := splitPath(r.URL.Path, "/orchestration/v1/")
+	if len(paths) < 2 || paths[1] != "stacks" {
+		writeJSON(w, http.StatusNotFound, nil)
+		return
+	}
+	paths = paths[2:]
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	switch {
+	case len(paths) == 0 && r.Method == http.MethodGet:
+		var list = make([]*Stack, 0, len(o.stacks))
+		for _, v := range o.stacks {
+			o.transition(v)
+			list = append(list, v)
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"stacks": list})
+	case len(paths) == 0 && r.Method == http.MethodPost:
+		var body struct {
+			Name     string `json:"stack_name"`
+			Template string `json:"template"`
+		}
+		readJSON(r, &body)
+		tpl, err := parseTemplate(body.Template)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		st := &Stack{
+			ID:        o.newID("stack"),
+			Name:      body.Name,
+			Status:    StackCreateInProgress,
+			template:  tpl,
+			resources: make(map[string]*resource),
+			fail:      o.failNew,
+		}
+		o.stacks[st.ID] = st
+		writeJSON(w, http.StatusCreated, map[string]interface{}{
+			"stack": map[string]interface{}{"id": st.ID},
+		})
+	case len(paths) == 2:
+		st, ok := o.stacks[paths[1]]
+		if !ok || st.Name != paths[0] {
+			writeJSON(w, http.StatusNotFound, nil)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, map[string]interface{}{"stack": st})
+		case http.MethodPut, http.MethodPatch:
+			var body struct {
+				Template string `json:"template"`
+			}
+			readJSON(r, &body)
+			if body.Template != "" {
+				tpl, err := parseTemplate(body.Template)
+				if err != nil {
+					writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+					return
+				}
+				st.template = tpl
+			}
+			st.Status = StackUpdateInProgress
+			st.StatusReason = ""
+			w.WriteHeader(http.StatusAccepted)
+		case http.MethodDelete:
+			for name := range st.resources {
+				o.remove(st, name)
+			}
+			delete(o.stacks, st.ID)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	default:
+		writeJSON(w, http.StatusNotFound, nil)
+	}
+}
+
+// complete the stack which is in progress
+func (o *OpenStack) transition(st *Stack) {
+	var failed, complete string
+	switch st.Status {
+	case StackCreateInProgress:
+		failed, complete = StackCreateFailed, StackCreateComplete
+	case StackUpdateInProgress:
+		failed, complete = StackUpdateFailed, StackUpdateComplete
+	default:
+		return
+	}
+	if st.fail != "" {
+		st.Status = failed
+		st.StatusReason = st.fail
+		st.fail = ""
+		return
+	}
+	o.apply(st)
+	st.Status = complete
+	st.StatusReason = "Stack " + complete + " completed successfully"
+}
+
+// create, update or remove resources by template
+func (o *OpenStack) apply(st *Stack) {
+	res, _ := st.template["resources"].(map[string]interface{})
+	for name := range st.resources {
+		if _, ok := res[name]; !ok {
+			o.remove(st, name)
+		}
+	}
+	var names []string
+	for name := range res {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		ti, tj := resType(res[names[i]]), resType(res[names[j]])
+		if resOrder[ti] != resOrder[tj] {
+			return resOrder[ti] < resOrder[tj]
+		}
+		return names[i] < names[j]
+	})
+	for _, name := range names {
+		o.ensure(st, name, res[name])
+	}
+}
+
+func (o *OpenStack) ensure(st *Stack, name string, value interface{}) {
+	var (
+		ty       = resType(value)
+		props, _ = value.(map[string]interface{})["properties"].(map[string]interface{})
+		r, ok    = st.resources[name]
+	)
+	if !ok {
+		r = &resource{Type: ty}
+	}
+	switch ty {
+	case resPort:
+		if ok {
+			return
+		}
+		r.ID = o.newID("port")
+		o.ports[r.ID] = &Port{
+			ID:        r.ID,
+			Name:      name,
+			NetworkID: str(props, "network"),
+			FixedIPs:  []FixedIP{{IPAddress: o.newIP("10.0")}},
+		}
+	case resServer:
+		var (
+			sv     *Server
+			portid string
+		)
+		if ok {
+			sv = o.servers[r.ID]
+		}
+		if sv == nil {
+			r.ID = o.newID("server")
+			sv = &Server{
+				ID:     r.ID,
+				Status: "ACTIVE",
+				Image:  map[string]string{"id": "image"},
+			}
+			o.servers[r.ID] = sv
+		}
+		sv.Name = str(props, "name")
+		sv.Metadata = make(map[string]string)
+		if md, ok := props["metadata"].(map[string]interface{}); ok {
+			for k, v := range md {
+				sv.Metadata[k] = fmt.Sprint(v)
+			}
+		}
+		if nets, ok := props["networks"].([]interface{}); ok && len(nets) > 0 {
+			portres := getResource(nets[0], "port")
+			if v, ok := st.resources[portres]; ok {
+				portid = v.ID
+			}
+		}
+		sv.Addresses = o.addresses(portid)
+	case resLb, resOctaviaLb:
+		if ok {
+			if lb, exist := o.lbs[r.ID]; exist {
+				lb.Name = str(props, "name")
+				return
+			}
+		}
+		lb := o.newLoadBalancer(str(props, "name"), str(props, "vip_subnet"), str(props, "vip_address"))
+		r.ID = lb.ID
+	case resMember, resOctaviaMem:
+		if !ok {
+			r.ID = o.newID("member")
+		}
+		var lbid string
+		if v, exist := st.resources["lb"]; exist {
+			lbid = v.ID
+		}
+		o.members[r.ID] = &Member{
+			ID:              r.ID,
+			Address:         str(props, "address"),
+			ProtocolPort:    toInt(props["protocol_port"]),
+			OperatingStatus: "ONLINE",
+			lb:              lbid,
+		}
+	case resFip:
+		fip, exist := o.fips[r.ID]
+		if !ok || !exist {
+			r.ID = o.newID("fip")
+			fip = &FloatingIP{
+				ID:                r.ID,
+				FloatingIP:        o.newIP("172.24"),
+				FloatingNetworkID: str(props, "floating_network"),
+				Status:            "ACTIVE",
+			}
+			o.fips[r.ID] = fip
+		}
+		fip.PortID = str(props, "port_id")
+		fip.FixedIP = str(props, "fixed_ip_address")
+	case resFipAssocate:
+		r.ID = str(props, "floatingip_id")
+		if fip, exist := o.fips[r.ID]; exist {
+			fip.PortID = str(props, "port_id")
+			fip.FixedIP = str(props, "fixed_ip_address")
+		}
+	default:
+		if !ok {
+			r.ID = o.newID("res")
+		}
+	}
+	st.resources[name] = r
+}
+
+func (o *OpenStack) createServer(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Server struct {
+			Name     string              `json:"name"`
+			Metadata map[string]string   `json:"metadata"`
+			Networks []map[string]string `json:"networks"`
+			BDM      []struct {
+				UUID                string `json:"uuid"`
+				DeleteOnTermination bool   `json:"delete_on_termination"`
+			} `json:"block_device_mapping_v2"`
+		} `json:"server"`
+	}
+	readJSON(r, &body)
+	var portid string
+	if len(body.Server.Networks) > 0 {
+		portid = body.Server.Networks[0]["port"]
+	}
+	sv := &Server{
+		ID:        o.newID("server"),
+		Name:      body.Server.Name,
+		Status:    "ACTIVE",
+		Metadata:  body.Server.Metadata,
+		Addresses: o.addresses(portid),
+		Image:     map[string]string{"id": "image"},
+	}
+	for _, bd := range body.Server.BDM {
+		v, ok := o.volumes[bd.UUID]
+		if !ok {
+			writeJSON(w, http.StatusBadRequest, nil)
+			return
+		}
+		v.Status = "in-use"
+		// volume backed server has no image
+		sv.Image = nil
+		sv.AttachedVolumes = append(sv.AttachedVolumes, AttachedVolume{ID: v.ID})
+		if bd.DeleteOnTermination {
+			o.bdms[sv.ID] = append(o.bdms[sv.ID], v.ID)
+		}
+	}
+	o.servers[sv.ID] = sv
+	writeJSON(w, http.StatusAccepted, map[string]interface{}{"server": map[string]string{"id": sv.ID}})
+}
+
+// volumes are detached, and removed if delete on termination
+func (o *OpenStack) deleteServer(id string) {
+	delete(o.servers, id)
+	for _, vid := range o.bdms[id] {
+		delete(o.volumes, vid)
+	}
+	delete(o.bdms, id)
+	for _, v := range o.volumes {
+		if v.Status == "in-use" {
+			v.Status = "available"
+		}
+	}
+}
+
+// address of server on the network of port, the network name is
+// prefix cut if port is created by api
+func (o *OpenStack) addresses(portid string) map[string][]Address {
+	var netname, ip string
+	if port, ok := o.ports[portid]; ok {
+		netname = strings.TrimPrefix(port.NetworkID, "net-")
+		ip = port.FixedIPs[0].IPAddress
+	}
+	if ip == "" {
+		ip = o.newIP("10.0")
+	}
+	return map[string][]Address{
+		netname: {{Version: 4, Addr: ip}},
+	}
+}
+
+func (o *OpenStack) newLoadBalancer(name, subnet, vip string) *LoadBalancer {
+	if vip == "" {
+		vip = o.newIP("10.1")
+	}
+	port := &Port{
+		ID:          o.newID("port"),
+		Name:        "loadbalancer-" + name,
+		DeviceOwner: "neutron:LOADBALANCERV2",
+		FixedIPs:    []FixedIP{{SubnetID: subnet, IPAddress: vip}},
+	}
+	o.ports[port.ID] = port
+	lb := &LoadBalancer{
+		ID:                 o.newID("lb"),
+		Name:               name,
+		VipAddress:         vip,
+		VipPortID:          port.ID,
+		VipSubnetID:        subnet,
+		ProvisioningStatus: "ACTIVE",
+		OperatingStatus:    "ONLINE",
+	}
+	o.lbs[lb.ID] = lb
+	return lb
+}
+
+func (o *OpenStack) remove(st *Stack, name string) {
+	r, ok := st.resources[name]
+	if !ok {
+		return
+	}
+	delete(st.resources, name)
+	switch r.Type {
+	case resPort:
+		delete(o.ports, r.ID)
+	case resServer:
+		delete(o.servers, r.ID)
+	case resLb, resOctaviaLb:
+		if lb, ok := o.lbs[r.ID]; ok {
+			delete(o.ports, lb.VipPortID)
+		}
+		delete(o.lbs, r.ID)
+	case resMember, resOctaviaMem:
+		delete(o.members, r.ID)
+	case resFip:
+		delete(o.fips, r.ID)
+	case resFipAssocate:
+		if fip, ok := o.fips[r.ID]; ok {
+			fip.PortID = ""
+			fip.FixedIP = ""
+		}
+	}
+}
+
+// find load balance of listener, pool or member
+func (o *OpenStack) parentLb(id string) string {
+	for id != "" {
+		if _, ok := o.lbs[id]; ok {
+			return id
+		}
+		id = o.lbaas[id]
+	}
+	return ""
+}
+
+func parseTemplate(data string) (map[string]interface{}, error) {
+	var tpl map[string]interface{}
+	err := yaml.Unmarshal([]byte(data), &tpl)
+	if err != nil {
+		return nil, err
+	}
+	return tpl, nil
+}
+
+func resType(value interface{}) string {
+	m, _ := value.(map[string]interface{})
+	ty, _ := m["type"].(string)
+	return ty
+}
+
+// such as {port: {get_resource: name}}
+func getResource(value interface{}, key string) string {
+	m, _ := value.(map[string]interface{})
+	ref, _ := m[key].(map[string]interface{})
+	name, _ := ref["get_resource"].(string)
+	return name
+}
+
+func str(props map[string]interface{}, key string) string {
+	v, ok := props[key]
+	if !ok || v == nil {
+		return ""
+	}
+	return fmt.Sprint(v)
+}
+
+func toInt(v interface{}) int {
+	switch t := v.(type) {
+	case float64:
+		return int(t)
+	case int64:
+		return int(t)
+	case int:
+		return t
+	default:
+		return 0
+	}
+}
+
+// minor of compute microversion, 2.1 if not set
+func computeMinor(r *http.Request) int {
+	ver := r.Header.Get("X-OpenStack-Nova-API-Version")
+	minor, err := strconv.Atoi(strings.TrimPrefix(ver, "2."))
+	if err != nil {
+		return 1
+	}
+	return minor
+}
+
+func hasKey(m map[string]interface{}, key string) bool {
+	_, ok := m[key]
+	return ok
+}
+
+func splitPath(path, prefix string) []string {
+	path = strings.Trim(strings.TrimPrefix(path, prefix), "/")
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, "/")
+}
+
+func readJSON(r *http.Request, v interface{}) {
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil || len(data) == 0 {
+		return
+	}
+	_ = json.Unmarshal(data, v)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if v == nil {
+		return
+	}
+	_ = json.NewEncoder(w).Encode(v)
+}
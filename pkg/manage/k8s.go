@@ -2,11 +2,14 @@ package manage
 
 import (
 	goctx "context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 
-	"encoding/binary"
+	"bytes"
 	"net"
+	"reflect"
 	"sort"
 	"strings"
 	"sync"
@@ -15,12 +18,18 @@ import (
 	vmv1 "easystack.io/vm-operator/pkg/api/v1"
 	"easystack.io/vm-operator/pkg/util"
 	"github.com/tidwall/gjson"
+	corev1 "k8s.io/api/core/v1"
 	apierrs "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 	klog "k8s.io/klog/v2"
 )
 
@@ -28,8 +37,47 @@ type K8Res int
 
 const (
 	Pod K8Res = iota
+	Service
 
 	network_status = "k8s.v1.cni.cncf.io/networks-status"
+
+	defaultNetwork = "kuryr"
+
+	// labels and annotation on service which synced by link,
+	// which point to the owner virtual machine
+	ServiceOwnerName      = "mixapp.easystack.io/owner-name"
+	ServiceOwnerNamespace = "mixapp.easystack.io/owner-namespace"
+	serviceLinkAnnotation = "mixapp.easystack.io/link"
+	// length of random suffix on name of service created by old version
+	legacySuffixLen = 5
+
+	// label of service and endpoint slices which expose members
+	ExposeOwnerName = "mixapp.easystack.io/expose-owner"
+
+	// LoadBalancerClass of LoadBalancer service which synced by link
+	LoadBalancerClass = "mixapp.easystack.io/openstack"
+
+	// EndpointSliceServiceName label of endpoint slices which point to the service
+	EndpointSliceServiceName = "kubernetes.io/service-name"
+)
+
+var (
+	podGvr = schema.GroupVersionResource{
+		Version:  "v1",
+		Resource: "pods",
+	}
+	serviceGvr = schema.GroupVersionResource{
+		Version:  "v1",
+		Resource: "services",
+	}
+	vmGvr = vmv1.GroupVersion.WithResource("virtualmachines")
+	// v1 is served since kubernetes 1.21, which is required by expose,
+	// and v1beta1 is not served since kubernetes 1.25
+	endpointSliceGvr = schema.GroupVersionResource{
+		Group:    "discovery.k8s.io",
+		Version:  "v1",
+		Resource: "endpointslices",
+	}
 )
 
 // 1. Sync service which externalIPs is lb ip
@@ -40,28 +88,42 @@ type K8sMgr struct {
 	ctx    goctx.Context
 	stopch chan struct{}
 	client dynamic.Interface
+
+	// pods and workloads of links are watched by informers,
+	// handlers are added once for each resource
+	factory dynamicinformer.DynamicSharedInformerFactory
+	watched map[schema.GroupVersionResource]cache.InformerSynced
+	notify  func(owner *corev1.ObjectReference)
+
+	recorder record.EventRecorder
 }
 
+// ErrNotSynced is returned when informers of link are not synced, ips of
+// link are incomplete, which should not be used to update members
+var ErrNotSynced = errors.New("informers of link are not synced")
+
 type Results []*Result
 
 type Result struct {
 	Ip      net.IP
 	PodName string
+	// Ready is true when pod is ready and not terminating
+	Ready bool
 }
 
 func (t Results) Len() int {
 	return len(t)
 }
 
+// ipv4 is compared in ipv6 form, so it is sorted before ipv6
 func (t Results) Less(i, j int) bool {
-	inti := binary.BigEndian.Uint32(t[i].Ip.To4())
-	intj := binary.BigEndian.Uint32(t[j].Ip.To4())
-	return inti < intj
+	return bytes.Compare(t[i].Ip.To16(), t[j].Ip.To16()) < 0
 }
 
 func (t Results) Swap(i, j int) {
 	t[i].Ip, t[j].Ip = t[j].Ip, t[i].Ip
 	t[i].PodName, t[j].PodName = t[j].PodName, t[i].PodName
+	t[i].Ready, t[j].Ready = t[j].Ready, t[i].Ready
 }
 
 type info struct {
@@ -79,6 +141,18 @@ type info struct {
 	portmap  []*vmv1.PortMap
 	hashid   int64
 	link     string
+
+	// the object which events of service recorded on
+	owner *corev1.ObjectReference
+
+	// type of service, and floating ip which bound to lb ip
+	svctype  string
+	publicip net.IP
+
+	// workload of link, and ips of pods selected by workload
+	res    *Resource
+	source *vmv1.IpSource
+	ips    Results
 }
 
 type Resource struct {
@@ -94,25 +168,47 @@ func (r *Resource) IsResource(res K8Res) bool {
 	switch res {
 	case Pod:
 		return r.kind == "pods"
+	case Service:
+		return r.kind == "services"
 	default:
 		return false
 	}
 }
 
+func (r *Resource) gvr() schema.GroupVersionResource {
+	return schema.GroupVersionResource{
+		Group:    r.group,
+		Version:  r.version,
+		Resource: r.kind,
+	}
+}
+
 func (r *Resource) NamespaceName() string {
 	return fmt.Sprintf("%s/%s", r.namespace, r.name)
 }
 
-func NewK8sMgr(client dynamic.Interface) *K8sMgr {
+func NewK8sMgr(client dynamic.Interface, recorder record.EventRecorder) *K8sMgr {
 	mgr := &K8sMgr{
-		client: client,
-		ctx:    goctx.Background(),
-		stopch: make(chan struct{}),
-		lbinfo: make(map[string]*info),
+		client:   client,
+		recorder: recorder,
+		ctx:      goctx.Background(),
+		stopch:   make(chan struct{}),
+		lbinfo:   make(map[string]*info),
+
+		factory: dynamicinformer.NewDynamicSharedInformerFactory(client, 0),
+		watched: make(map[schema.GroupVersionResource]cache.InformerSynced),
 	}
 	return mgr
 }
 
+// Regist fn which is called when kuryr ips of link changed,
+// owner is the object added with link
+func (p *K8sMgr) Regist(fn func(owner *corev1.ObjectReference)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.notify = fn
+}
+
 func (p *K8sMgr) hashinfo(val *info) int64 {
 	buf := util.GetBuf()
 	defer util.PutBuf(buf)
@@ -123,6 +219,8 @@ func (p *K8sMgr) hashinfo(val *info) int64 {
 		buf.WriteByte('f')
 	}
 	buf.WriteString(val.lbip.String())
+	buf.WriteString(val.publicip.String())
+	buf.WriteString(val.svctype)
 	buf.WriteString(val.link)
 	if len(val.portmap) == 0 {
 		return util.Hashid(buf.Bytes())
@@ -137,48 +235,123 @@ func (p *K8sMgr) hashinfo(val *info) int64 {
 
 // TODO add more worker on sync pod
 func (p *K8sMgr) loop(timed time.Duration, getlbfn func(link string) net.IP) {
-	var err error
 	for {
 		select {
 		case <-p.stopch:
 			klog.Info("receive stop signal, exit!")
 			return
 		case <-time.NewTimer(timed).C:
-			p.mu.RLock()
-			for link, val := range p.lbinfo {
-				if val.isdelete == true {
-					delete(p.lbinfo, link)
-				}
-				if !val.isservice {
-					continue
-				}
-				if !val.existip {
-					val.lbip = getlbfn(link)
-				}
-				if val.lbip == nil {
-					klog.Infof("not found lb ip for link:%v, skip sync service", val.link)
-					continue
-				}
-				hashid := p.hashinfo(val)
-				if val.hashid == hashid {
-					continue
-				}
-				klog.V(4).Infof("start sync k8s service on link:%v", val.link)
+			p.syncLinks(getlbfn)
+		}
+	}
+}
 
-				err = p.updateService(val.lbip, val)
-				if err != nil {
-					klog.Infof("update service failed:%v", err)
-				}
-				val.hashid = hashid
-				klog.V(4).Infof("sync service done, delete:%v, link:%v", val.isdelete, val.link)
+// sync services of links, deleted link is kept until its service
+// is removed, so failed deletion is retried on next loop
+func (p *K8sMgr) syncLinks(getlbfn func(link string) net.IP) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for link, val := range p.lbinfo {
+		if !val.isservice {
+			if val.isdelete {
+				delete(p.lbinfo, link)
+			}
+			continue
+		}
+		if val.isdelete {
+			err := p.updateService(val.lbip, val)
+			if err != nil {
+				klog.Infof("delete service of link %v failed:%v", link, err)
+				continue
 			}
-			p.mu.RUnlock()
+			klog.V(4).Infof("sync service done, delete:%v, link:%v", val.isdelete, val.link)
+			delete(p.lbinfo, link)
+			continue
+		}
+		if !val.existip {
+			val.lbip = getlbfn(link)
+		}
+		if val.lbip == nil {
+			klog.Infof("not found lb ip for link:%v, skip sync service", val.link)
+			continue
+		}
+		hashid := p.hashinfo(val)
+		if val.hashid == hashid {
+			continue
+		}
+		klog.V(4).Infof("start sync k8s service on link:%v", val.link)
+
+		err := p.updateService(val.lbip, val)
+		if err != nil {
+			// retry on next loop
+			klog.Infof("update service failed:%v", err)
+			continue
 		}
+		val.hashid = hashid
+		klog.V(4).Infof("sync service done, delete:%v, link:%v", val.isdelete, val.link)
 	}
 }
 
 func (p *K8sMgr) Run(du time.Duration, getlbfn func(link string) net.IP) {
-	go p.loop(du, getlbfn)
+	go func() {
+		p.cleanServices()
+		p.loop(du, getlbfn)
+	}()
+}
+
+// remove services which owner is deleted or not use the link any more,
+// the others are adopted by name on sync
+func (p *K8sMgr) cleanServices() {
+	svcs, err := p.client.Resource(serviceGvr).List(p.ctx, metav1.ListOptions{LabelSelector: ServiceOwnerName})
+	if err != nil {
+		klog.Errorf("list services failed:%v", err)
+		return
+	}
+	for i := range svcs.Items {
+		svc := &svcs.Items[i]
+		owner := svc.GetLabels()
+		link := svc.GetAnnotations()[serviceLinkAnnotation]
+		used, err := p.linkUsed(owner[ServiceOwnerNamespace], owner[ServiceOwnerName], link)
+		if err != nil {
+			klog.Errorf("check owner of service %s/%s failed:%v", svc.GetNamespace(), svc.GetName(), err)
+			continue
+		}
+		if used {
+			continue
+		}
+		klog.Infof("remove stale service %s/%s of link %s", svc.GetNamespace(), svc.GetName(), link)
+		err = p.client.Resource(serviceGvr).Namespace(svc.GetNamespace()).Delete(p.ctx, svc.GetName(), metav1.DeleteOptions{})
+		if err != nil && !apierrs.IsNotFound(err) {
+			klog.Errorf("delete service %s/%s failed:%v", svc.GetNamespace(), svc.GetName(), err)
+		}
+	}
+}
+
+// the virtual machine exists and sync service of link
+func (p *K8sMgr) linkUsed(namespace, name, link string) (bool, error) {
+	obj, err := p.client.Resource(vmGvr).Namespace(namespace).Get(p.ctx, name, metav1.GetOptions{})
+	if err != nil {
+		if apierrs.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	lbobj, found, err := unstructured.NestedMap(obj.Object, "spec", "loadbalance")
+	if err != nil || !found {
+		return false, err
+	}
+	spec := new(vmv1.LoadBalanceSpec)
+	err = runtime.DefaultUnstructuredConverter.FromUnstructured(lbobj, spec)
+	if err != nil {
+		return false, err
+	}
+	if spec.LinkRef != nil {
+		spec.Link, err = RefLink(spec.LinkRef, namespace)
+		if err != nil {
+			return false, err
+		}
+	}
+	return spec.UseService && spec.Link == link, nil
 }
 
 func (p *K8sMgr) Stop() {
@@ -186,52 +359,226 @@ func (p *K8sMgr) Stop() {
 }
 
 func (p *K8sMgr) updateService(lbip net.IP, val *info) error {
-
 	var (
-		gvk = schema.GroupVersionResource{
-			Version:  "v1",
-			Resource: "services",
-		}
-		ns_map = make(map[string]struct{})
-		link   = val.link
+		res = val.res
+		cli dynamic.ResourceInterface
 	)
-
-	labels, res, err := getLinkLabels(p.client, link)
-	if err != nil {
-		return err
+	if res == nil {
+		return fmt.Errorf("invalid link %s", val.link)
 	}
+	cli = p.client.Resource(serviceGvr).Namespace(res.namespace)
 
 	if val.isdelete == true {
-		err = p.client.Resource(gvk).Namespace(res.namespace).Delete(p.ctx, res.svcname, metav1.DeleteOptions{})
+		err := cli.Delete(p.ctx, res.svcname, metav1.DeleteOptions{})
 		if err != nil {
+			if apierrs.IsNotFound(err) {
+				return nil
+			}
 			return err
 		}
+		p.event(val, corev1.EventTypeNormal, "ServiceDeleted", "deleted service %s/%s", res.namespace, res.svcname)
+		return nil
 	}
 
+	obj, err := p.factory.ForResource(res.gvr()).Lister().ByNamespace(res.namespace).Get(res.name)
+	if err != nil {
+		return fmt.Errorf("get resource failed,name=%s, err=%s", res.name, err)
+	}
+	wk, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return fmt.Errorf("unexpected type %T", obj)
+	}
+	labels, err := linkLabels(wk)
+	if err != nil {
+		return err
+	}
 	svcunstruct := serviceExternalUnstract(labels, res.namespace, res.svcname, lbip.String(), val.portmap)
-	_, err = p.client.Resource(gvk).Namespace(res.namespace).Get(p.ctx, res.svcname, metav1.GetOptions{})
+	if val.svctype == vmv1.ServiceTypeLoadBalancer {
+		loadBalancerService(svcunstruct)
+	}
+	setServiceOwner(svcunstruct, val)
+
+	exist, err := cli.Get(p.ctx, res.svcname, metav1.GetOptions{})
+	if err == nil && serviceType(exist) != serviceType(svcunstruct) {
+		// type and class can not be changed in place, recreate it
+		klog.Infof("service %s/%s type changed to %s, recreate it", res.namespace, res.svcname, serviceType(svcunstruct))
+		err = cli.Delete(p.ctx, res.svcname, metav1.DeleteOptions{})
+		if err != nil && !apierrs.IsNotFound(err) {
+			return err
+		}
+		err = apierrs.NewNotFound(serviceGvr.GroupResource(), res.svcname)
+	}
 	if err != nil {
-		//create, the err should be not found type
-		ns_map[res.namespace] = struct{}{}
-		_, err = p.client.Resource(gvk).Namespace(res.namespace).Create(p.ctx, svcunstruct, metav1.CreateOptions{})
+		if !apierrs.IsNotFound(err) {
+			return err
+		}
+		_, err = cli.Create(p.ctx, svcunstruct, metav1.CreateOptions{})
 		if err != nil {
+			p.event(val, corev1.EventTypeWarning, "ServiceCreateFailed", "create service %s/%s failed: %v", res.namespace, res.svcname, err)
 			return err
 		}
+		p.event(val, corev1.EventTypeNormal, "ServiceCreated", "created service %s/%s", res.namespace, res.svcname)
 	} else {
-		//update
-		if _, ok := ns_map[res.namespace]; !ok {
-			klog.V(2).Infof("patch k8s service", "name", res.svcname, "ns", res.namespace, "value", svcunstruct.Object)
-			data, err := json.Marshal(svcunstruct.Object["spec"])
-			_, err = p.client.Resource(gvk).Namespace(res.namespace).Patch(p.ctx, res.svcname, types.MergePatchType, data, metav1.PatchOptions{})
-			if err != nil {
-				return err
-			}
+		// metadata is patched too, which adopt the service left by last run
+		klog.V(2).Infof("patch k8s service %s/%s", res.namespace, res.svcname)
+		data, err := json.Marshal(map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"labels":          svcunstruct.GetLabels(),
+				"annotations":     svcunstruct.GetAnnotations(),
+				"ownerReferences": svcunstruct.Object["metadata"].(map[string]interface{})["ownerReferences"],
+			},
+			"spec": svcunstruct.Object["spec"],
+		})
+		if err != nil {
+			return err
+		}
+		_, err = cli.Patch(p.ctx, res.svcname, types.MergePatchType, data, metav1.PatchOptions{})
+		if err != nil {
+			return err
+		}
+	}
+	p.cleanLegacyServices(cli, val, labels, lbip)
+	if val.svctype != vmv1.ServiceTypeLoadBalancer {
+		return nil
+	}
+	return p.updateIngress(cli, res.svcname, lbip, val.publicip)
+}
+
+// services of old version are named "<workload>-<rand>" without labels,
+// and a new one is leaked on every restart. they are matched by selector
+// and lb ip of link, and removed once the owned service is synced
+func (p *K8sMgr) cleanLegacyServices(cli dynamic.ResourceInterface, val *info, labels map[string]string, lbip net.IP) {
+	svcs, err := cli.List(p.ctx, metav1.ListOptions{})
+	if err != nil {
+		klog.Errorf("list services in %s failed:%v", val.res.namespace, err)
+		return
+	}
+	for i := range svcs.Items {
+		svc := &svcs.Items[i]
+		if !isLegacyService(svc, val.res.name, labels, lbip) {
+			continue
 		}
+		klog.Infof("remove legacy service %s/%s of link %s", svc.GetNamespace(), svc.GetName(), val.link)
+		err = cli.Delete(p.ctx, svc.GetName(), metav1.DeleteOptions{})
+		if err != nil && !apierrs.IsNotFound(err) {
+			klog.Errorf("delete service %s/%s failed:%v", svc.GetNamespace(), svc.GetName(), err)
+			continue
+		}
+		p.event(val, corev1.EventTypeNormal, "ServiceDeleted", "deleted legacy service %s/%s", svc.GetNamespace(), svc.GetName())
 	}
+}
 
+func isLegacyService(svc *unstructured.Unstructured, workload string, labels map[string]string, lbip net.IP) bool {
+	if _, ok := svc.GetLabels()[ServiceOwnerName]; ok {
+		return false
+	}
+	suffix := strings.TrimPrefix(svc.GetName(), workload+"-")
+	if suffix == svc.GetName() || len(suffix) != legacySuffixLen {
+		return false
+	}
+	for _, c := range suffix {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'z') {
+			return false
+		}
+	}
+	ips, _, _ := unstructured.NestedStringSlice(svc.Object, "spec", "externalIPs")
+	if len(ips) != 1 || !lbip.Equal(net.ParseIP(ips[0])) {
+		return false
+	}
+	selector, _, _ := unstructured.NestedStringMap(svc.Object, "spec", "selector")
+	return len(selector) != 0 && reflect.DeepEqual(selector, labels)
+}
+
+// ingress of LoadBalancer service are lb ip and floating ip,
+// which is used by tools such as external-dns
+func (p *K8sMgr) updateIngress(cli dynamic.ResourceInterface, name string, ips ...net.IP) error {
+	var ingress []interface{}
+	for _, ip := range ips {
+		if ip == nil {
+			continue
+		}
+		ingress = append(ingress, map[string]interface{}{"ip": ip.String()})
+	}
+	data, err := json.Marshal(map[string]interface{}{
+		"status": map[string]interface{}{
+			"loadBalancer": map[string]interface{}{
+				"ingress": ingress,
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = cli.Patch(p.ctx, name, types.MergePatchType, data, metav1.PatchOptions{}, "status")
+	if err != nil {
+		return fmt.Errorf("update ingress of service %s failed:%v", name, err)
+	}
 	return nil
 }
 
+// ClusterIP service with externalIPs is changed to LoadBalancer service,
+// which is ignored by other load balancer controllers by class
+func loadBalancerService(svc *unstructured.Unstructured) {
+	spec := svc.Object["spec"].(map[string]interface{})
+	delete(spec, "externalIPs")
+	spec["type"] = "LoadBalancer"
+	spec["loadBalancerClass"] = LoadBalancerClass
+	spec["allocateLoadBalancerNodePorts"] = false
+}
+
+func serviceType(svc *unstructured.Unstructured) string {
+	typ, _, _ := unstructured.NestedString(svc.Object, "spec", "type")
+	if typ == "" {
+		return "ClusterIP"
+	}
+	return typ
+}
+
+// service name is generated by owner and workload, which is dns-1035 label
+func serviceName(owner *corev1.ObjectReference, res *Resource) string {
+	name := res.name
+	if owner != nil {
+		name = fmt.Sprintf("%s-%s", owner.Name, res.name)
+	}
+	name = strings.ToLower(strings.Replace(name, ".", "-", -1))
+	if len(name) > 63 {
+		hash := uint64(util.Hashid(util.Str2bytes(name)))
+		name = fmt.Sprintf("%s-%x", strings.TrimRight(name[:46], "-"), hash)
+	}
+	return name
+}
+
+// owner reference is only set in the same namespace,
+// labels are used to find the owner in other namespace
+func setServiceOwner(svc *unstructured.Unstructured, val *info) {
+	owner := val.owner
+	if owner == nil {
+		return
+	}
+	svc.SetLabels(map[string]string{
+		ServiceOwnerName:      owner.Name,
+		ServiceOwnerNamespace: owner.Namespace,
+	})
+	svc.SetAnnotations(map[string]string{
+		serviceLinkAnnotation: val.link,
+	})
+	if owner.Namespace == svc.GetNamespace() && owner.UID != "" {
+		svc.SetOwnerReferences([]metav1.OwnerReference{{
+			APIVersion: owner.APIVersion,
+			Kind:       owner.Kind,
+			Name:       owner.Name,
+			UID:        owner.UID,
+		}})
+	}
+}
+
+func (p *K8sMgr) event(val *info, eventtype, reason, messageFmt string, args ...interface{}) {
+	if p.recorder == nil || val.owner == nil {
+		return
+	}
+	p.recorder.Eventf(val.owner, eventtype, reason, messageFmt, args...)
+}
+
 func (p *K8sMgr) LinkIsExist(link string) bool {
 	p.mu.RLock()
 	defer p.mu.RUnlock()
@@ -241,6 +588,206 @@ func (p *K8sMgr) LinkIsExist(link string) bool {
 	return false
 }
 
+// Expose sync selectorless service and endpoint slices in namespace of owner,
+// which endpoints are ips. there is one slice for each address type
+func (p *K8sMgr) Expose(owner *corev1.ObjectReference, name string, ports []*vmv1.ExposePort, ips []string) error {
+	var (
+		svcports   []interface{}
+		sliceports []interface{}
+		families   = map[string][]interface{}{"IPv4": nil, "IPv6": nil}
+	)
+	for _, port := range ports {
+		proto := port.Protocol
+		if proto == "" {
+			proto = string(corev1.ProtocolTCP)
+		}
+		portname := port.Name
+		if portname == "" {
+			portname = fmt.Sprintf("%s-%d", strings.ToLower(proto), port.Port)
+		}
+		svcports = append(svcports, map[string]interface{}{
+			"name":     portname,
+			"port":     int64(port.Port),
+			"protocol": proto,
+		})
+		sliceports = append(sliceports, map[string]interface{}{
+			"name":     portname,
+			"port":     int64(port.Port),
+			"protocol": proto,
+		})
+	}
+	for _, v := range ips {
+		ip := net.ParseIP(v)
+		if ip == nil {
+			continue
+		}
+		family := "IPv6"
+		if ip.To4() != nil {
+			family = "IPv4"
+		}
+		families[family] = append(families[family], map[string]interface{}{
+			"addresses":  []interface{}{ip.String()},
+			"conditions": map[string]interface{}{"ready": true},
+		})
+	}
+
+	svc := exposeObject(owner, "v1", "Service", name, name)
+	svc.Object["spec"] = map[string]interface{}{
+		"ports": svcports,
+	}
+	err := p.applyExpose(owner, serviceGvr, svc, map[string]interface{}{"spec": svc.Object["spec"]})
+	if err != nil {
+		return err
+	}
+	for family, endpoints := range families {
+		slicename := fmt.Sprintf("%s-%s", name, strings.ToLower(family))
+		if len(endpoints) == 0 {
+			err = p.deleteExposed(owner, endpointSliceGvr, slicename)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		slice := exposeObject(owner, endpointSliceGvr.GroupVersion().String(), "EndpointSlice", slicename, name)
+		slice.Object["addressType"] = family
+		slice.Object["endpoints"] = endpoints
+		slice.Object["ports"] = sliceports
+		err = p.applyExpose(owner, endpointSliceGvr, slice, map[string]interface{}{
+			"endpoints": endpoints,
+			"ports":     sliceports,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Unexpose remove service and endpoint slices which created by Expose,
+// NotOwnedError is returned when they are not created by owner
+func (p *K8sMgr) Unexpose(owner *corev1.ObjectReference, name string) error {
+	err := p.deleteExposed(owner, serviceGvr, name)
+	if err != nil {
+		return err
+	}
+	for _, family := range []string{"ipv4", "ipv6"} {
+		slicename := fmt.Sprintf("%s-%s", name, family)
+		err = p.deleteExposed(owner, endpointSliceGvr, slicename)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NotOwnedError is returned when object with the same name exists,
+// but not created by Expose of owner
+type NotOwnedError struct {
+	Kind      string
+	Namespace string
+	Name      string
+}
+
+func (e *NotOwnedError) Error() string {
+	return fmt.Sprintf("%s %s/%s exists and is not created by expose", e.Kind, e.Namespace, e.Name)
+}
+
+// object is created by Expose of owner, uid is checked when both set
+func exposedBy(obj *unstructured.Unstructured, owner *corev1.ObjectReference) bool {
+	if obj.GetLabels()[ExposeOwnerName] != owner.Name {
+		return false
+	}
+	refs := obj.GetOwnerReferences()
+	if owner.UID == "" || len(refs) == 0 {
+		return true
+	}
+	for _, ref := range refs {
+		if ref.UID == owner.UID {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *K8sMgr) deleteExposed(owner *corev1.ObjectReference, gvr schema.GroupVersionResource, name string) error {
+	cli := p.client.Resource(gvr).Namespace(owner.Namespace)
+	exist, err := cli.Get(p.ctx, name, metav1.GetOptions{})
+	if err != nil {
+		if apierrs.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if !exposedBy(exist, owner) {
+		return &NotOwnedError{Kind: exist.GetKind(), Namespace: owner.Namespace, Name: name}
+	}
+	err = cli.Delete(p.ctx, name, metav1.DeleteOptions{})
+	if err != nil && !apierrs.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// create object, or merge patch by patch when exists and created by owner
+func (p *K8sMgr) applyExpose(owner *corev1.ObjectReference, gvr schema.GroupVersionResource, obj *unstructured.Unstructured, patch map[string]interface{}) error {
+	cli := p.client.Resource(gvr).Namespace(obj.GetNamespace())
+	exist, err := cli.Get(p.ctx, obj.GetName(), metav1.GetOptions{})
+	if err != nil {
+		if !apierrs.IsNotFound(err) {
+			return err
+		}
+		_, err = cli.Create(p.ctx, obj, metav1.CreateOptions{})
+		if err != nil {
+			return fmt.Errorf("create %s %s/%s failed:%v", obj.GetKind(), obj.GetNamespace(), obj.GetName(), err)
+		}
+		klog.Infof("created %s %s/%s", obj.GetKind(), obj.GetNamespace(), obj.GetName())
+		return nil
+	}
+	if !exposedBy(exist, owner) {
+		return &NotOwnedError{Kind: obj.GetKind(), Namespace: obj.GetNamespace(), Name: obj.GetName()}
+	}
+	data, err := json.Marshal(patch)
+	if err != nil {
+		return err
+	}
+	_, err = cli.Patch(p.ctx, obj.GetName(), types.MergePatchType, data, metav1.PatchOptions{})
+	return err
+}
+
+// object owned by virtual machine, service is label of endpoint slice
+func exposeObject(owner *corev1.ObjectReference, apiversion, kind, name, service string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": apiversion,
+		"kind":       kind,
+	}}
+	obj.SetNamespace(owner.Namespace)
+	obj.SetName(name)
+	labels := map[string]string{ExposeOwnerName: owner.Name}
+	if kind == "EndpointSlice" {
+		labels["kubernetes.io/service-name"] = service
+		labels["endpointslice.kubernetes.io/managed-by"] = vmv1.GroupVersion.Group
+	}
+	obj.SetLabels(labels)
+	if owner.UID != "" {
+		obj.SetOwnerReferences([]metav1.OwnerReference{{
+			APIVersion: owner.APIVersion,
+			Kind:       owner.Kind,
+			Name:       owner.Name,
+			UID:        owner.UID,
+		}})
+	}
+	return obj
+}
+
+// SetPublicIp record floating ip of link, which is one of ingress
+func (p *K8sMgr) SetPublicIp(link string, ip net.IP) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if val, ok := p.lbinfo[link]; ok {
+		val.publicip = ip
+	}
+}
+
 func (p *K8sMgr) DelLinks(links ...string) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
@@ -252,7 +799,9 @@ func (p *K8sMgr) DelLinks(links ...string) {
 	}
 }
 
-func (p *K8sMgr) AddLinks(link string, lbip net.IP, portmap []*vmv1.PortMap, useservcie bool) {
+// AddLinks add or update link, source is where to find ip of pods,
+// and svctype is type of service when useservice
+func (p *K8sMgr) AddLinks(link string, lbip net.IP, portmap []*vmv1.PortMap, useservcie bool, svctype string, source *vmv1.IpSource, owner *corev1.ObjectReference) {
 	if link == "" || len(portmap) == 0 {
 		klog.Info("add link failed, not found portmap or link")
 		return
@@ -266,19 +815,322 @@ func (p *K8sMgr) AddLinks(link string, lbip net.IP, portmap []*vmv1.PortMap, use
 		newpm[i] = v.DeepCopy()
 	}
 
-	klog.Infof("link add lbip:%v, link:%v", lbip, link)
+	source = defaultIpSource(source)
 	if val, ok := p.lbinfo[link]; ok {
+		// used again before its service removed
+		val.isdelete = false
 		val.portmap = val.portmap[:0]
 		val.portmap = newpm
+		val.owner = owner
+		val.svctype = svctype
+		if *val.source != *source {
+			klog.Infof("ip source of link %s changed to %v", link, source.Type)
+			val.source = source
+			if val.res != nil {
+				p.watchLink(val)
+				p.refreshLink(val)
+			}
+		}
 	} else {
-		p.lbinfo[link] = &info{
+		klog.Infof("link add lbip:%v, link:%v", lbip, link)
+		res := new(Resource)
+		if err := ParseLink(link, res); err != nil {
+			klog.Errorf("parse link %s failed:%v", link, err)
+			res = nil
+		} else {
+			res.svcname = serviceName(owner, res)
+		}
+		val = &info{
 			portmap:   newpm,
 			link:      link,
 			lbip:      lbip,
 			existip:   lbip != nil,
 			isservice: useservcie,
+			owner:     owner,
+			res:       res,
+			source:    source,
+			svctype:   svctype,
+		}
+		p.lbinfo[link] = val
+		if res != nil {
+			p.watchLink(val)
+			// informers may be synced by other links
+			p.refreshLink(val)
+		}
+	}
+}
+
+// resources which ips of link are found from, pods are selected by
+// workload, or addresses are found in endpoint slices of service
+func linkGvrs(val *info) []schema.GroupVersionResource {
+	if val.source.Type == vmv1.IpSourceEndpointSlices {
+		return []schema.GroupVersionResource{endpointSliceGvr, val.res.gvr()}
+	}
+	return []schema.GroupVersionResource{podGvr, val.res.gvr()}
+}
+
+func (p *K8sMgr) watchLink(val *info) {
+	for _, gvr := range linkGvrs(val) {
+		p.watch(gvr)
+	}
+}
+
+// link is affected by changed object of gvr
+func linkAffected(val *info, gvr schema.GroupVersionResource, object *unstructured.Unstructured) bool {
+	if val.res.namespace != object.GetNamespace() {
+		return false
+	}
+	switch gvr {
+	case podGvr:
+		// pods changed may affect all links in namespace
+		return val.source.Type != vmv1.IpSourceEndpointSlices
+	case endpointSliceGvr:
+		return val.source.Type == vmv1.IpSourceEndpointSlices &&
+			val.res.name == object.GetLabels()[EndpointSliceServiceName]
+	}
+	return val.res.gvr() == gvr && val.res.name == object.GetName()
+}
+
+// start informer of gvr and add handlers once
+func (p *K8sMgr) watch(gvr schema.GroupVersionResource) {
+	if _, ok := p.watched[gvr]; ok {
+		return
+	}
+	klog.V(2).Infof("start watch resource %s", gvr.String())
+	informer := p.factory.ForResource(gvr).Informer()
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			p.onChange(gvr, obj)
+		},
+		UpdateFunc: func(_, obj interface{}) {
+			p.onChange(gvr, obj)
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tomb, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tomb.Obj
+			}
+			p.onChange(gvr, obj)
+		},
+	})
+	p.watched[gvr] = informer.HasSynced
+	p.factory.Start(p.stopch)
+	go p.waitSynced(gvr, informer.HasSynced)
+}
+
+// links skipped before synced are refreshed and notified once synced
+func (p *K8sMgr) waitSynced(gvr schema.GroupVersionResource, synced cache.InformerSynced) {
+	if !cache.WaitForCacheSync(p.stopch, synced) {
+		return
+	}
+	klog.V(2).Infof("resource %s is synced", gvr.String())
+	var owners []*corev1.ObjectReference
+	p.mu.Lock()
+	notify := p.notify
+	for _, val := range p.lbinfo {
+		if val.isdelete || val.res == nil || !p.linkSynced(val) {
+			continue
+		}
+		if !hasGvr(linkGvrs(val), gvr) {
+			continue
+		}
+		p.refreshLink(val)
+		if val.owner != nil {
+			owners = append(owners, val.owner)
+		}
+	}
+	p.mu.Unlock()
+
+	if notify == nil {
+		return
+	}
+	for _, owner := range owners {
+		notify(owner)
+	}
+}
+
+func hasGvr(gvrs []schema.GroupVersionResource, gvr schema.GroupVersionResource) bool {
+	for _, v := range gvrs {
+		if v == gvr {
+			return true
+		}
+	}
+	return false
+}
+
+// link is synced when informers of pods or endpoint slices and its workload are synced
+func (p *K8sMgr) linkSynced(val *info) bool {
+	for _, gvr := range linkGvrs(val) {
+		synced, ok := p.watched[gvr]
+		if !ok || !synced() {
+			return false
+		}
+	}
+	return true
+}
+
+// refresh links which may be affected by the object, and notify
+// owners of links which ips changed
+func (p *K8sMgr) onChange(gvr schema.GroupVersionResource, obj interface{}) {
+	object, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+	var owners []*corev1.ObjectReference
+	p.mu.Lock()
+	notify := p.notify
+	for _, val := range p.lbinfo {
+		if val.isdelete || val.res == nil || !linkAffected(val, gvr, object) {
+			continue
+		}
+		if p.refreshLink(val) && val.owner != nil {
+			owners = append(owners, val.owner)
+		}
+	}
+	p.mu.Unlock()
+
+	if notify == nil {
+		return
+	}
+	for _, owner := range owners {
+		klog.V(3).Infof("kuryr ips changed on link of %s/%s", owner.Namespace, owner.Name)
+		notify(owner)
+	}
+}
+
+// rebuild kuryr ips of link from informer cache, return true when ips changed
+func (p *K8sMgr) refreshLink(val *info) bool {
+	var ips Results
+	obj, err := p.factory.ForResource(val.res.gvr()).Lister().ByNamespace(val.res.namespace).Get(val.res.name)
+	if err == nil {
+		if val.source.Type == vmv1.IpSourceEndpointSlices {
+			ips, err = p.sliceIps(val.res.namespace, val.res.name)
+		} else {
+			ips, err = p.podIps(val.res.namespace, obj, val.source)
+		}
+	}
+	if err != nil && !apierrs.IsNotFound(err) {
+		klog.V(2).Infof("refresh link %s failed:%v", val.link, err)
+	}
+	sort.Sort(ips)
+	if equalResults(val.ips, ips) {
+		return false
+	}
+	val.ips = ips
+	return true
+}
+
+func (p *K8sMgr) podIps(namespace string, obj runtime.Object, source *vmv1.IpSource) (Results, error) {
+	var (
+		ips  Results
+		errs = util.NewErr()
+	)
+	wk, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return nil, fmt.Errorf("unexpected type %T", obj)
+	}
+	selector, err := linkSelector(wk)
+	if err != nil {
+		return nil, err
+	}
+	pods, err := p.factory.ForResource(podGvr).Lister().ByNamespace(namespace).List(selector)
+	if err != nil {
+		return nil, err
+	}
+	for _, pod := range pods {
+		object, ok := pod.(*unstructured.Unstructured)
+		if !ok {
+			continue
+		}
+		ready := podReady(object)
+		errs.Add(sourceIps(object, source, func(podname string, ip net.IP) {
+			ips = append(ips, &Result{Ip: ip, PodName: podname, Ready: ready})
+		}))
+	}
+	return ips, errs.Error()
+}
+
+// addresses in endpoint slices of service, which is same with endpoints
+// of service, so selectorless service is supported
+func (p *K8sMgr) sliceIps(namespace, service string) (Results, error) {
+	var (
+		ips  Results
+		seen = make(map[string]struct{})
+	)
+	selector := labels.SelectorFromSet(labels.Set{EndpointSliceServiceName: service})
+	slices, err := p.factory.ForResource(endpointSliceGvr).Lister().ByNamespace(namespace).List(selector)
+	if err != nil {
+		return nil, err
+	}
+	for _, obj := range slices {
+		slice, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			continue
+		}
+		endpoints, _, _ := unstructured.NestedSlice(slice.Object, "endpoints")
+		for _, v := range endpoints {
+			ep, ok := v.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			ready := endpointReady(ep)
+			podname, _, _ := unstructured.NestedString(ep, "targetRef", "name")
+			addrs, _, _ := unstructured.NestedStringSlice(ep, "addresses")
+			for _, addr := range addrs {
+				ip := net.ParseIP(addr)
+				if ip == nil {
+					continue
+				}
+				// endpoint may be in multiple slices when updating
+				if _, ok := seen[ip.String()]; ok {
+					continue
+				}
+				seen[ip.String()] = struct{}{}
+				ips = append(ips, &Result{Ip: ip, PodName: podname, Ready: ready})
+			}
+		}
+	}
+	return ips, nil
+}
+
+// nil ready condition should be interpreted as ready, and terminating
+// endpoint is not ready
+func endpointReady(ep map[string]interface{}) bool {
+	ready, found, _ := unstructured.NestedBool(ep, "conditions", "ready")
+	if !found {
+		ready = true
+	}
+	terminating, _, _ := unstructured.NestedBool(ep, "conditions", "terminating")
+	return ready && !terminating
+}
+
+// pod is ready when Ready condition is True and not being deleted
+func podReady(pod *unstructured.Unstructured) bool {
+	if pod.GetDeletionTimestamp() != nil {
+		return false
+	}
+	conds, _, _ := unstructured.NestedSlice(pod.Object, "status", "conditions")
+	for _, v := range conds {
+		cond, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if cond["type"] == string(corev1.PodReady) {
+			return cond["status"] == string(corev1.ConditionTrue)
+		}
+	}
+	return false
+}
+
+func equalResults(a, b Results) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Ip.Equal(b[i].Ip) || a[i].PodName != b[i].PodName || a[i].Ready != b[i].Ready {
+			return false
 		}
 	}
+	return true
 }
 
 func (p *K8sMgr) IsExist(res *Resource) (bool, error) {
@@ -307,32 +1159,52 @@ func (p *K8sMgr) IsExist(res *Resource) (bool, error) {
 	return true, nil
 }
 
-func (p *K8sMgr) SecondIp(link string) []*Result {
-	var (
-		ips []*Result
-		err error
-	)
+// GetSecret return the decoded data of secret
+func (p *K8sMgr) GetSecret(namespace, name string) (map[string]string, error) {
+	gvk := schema.GroupVersionResource{
+		Version:  "v1",
+		Resource: "secrets",
+	}
+	obj, err := p.client.Resource(gvk).Namespace(namespace).Get(p.ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("get secret %s/%s failed:%v", namespace, name, err)
+	}
+	datas, _, err := unstructured.NestedStringMap(obj.Object, "data")
+	if err != nil {
+		return nil, err
+	}
+	var retmap = make(map[string]string, len(datas))
+	for k, v := range datas {
+		bs, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			return nil, fmt.Errorf("decode secret %s/%s key %s failed:%v", namespace, name, k, err)
+		}
+		retmap[k] = string(bs)
+	}
+	return retmap, nil
+}
+
+// SecondIp return kuryr ips of link from informer cache, which sorted by ip,
+// ErrNotSynced is returned until informers of link are synced
+func (p *K8sMgr) SecondIp(link string) ([]*Result, error) {
 	p.mu.RLock()
 	defer p.mu.RUnlock()
 
-	if _, ok := p.lbinfo[link]; ok {
-		err = getPodSecondIps(p.client, link, func(podname string, ip net.IP) {
-			if ip == nil {
-				return
-			}
-			ips = append(ips, &Result{Ip: ip,
-				PodName: podname})
-		})
-		if err != nil {
-			klog.Errorf("Get kuryr ip failed: %v", err)
-		}
+	val, ok := p.lbinfo[link]
+	if !ok {
+		return nil, nil
+	}
+	if val.res != nil && !p.linkSynced(val) {
+		return nil, ErrNotSynced
+	}
+	if len(val.ips) == 0 {
+		return nil, nil
 	}
-	if len(ips) < 2 {
-		return ips
+	ips := make([]*Result, len(val.ips))
+	for i, v := range val.ips {
+		ips[i] = &Result{Ip: v.Ip, PodName: v.PodName, Ready: v.Ready}
 	}
-	rsts := Results(ips)
-	sort.Sort(rsts)
-	return ips
+	return ips, nil
 }
 
 func ParseLink(link string, res *Resource) error {
@@ -353,30 +1225,42 @@ func ParseLink(link string, res *Resource) error {
 	}
 	// link example: /apis/apps/v1/namespaces/test/deployments/pause
 	// 		/api/v1/namespaces/default/pods/nginx-xs89a
-	res.svcname = fmt.Sprintf("%s-%s", res.name, util.RandStr(5))
 	return nil
 }
 
 func serviceExternalUnstract(labels map[string]string, namespace, name, lbip string, portmap []*vmv1.PortMap) *unstructured.Unstructured {
 	var (
-		ports   []map[string]interface{}
-		proto   string
-		podport int32
+		ports    []interface{}
+		proto    string
+		podport  int32
+		selector = make(map[string]interface{}, len(labels))
+		names    = make(map[string]struct{}, len(portmap))
 	)
 	for _, val := range portmap {
-		if val.Protocol != "UDP" && val.Protocol != "TCP" {
+		proto = val.Protocol
+		if proto != "UDP" && proto != "TCP" {
 			proto = "TCP"
 		}
 		podport = val.PodPort
 		if podport == 0 {
 			podport = val.Port
 		}
+		// name is required by multiple ports, http and tcp may be same port
+		name := fmt.Sprintf("%s-%d", strings.ToLower(proto), val.Port)
+		if _, ok := names[name]; ok {
+			continue
+		}
+		names[name] = struct{}{}
 		ports = append(ports, map[string]interface{}{
+			"name":       name,
 			"protocol":   proto,
-			"port":       val.Port,
-			"targetPort": podport,
+			"port":       int64(val.Port),
+			"targetPort": int64(podport),
 		})
 	}
+	for k, v := range labels {
+		selector[k] = v
+	}
 
 	return &unstructured.Unstructured{
 		Object: map[string]interface{}{
@@ -387,9 +1271,9 @@ func serviceExternalUnstract(labels map[string]string, namespace, name, lbip str
 				"namespace": namespace,
 			},
 			"spec": map[string]interface{}{
-				"selector":    labels,
+				"selector":    selector,
 				"ports":       ports,
-				"externalIPs": []string{lbip},
+				"externalIPs": []interface{}{lbip},
 			},
 		},
 	}
@@ -398,22 +1282,23 @@ func serviceExternalUnstract(labels map[string]string, namespace, name, lbip str
 
 /*
 example:
-   k8s.v1.cni.cncf.io/networks-status: |-
-     [{
-         "name": "kuryr",
-         "interface": "eth0",
-         "ips": [
-             "10.0.0.98"
-         ],
-         "mac": "fa:16:3e:e5:24:d7",
-         "dns": {
-             "nameservers": [
-                 "8.8.8.8"
-             ]
-         }
-     }]
+
+	k8s.v1.cni.cncf.io/networks-status: |-
+	  [{
+	      "name": "kuryr",
+	      "interface": "eth0",
+	      "ips": [
+	          "10.0.0.98"
+	      ],
+	      "mac": "fa:16:3e:e5:24:d7",
+	      "dns": {
+	          "nameservers": [
+	              "8.8.8.8"
+	          ]
+	      }
+	  }]
 */
-func kuryrIps(object *unstructured.Unstructured, fn func(string, net.IP)) error {
+func networkIps(object *unstructured.Unstructured, network string, fn func(string, net.IP)) error {
 	klog.V(2).Infof("type find ip on pod(%s/%s)", object.GetNamespace(), object.GetName())
 	networks, found, err := unstructured.NestedString(object.Object, "metadata", "annotations", network_status)
 	if err != nil || !found {
@@ -428,7 +1313,9 @@ func kuryrIps(object *unstructured.Unstructured, fn func(string, net.IP)) error
 		if !value.IsObject() {
 			return true
 		}
-		if value.Get("name").String() != "kuryr" {
+		// multus may record the name with namespace, such as default/kuryr
+		name := value.Get("name").String()
+		if name != network && !strings.HasSuffix(name, "/"+network) {
 			return true
 		}
 		if ips := value.Get("ips"); ips.IsArray() {
@@ -444,87 +1331,194 @@ func kuryrIps(object *unstructured.Unstructured, fn func(string, net.IP)) error
 	return nil
 }
 
-func getLinkLabels(client dynamic.Interface, link string) (map[string]string, *Resource, error) {
-	var (
-		res  = new(Resource)
-		err  error
-		ok   bool
-		ctx  = goctx.Background()
-		maps map[string]interface{}
-	)
-	err = ParseLink(link, res)
-	if err != nil {
-		return nil, nil, err
+func defaultIpSource(source *vmv1.IpSource) *vmv1.IpSource {
+	var src vmv1.IpSource
+	if source != nil {
+		src = *source
 	}
-	gvk := schema.GroupVersionResource{
-		Group:    res.group,
-		Version:  res.version,
-		Resource: res.kind,
+	if src.Type == "" {
+		src.Type = vmv1.IpSourceNetwork
 	}
+	if src.Type == vmv1.IpSourceNetwork && src.Network == "" {
+		src.Network = defaultNetwork
+	}
+	return &src
+}
 
-	wk, err := client.Resource(gvk).Namespace(res.namespace).Get(ctx, res.name, metav1.GetOptions{})
-	if err != nil {
-		return nil, nil, fmt.Errorf("get resource failed,name=%s, err=%s", res.name, err)
+// ValidIpSource check the type and required fields of source
+func ValidIpSource(source *vmv1.IpSource) error {
+	if source == nil {
+		return nil
 	}
-	if gvk.Resource == "pods" {
+	switch source.Type {
+	case "", vmv1.IpSourceNetwork, vmv1.IpSourcePodIPs, vmv1.IpSourceEndpointSlices:
+	case vmv1.IpSourceAnnotation:
+		if source.Annotation == "" {
+			return fmt.Errorf("annotation is required by ip source %s", source.Type)
+		}
+	default:
+		return fmt.Errorf("ip source should be %s, %s, %s or %s", vmv1.IpSourceNetwork, vmv1.IpSourcePodIPs,
+			vmv1.IpSourceAnnotation, vmv1.IpSourceEndpointSlices)
+	}
+	return nil
+}
+
+// find ips of pod by source, which should be defaulted
+func sourceIps(object *unstructured.Unstructured, source *vmv1.IpSource, fn func(string, net.IP)) error {
+	switch source.Type {
+	case vmv1.IpSourcePodIPs:
+		return podIPs(object, fn)
+	case vmv1.IpSourceAnnotation:
+		return annotationIps(object, source.Annotation, source.Path, fn)
+	default:
+		return networkIps(object, source.Network, fn)
+	}
+}
+
+// status.podIPs is used, and status.podIP for old cluster
+func podIPs(object *unstructured.Unstructured, fn func(string, net.IP)) error {
+	podips, _, _ := unstructured.NestedSlice(object.Object, "status", "podIPs")
+	for _, v := range podips {
+		podip, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		str, _ := podip["ip"].(string)
+		if ip := net.ParseIP(str); ip != nil {
+			fn(object.GetName(), ip)
+		}
+	}
+	if len(podips) != 0 {
+		return nil
+	}
+	str, found, _ := unstructured.NestedString(object.Object, "status", "podIP")
+	if !found {
+		return fmt.Errorf("not found ip in status of pod")
+	}
+	if ip := net.ParseIP(str); ip != nil {
+		fn(object.GetName(), ip)
+	}
+	return nil
+}
+
+// value of path can be string or array of string
+func annotationIps(object *unstructured.Unstructured, annotation, path string, fn func(string, net.IP)) error {
+	value, found, err := unstructured.NestedString(object.Object, "metadata", "annotations", annotation)
+	if err != nil || !found {
+		return fmt.Errorf("not found %s in annotations", annotation)
+	}
+	result := gjson.Parse(value)
+	if path != "" {
+		result = result.Get(path)
+	} else if !result.IsArray() {
+		// plain ip is not valid json
+		result = gjson.Result{Type: gjson.String, Str: strings.TrimSpace(value)}
+	}
+	if !result.Exists() {
+		return fmt.Errorf("not found %s in annotation %s", path, annotation)
+	}
+	for _, v := range result.Array() {
+		if ip := net.ParseIP(v.String()); ip != nil {
+			fn(object.GetName(), ip)
+		}
+	}
+	return nil
+}
+
+// labels of pods which selected by workload, the labels of pod itself
+// are used when workload is pod
+func linkLabels(wk *unstructured.Unstructured) (map[string]string, error) {
+	var (
+		maps map[string]interface{}
+		ok   bool
+		err  error
+	)
+	switch wk.GetKind() {
+	case "Pod":
 		maps, ok, err = unstructured.NestedMap(wk.Object, "metadata", "labels")
-	} else {
+	case "Service", "ReplicationController":
+		maps, ok, err = unstructured.NestedMap(wk.Object, "spec", "selector")
+	default:
 		maps, ok, err = unstructured.NestedMap(wk.Object, "spec", "selector", "matchLabels")
 	}
 	if err != nil || !ok {
 		err = fmt.Errorf("Labels not found for resource %s/%s, error=%s", wk.GetNamespace(), wk.GetName(), err)
-		return nil, nil, err
+		return nil, err
 	}
 	var retmap = make(map[string]string)
 
 	for k, v := range maps {
 		if vstr, ok := v.(string); ok {
-			if k != "pod-template-hash" {
+			// the hash is changed with template of deployment
+			if k != "pod-template-hash" || wk.GetKind() != "Pod" {
 				retmap[k] = vstr
 			}
 		}
 	}
-	return retmap, res, nil
+	return retmap, nil
 }
 
-func getPodSecondIps(client dynamic.Interface, link string, fn func(string, net.IP)) error {
-	var (
-		err  error
-		ctx  = goctx.Background()
-		errs = util.NewErr()
-	)
-
-	maps, res, err := getLinkLabels(client, link)
-	if err != nil {
-		return err
-	}
-
-	buf := util.GetBuf()
-	defer util.PutBuf(buf)
-	buf.Reset()
-	maplen := len(maps)
-	count := 0
-	for k, v := range maps {
-		count += 1
-		buf.WriteString(k)
-		buf.WriteByte('=')
-		buf.WriteString(v)
-		if count != maplen {
-			buf.WriteByte(',')
+// selector of pods which selected by workload, matchExpressions of
+// label selector is supported
+func linkSelector(wk *unstructured.Unstructured) (labels.Selector, error) {
+	switch wk.GetKind() {
+	case "Pod", "Service", "ReplicationController":
+		maps, err := linkLabels(wk)
+		if err != nil {
+			return nil, err
+		}
+		if len(maps) == 0 {
+			return nil, fmt.Errorf("empty selector of resource %s/%s", wk.GetNamespace(), wk.GetName())
 		}
+		return labels.SelectorFromSet(maps), nil
 	}
-
-	gvk := schema.GroupVersionResource{
-		Version:  "v1",
-		Resource: "pods",
+	obj, found, err := unstructured.NestedMap(wk.Object, "spec", "selector")
+	if err != nil || !found {
+		return nil, fmt.Errorf("selector not found for resource %s/%s, error=%v", wk.GetNamespace(), wk.GetName(), err)
 	}
-	results, err := client.Resource(gvk).Namespace(res.namespace).List(ctx, metav1.ListOptions{LabelSelector: buf.String()})
+	ls := new(metav1.LabelSelector)
+	err = runtime.DefaultUnstructuredConverter.FromUnstructured(obj, ls)
 	if err != nil {
-		return fmt.Errorf("list pods failed, err=%s", err)
+		return nil, err
 	}
-	for _, result := range results.Items {
-		errs.Add(kuryrIps(&result, fn))
+	// empty selector select all pods in namespace
+	if len(ls.MatchLabels) == 0 && len(ls.MatchExpressions) == 0 {
+		return nil, fmt.Errorf("empty selector of resource %s/%s", wk.GetNamespace(), wk.GetName())
 	}
+	return metav1.LabelSelectorAsSelector(ls)
+}
+
+// resources of kinds which can be used by link
+var linkKinds = map[string]string{
+	"Pod":                   "pods",
+	"Service":               "services",
+	"ReplicationController": "replicationcontrollers",
+	"Deployment":            "deployments",
+	"StatefulSet":           "statefulsets",
+	"DaemonSet":             "daemonsets",
+	"ReplicaSet":            "replicasets",
+	"Job":                   "jobs",
+}
 
-	return errs.Error()
+// RefLink convert reference to link, namespace is used when
+// namespace of reference is empty
+func RefLink(ref *vmv1.LinkRef, namespace string) (string, error) {
+	gv, err := schema.ParseGroupVersion(ref.APIVersion)
+	if err != nil {
+		return "", err
+	}
+	if ref.Kind == "" || ref.Name == "" || gv.Version == "" {
+		return "", fmt.Errorf("apiVersion, kind and name are required by link reference")
+	}
+	resource, ok := linkKinds[ref.Kind]
+	if !ok {
+		return "", fmt.Errorf("kind %s is not supported by link", ref.Kind)
+	}
+	if ref.Namespace != "" {
+		namespace = ref.Namespace
+	}
+	if gv.Group == "" {
+		return fmt.Sprintf("/api/%s/namespaces/%s/%s/%s", gv.Version, namespace, resource, ref.Name), nil
+	}
+	return fmt.Sprintf("/apis/%s/%s/namespaces/%s/%s/%s", gv.Group, gv.Version, namespace, resource, ref.Name), nil
 }
@@ -1,9 +1,22 @@
 package manage
 
 import (
+	goctx "context"
+	"fmt"
 	"net"
+	"reflect"
 	"sort"
 	"testing"
+	"time"
+
+	vmv1 "easystack.io/vm-operator/pkg/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	clienttesting "k8s.io/client-go/testing"
 )
 
 func TestSortResources(t *testing.T) {
@@ -35,3 +48,587 @@ func TestSortResources(t *testing.T) {
 	}
 
 }
+
+func TestSortResourcesIpv6(t *testing.T) {
+	rest := Results([]*Result{
+		{Ip: net.ParseIP("fd00::2"), PodName: "pod3"},
+		{Ip: net.ParseIP("10.0.0.2"), PodName: "pod1"},
+		{Ip: net.ParseIP("fd00::1"), PodName: "pod2"},
+		{Ip: net.ParseIP("10.0.0.1"), PodName: "pod0"},
+	})
+	sort.Sort(rest)
+	for i, v := range rest {
+		if v.PodName != fmt.Sprintf("pod%d", i) {
+			t.Errorf("index %d should be pod%d, but %s", i, i, v.PodName)
+		}
+	}
+}
+
+func TestSourceIps(t *testing.T) {
+	pod := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"name": "pod",
+			"annotations": map[string]interface{}{
+				network_status: `[{"name":"cluster","ips":["172.16.0.2"]},{"name":"default/neutron","ips":["10.0.0.2","fd00::2"]}]`,
+				"custom/ip":    `{"neutron":{"ips":["10.1.0.2"]}}`,
+				"custom/plain": "10.2.0.2",
+			},
+		},
+		"status": map[string]interface{}{
+			"podIP":  "172.16.0.2",
+			"podIPs": []interface{}{map[string]interface{}{"ip": "172.16.0.2"}, map[string]interface{}{"ip": "fd01::2"}},
+		},
+	}}
+	cases := []struct {
+		source *vmv1.IpSource
+		want   []string
+	}{
+		{&vmv1.IpSource{Network: "neutron"}, []string{"10.0.0.2", "fd00::2"}},
+		{&vmv1.IpSource{Type: vmv1.IpSourcePodIPs}, []string{"172.16.0.2", "fd01::2"}},
+		{&vmv1.IpSource{Type: vmv1.IpSourceAnnotation, Annotation: "custom/ip", Path: "neutron.ips"}, []string{"10.1.0.2"}},
+		{&vmv1.IpSource{Type: vmv1.IpSourceAnnotation, Annotation: "custom/plain"}, []string{"10.2.0.2"}},
+		{nil, nil},
+	}
+	for i, c := range cases {
+		var got []string
+		sourceIps(pod, defaultIpSource(c.source), func(_ string, ip net.IP) {
+			got = append(got, ip.String())
+		})
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("case %d: ips should be %v, but %v", i, c.want, got)
+		}
+	}
+}
+
+func newPod(name, ip string, labels map[string]interface{}) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Pod",
+		"metadata": map[string]interface{}{
+			"namespace": "test",
+			"name":      name,
+			"labels":    labels,
+			"annotations": map[string]interface{}{
+				network_status: fmt.Sprintf(`[{"name":"kuryr","ips":["%s"]}]`, ip),
+			},
+		},
+	}}
+}
+
+func setPodReady(pod *unstructured.Unstructured, ready string) *unstructured.Unstructured {
+	unstructured.SetNestedSlice(pod.Object, []interface{}{
+		map[string]interface{}{"type": "Ready", "status": ready},
+	}, "status", "conditions")
+	return pod
+}
+
+func TestSecondIpWatch(t *testing.T) {
+	app := map[string]interface{}{"app": "pause"}
+	deploy := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "apps/v1",
+		"kind":       "Deployment",
+		"metadata": map[string]interface{}{
+			"namespace": "test",
+			"name":      "pause",
+		},
+		"spec": map[string]interface{}{
+			"selector": map[string]interface{}{
+				"matchLabels": app,
+			},
+		},
+	}}
+	client := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), deploy,
+		newPod("pause-2", "10.0.0.2", app),
+		newPod("pause-1", "10.0.0.1", app),
+		newPod("other", "10.0.0.3", map[string]interface{}{"app": "other"}))
+	mgr := NewK8sMgr(client, nil)
+	defer mgr.Stop()
+	notified := make(chan string, 16)
+	mgr.Regist(func(owner *corev1.ObjectReference) {
+		notified <- owner.Name
+	})
+
+	link := "/apis/apps/v1/namespaces/test/deployments/pause"
+	mgr.AddLinks(link, nil, []*vmv1.PortMap{{Port: 80, Protocol: "TCP"}}, false, "", nil, &corev1.ObjectReference{Namespace: "test", Name: "vm"})
+	waitIps := func(want ...string) {
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			var got []string
+			res, err := mgr.SecondIp(link)
+			for _, v := range res {
+				got = append(got, v.Ip.String())
+			}
+			if err == ErrNotSynced {
+				got = nil
+			}
+			if reflect.DeepEqual(got, want) {
+				return
+			}
+			select {
+			case <-notified:
+			case <-time.After(time.Until(deadline)):
+				t.Fatalf("ips should be %v, but %v", want, got)
+			}
+		}
+	}
+	waitIps("10.0.0.1", "10.0.0.2")
+
+	// new pod is found without listing
+	_, err := client.Resource(podGvr).Namespace("test").Create(goctx.Background(), newPod("pause-0", "10.0.0.10", app), metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitIps("10.0.0.1", "10.0.0.2", "10.0.0.10")
+
+	err = client.Resource(podGvr).Namespace("test").Delete(goctx.Background(), "pause-2", metav1.DeleteOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitIps("10.0.0.1", "10.0.0.10")
+
+	// readiness change is notified
+	_, err = client.Resource(podGvr).Namespace("test").Update(goctx.Background(), setPodReady(newPod("pause-1", "10.0.0.1", app), "True"), metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.After(5 * time.Second)
+	for {
+		res, _ := mgr.SecondIp(link)
+		if len(res) == 2 && res[0].Ready && !res[1].Ready {
+			break
+		}
+		select {
+		case <-notified:
+		case <-deadline:
+			t.Fatalf("pod pause-1 should be ready")
+		}
+	}
+}
+
+// endpoint slice of service, endpoint is address and ready condition
+func newEndpointSlice(name, service string, endpoints ...[2]string) *unstructured.Unstructured {
+	var eps []interface{}
+	for _, ep := range endpoints {
+		conditions := map[string]interface{}{}
+		if ep[1] != "" {
+			conditions["ready"] = ep[1] == "true"
+		}
+		eps = append(eps, map[string]interface{}{
+			"addresses":  []interface{}{ep[0]},
+			"conditions": conditions,
+		})
+	}
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "discovery.k8s.io/v1",
+		"kind":       "EndpointSlice",
+		"metadata": map[string]interface{}{
+			"namespace": "test",
+			"name":      name,
+			"labels":    map[string]interface{}{EndpointSliceServiceName: service},
+		},
+		"addressType": "IPv4",
+		"endpoints":   eps,
+	}}
+}
+
+func TestSecondIpEndpointSlices(t *testing.T) {
+	// selectorless service
+	svc := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Service",
+		"metadata":   map[string]interface{}{"namespace": "test", "name": "web"},
+	}}
+	client := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), svc,
+		newEndpointSlice("web-1", "web", [2]string{"10.0.0.2", ""}, [2]string{"10.0.0.1", "false"}),
+		newEndpointSlice("other-1", "other", [2]string{"10.0.0.3", "true"}))
+	mgr := NewK8sMgr(client, nil)
+	defer mgr.Stop()
+	notified := make(chan string, 16)
+	mgr.Regist(func(owner *corev1.ObjectReference) {
+		notified <- owner.Name
+	})
+
+	link := "/api/v1/namespaces/test/services/web"
+	source := &vmv1.IpSource{Type: vmv1.IpSourceEndpointSlices}
+	mgr.AddLinks(link, nil, []*vmv1.PortMap{{Port: 80, Protocol: "TCP"}}, false, "", source, &corev1.ObjectReference{Namespace: "test", Name: "vm"})
+	waitIps := func(want ...string) {
+		deadline := time.After(5 * time.Second)
+		for {
+			var got []string
+			res, err := mgr.SecondIp(link)
+			for _, v := range res {
+				got = append(got, fmt.Sprintf("%s/%v", v.Ip, v.Ready))
+			}
+			if err == ErrNotSynced {
+				got = nil
+			}
+			if reflect.DeepEqual(got, want) {
+				return
+			}
+			select {
+			case <-notified:
+			case <-deadline:
+				t.Fatalf("ips should be %v, but %v", want, got)
+			}
+		}
+	}
+	// nil ready condition is ready
+	waitIps("10.0.0.1/false", "10.0.0.2/true")
+
+	slice := newEndpointSlice("web-2", "web", [2]string{"10.0.0.10", "true"})
+	_, err := client.Resource(endpointSliceGvr).Namespace("test").Create(goctx.Background(), slice, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitIps("10.0.0.1/false", "10.0.0.2/true", "10.0.0.10/true")
+
+	err = client.Resource(endpointSliceGvr).Namespace("test").Delete(goctx.Background(), "web-1", metav1.DeleteOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitIps("10.0.0.10/true")
+}
+
+func TestSecondIpNotSynced(t *testing.T) {
+	mgr := NewK8sMgr(dynamicfake.NewSimpleDynamicClient(runtime.NewScheme()), nil)
+	defer mgr.Stop()
+	link := "/apis/apps/v1/namespaces/test/deployments/pause"
+	res := &Resource{}
+	if err := ParseLink(link, res); err != nil {
+		t.Fatal(err)
+	}
+	// informers are not started
+	mgr.lbinfo[link] = &info{link: link, res: res, source: defaultIpSource(nil)}
+	if _, err := mgr.SecondIp(link); err != ErrNotSynced {
+		t.Errorf("link should not be synced, but %v", err)
+	}
+	mgr.watched[podGvr] = func() bool { return true }
+	mgr.watched[res.gvr()] = func() bool { return true }
+	if _, err := mgr.SecondIp(link); err != nil {
+		t.Errorf("link should be synced, but %v", err)
+	}
+}
+
+func TestLinkSelector(t *testing.T) {
+	cases := []struct {
+		wk      map[string]interface{}
+		matches map[string]string
+		valid   bool
+	}{
+		{
+			wk: map[string]interface{}{"kind": "StatefulSet", "spec": map[string]interface{}{
+				"selector": map[string]interface{}{
+					"matchLabels": map[string]interface{}{"app": "db"},
+					"matchExpressions": []interface{}{
+						map[string]interface{}{"key": "tier", "operator": "In", "values": []interface{}{"a", "b"}},
+					},
+				},
+			}},
+			matches: map[string]string{"app": "db", "tier": "b"},
+			valid:   true,
+		},
+		{
+			wk: map[string]interface{}{"kind": "Service", "spec": map[string]interface{}{
+				"selector": map[string]interface{}{"app": "web"},
+			}},
+			matches: map[string]string{"app": "web"},
+			valid:   true,
+		},
+		{
+			wk: map[string]interface{}{"kind": "Pod", "metadata": map[string]interface{}{
+				"labels": map[string]interface{}{"app": "web", "pod-template-hash": "abc"},
+			}},
+			matches: map[string]string{"app": "web", "pod-template-hash": "def"},
+			valid:   true,
+		},
+		{
+			// selectorless service
+			wk:    map[string]interface{}{"kind": "Service", "spec": map[string]interface{}{}},
+			valid: false,
+		},
+	}
+	for i, c := range cases {
+		selector, err := linkSelector(&unstructured.Unstructured{Object: c.wk})
+		if (err == nil) != c.valid {
+			t.Fatalf("case %d: valid should be %v, but %v", i, c.valid, err)
+		}
+		if err == nil && !selector.Matches(labels.Set(c.matches)) {
+			t.Errorf("case %d: selector %s should match %v", i, selector, c.matches)
+		}
+	}
+}
+
+func TestRefLink(t *testing.T) {
+	link, err := RefLink(&vmv1.LinkRef{APIVersion: "apps/v1", Kind: "StatefulSet", Name: "db"}, "test")
+	if err != nil || link != "/apis/apps/v1/namespaces/test/statefulsets/db" {
+		t.Errorf("unexpected link %s: %v", link, err)
+	}
+	link, err = RefLink(&vmv1.LinkRef{APIVersion: "v1", Kind: "Service", Namespace: "other", Name: "web"}, "test")
+	if err != nil || link != "/api/v1/namespaces/other/services/web" {
+		t.Errorf("unexpected link %s: %v", link, err)
+	}
+	res := new(Resource)
+	if err = ParseLink(link, res); err != nil || res.kind != "services" {
+		t.Errorf("link should be parsed: %v", err)
+	}
+	_, err = RefLink(&vmv1.LinkRef{APIVersion: "v1", Kind: "ConfigMap", Name: "cm"}, "test")
+	if err == nil {
+		t.Errorf("config map should not be supported")
+	}
+}
+
+func TestUpdateService(t *testing.T) {
+	app := map[string]interface{}{"app": "pause"}
+	deploy := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "apps/v1",
+		"kind":       "Deployment",
+		"metadata":   map[string]interface{}{"namespace": "test", "name": "pause"},
+		"spec": map[string]interface{}{
+			"selector": map[string]interface{}{"matchLabels": app},
+		},
+	}}
+	legacySvc := func(name, lbip string) *unstructured.Unstructured {
+		return &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Service",
+			"metadata":   map[string]interface{}{"namespace": "test", "name": name},
+			"spec": map[string]interface{}{
+				"selector":    app,
+				"externalIPs": []interface{}{lbip},
+			},
+		}}
+	}
+	// services leaked by old version, the one of other lb ip is not removed
+	client := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), deploy,
+		legacySvc("pause-x1y2z", "10.0.0.100"), legacySvc("pause-a1b2c", "10.0.0.100"), legacySvc("pause-k3m4n", "10.0.0.200"))
+	mgr := NewK8sMgr(client, nil)
+	defer mgr.Stop()
+
+	link := "/apis/apps/v1/namespaces/test/deployments/pause"
+	owner := &corev1.ObjectReference{APIVersion: vmv1.GroupVersion.String(), Kind: "VirtualMachine", Namespace: "test", Name: "vm", UID: "uid"}
+	ports := []*vmv1.PortMap{{Port: 80, Protocol: "HTTP"}, {Port: 53, Protocol: "UDP"}}
+	mgr.AddLinks(link, net.ParseIP("10.0.0.100"), ports, true, vmv1.ServiceTypeExternalIP, nil, owner)
+	mgr.factory.WaitForCacheSync(mgr.stopch)
+	val := mgr.lbinfo[link]
+
+	// the same service is updated by later sync
+	for i := 0; i < 2; i++ {
+		if err := mgr.updateService(val.lbip, val); err != nil {
+			t.Fatal(err)
+		}
+	}
+	svcs, err := client.Resource(serviceGvr).Namespace("test").List(goctx.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, v := range svcs.Items {
+		names = append(names, v.GetName())
+	}
+	sort.Strings(names)
+	if !reflect.DeepEqual(names, []string{"pause-k3m4n", "vm-pause"}) {
+		t.Fatalf("legacy services of link should be removed, but %v", names)
+	}
+	svc, err := client.Resource(serviceGvr).Namespace("test").Get(goctx.Background(), "vm-pause", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if svc.GetLabels()[ServiceOwnerName] != "vm" {
+		t.Errorf("unexpected service %s, labels %v", svc.GetName(), svc.GetLabels())
+	}
+	if refs := svc.GetOwnerReferences(); len(refs) != 1 || refs[0].UID != "uid" {
+		t.Errorf("owner reference should be set, but %v", refs)
+	}
+
+	// changed to LoadBalancer service with floating ip
+	mgr.AddLinks(link, net.ParseIP("10.0.0.100"), ports, true, vmv1.ServiceTypeLoadBalancer, nil, owner)
+	mgr.SetPublicIp(link, net.ParseIP("172.24.0.10"))
+	if err := mgr.updateService(val.lbip, val); err != nil {
+		t.Fatal(err)
+	}
+	lbsvc, err := client.Resource(serviceGvr).Namespace("test").Get(goctx.Background(), "vm-pause", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	class, _, _ := unstructured.NestedString(lbsvc.Object, "spec", "loadBalancerClass")
+	if serviceType(lbsvc) != "LoadBalancer" || class != LoadBalancerClass {
+		t.Errorf("service should be LoadBalancer of class %s, but %v", LoadBalancerClass, lbsvc.Object["spec"])
+	}
+	ingress, _, _ := unstructured.NestedSlice(lbsvc.Object, "status", "loadBalancer", "ingress")
+	want := []interface{}{
+		map[string]interface{}{"ip": "10.0.0.100"},
+		map[string]interface{}{"ip": "172.24.0.10"},
+	}
+	if !reflect.DeepEqual(ingress, want) {
+		t.Errorf("ingress should be %v, but %v", want, ingress)
+	}
+
+	val.isdelete = true
+	if err := mgr.updateService(val.lbip, val); err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.Resource(serviceGvr).Namespace("test").Get(goctx.Background(), "vm-pause", metav1.GetOptions{})
+	if err == nil {
+		t.Errorf("service should be deleted")
+	}
+}
+
+func TestCleanServices(t *testing.T) {
+	link := "/apis/apps/v1/namespaces/test/deployments/pause"
+	newSvc := func(name, owner string) *unstructured.Unstructured {
+		return &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Service",
+			"metadata": map[string]interface{}{
+				"namespace":   "test",
+				"name":        name,
+				"labels":      map[string]interface{}{ServiceOwnerName: owner, ServiceOwnerNamespace: "test"},
+				"annotations": map[string]interface{}{serviceLinkAnnotation: link},
+			},
+		}}
+	}
+	vm := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": vmv1.GroupVersion.String(),
+		"kind":       "VirtualMachine",
+		"metadata":   map[string]interface{}{"namespace": "test", "name": "vm"},
+		"spec": map[string]interface{}{
+			"loadbalance": map[string]interface{}{
+				"link_ref":    map[string]interface{}{"apiVersion": "apps/v1", "kind": "Deployment", "name": "pause"},
+				"use_service": true,
+			},
+		},
+	}}
+	client := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), vm, newSvc("vm-pause", "vm"), newSvc("gone-pause", "gone"))
+	mgr := NewK8sMgr(client, nil)
+	mgr.cleanServices()
+
+	svcs, err := client.Resource(serviceGvr).Namespace("test").List(goctx.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(svcs.Items) != 1 || svcs.Items[0].GetName() != "vm-pause" {
+		t.Errorf("only service of exist owner should be kept, but %v", svcs.Items)
+	}
+}
+
+func TestExpose(t *testing.T) {
+	client := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme())
+	mgr := NewK8sMgr(client, nil)
+	owner := &corev1.ObjectReference{APIVersion: vmv1.GroupVersion.String(), Kind: "VirtualMachine", Namespace: "test", Name: "vm", UID: "uid"}
+	ports := []*vmv1.ExposePort{{Port: 22}}
+	ctx := goctx.Background()
+
+	err := mgr.Expose(owner, "vm", ports, []string{"10.0.0.2", "fd00::2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc, err := client.Resource(serviceGvr).Namespace("test").Get(ctx, "vm", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, found, _ := unstructured.NestedMap(svc.Object, "spec", "selector"); found {
+		t.Errorf("service should be selectorless")
+	}
+	slice, err := client.Resource(endpointSliceGvr).Namespace("test").Get(ctx, "vm-ipv4", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if slice.GetLabels()["kubernetes.io/service-name"] != "vm" || slice.Object["addressType"] != "IPv4" {
+		t.Errorf("unexpected endpoint slice %v", slice.Object)
+	}
+
+	// ipv6 member is removed
+	err = mgr.Expose(owner, "vm", ports, []string{"10.0.0.2", "10.0.0.3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	slice, err = client.Resource(endpointSliceGvr).Namespace("test").Get(ctx, "vm-ipv4", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	endpoints, _, _ := unstructured.NestedSlice(slice.Object, "endpoints")
+	if len(endpoints) != 2 {
+		t.Errorf("there should be 2 endpoints, but %v", endpoints)
+	}
+	_, err = client.Resource(endpointSliceGvr).Namespace("test").Get(ctx, "vm-ipv6", metav1.GetOptions{})
+	if err == nil {
+		t.Errorf("ipv6 endpoint slice should be removed")
+	}
+
+	err = mgr.Unexpose(owner, "vm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.Resource(serviceGvr).Namespace("test").Get(ctx, "vm", metav1.GetOptions{})
+	if err == nil {
+		t.Errorf("service should be removed")
+	}
+
+	// service of user is not taken over
+	user := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Service",
+		"metadata":   map[string]interface{}{"namespace": "test", "name": "web"},
+		"spec":       map[string]interface{}{"selector": map[string]interface{}{"app": "web"}},
+	}}
+	_, err = client.Resource(serviceGvr).Namespace("test").Create(ctx, user, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = mgr.Expose(owner, "web", ports, []string{"10.0.0.2"})
+	if _, ok := err.(*NotOwnedError); !ok {
+		t.Errorf("expose should be conflict with service of user, but %v", err)
+	}
+	err = mgr.Unexpose(owner, "web")
+	if _, ok := err.(*NotOwnedError); !ok {
+		t.Errorf("unexpose should be conflict with service of user, but %v", err)
+	}
+	svc, err = client.Resource(serviceGvr).Namespace("test").Get(ctx, "web", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, found, _ := unstructured.NestedSlice(svc.Object, "spec", "ports"); found {
+		t.Errorf("service of user should not be patched, but %v", svc.Object)
+	}
+}
+
+func TestSyncLinksRetryDelete(t *testing.T) {
+	svc := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Service",
+		"metadata":   map[string]interface{}{"namespace": "other", "name": "vm-pause"},
+	}}
+	client := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), svc)
+	failed := false
+	client.PrependReactor("delete", "services", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		if !failed {
+			failed = true
+			return true, nil, fmt.Errorf("connection refused")
+		}
+		return false, nil, nil
+	})
+	mgr := NewK8sMgr(client, nil)
+	defer mgr.Stop()
+
+	link := "/apis/apps/v1/namespaces/other/deployments/pause"
+	mgr.lbinfo[link] = &info{
+		link:      link,
+		isservice: true,
+		isdelete:  true,
+		res:       &Resource{namespace: "other", name: "pause", svcname: "vm-pause"},
+	}
+	getlb := func(string) net.IP { return nil }
+
+	mgr.syncLinks(getlb)
+	if !mgr.LinkIsExist(link) {
+		t.Fatalf("link should be kept when delete service failed")
+	}
+	mgr.syncLinks(getlb)
+	if mgr.LinkIsExist(link) {
+		t.Errorf("link should be removed after service deleted")
+	}
+	_, err := client.Resource(serviceGvr).Namespace("other").Get(goctx.Background(), "vm-pause", metav1.GetOptions{})
+	if err == nil {
+		t.Errorf("service should be deleted on retry")
+	}
+}
@@ -5,12 +5,14 @@ import (
 	"sync"
 	"time"
 
+	"easystack.io/vm-operator/pkg/metrics"
 	"easystack.io/vm-operator/pkg/util"
 	klog "k8s.io/klog/v2"
 
 	"github.com/gophercloud/gophercloud"
 	"github.com/gophercloud/gophercloud/openstack"
 	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
+	octavialbs "github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/loadbalancers"
 	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/layer3/floatingips"
 	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/lbaas_v2/loadbalancers"
 	"github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
@@ -20,6 +22,14 @@ import (
 
 type Filterfn func(pagination.Page)
 
+// api of load balance
+const (
+	// octavia is used if load-balancer service is found in catalog
+	LbApiAuto    = "auto"
+	LbApiNeutron = "neutron"
+	LbApiOctavia = "octavia"
+)
+
 type OpResource int
 
 const (
@@ -88,21 +98,57 @@ func (or OpResource) ListPages(pv *gophercloud.ProviderClient) (pagination.Pager
 
 type OpenMgr struct {
 	provider *gophercloud.ProviderClient
+	// neutron or octavia
+	lbapi string
 
 	stopch chan struct{}
 	mu     sync.RWMutex
 	fns    map[OpResource]Filterfn
+	// start time of the last list, which is used by callback
+	// to ignore stale result
+	starts map[OpResource]time.Time
 }
 
-func NewOpMgr() *OpenMgr {
+func NewOpMgr(lbapi string) *OpenMgr {
 	om := &OpenMgr{
 		provider: mustProviderClient(),
 		stopch:   make(chan struct{}),
 		fns:      make(map[OpResource]Filterfn),
+		starts:   make(map[OpResource]time.Time),
+	}
+	switch lbapi {
+	case LbApiNeutron, LbApiOctavia:
+		om.lbapi = lbapi
+	case LbApiAuto:
+		om.lbapi = LbApiNeutron
+		_, err := openstack.NewLoadBalancerV2(om.provider, gophercloud.EndpointOpts{})
+		if err == nil {
+			om.lbapi = LbApiOctavia
+		}
+	default:
+		panic(fmt.Sprintf("load balance api should be %s, %s or %s, but %s", LbApiAuto, LbApiNeutron, LbApiOctavia, lbapi))
 	}
+	klog.Infof("use %s api of load balance", om.lbapi)
 	return om
 }
 
+// LbApi return neutron or octavia
+func (om *OpenMgr) LbApi() string {
+	return om.lbapi
+}
+
+// load balance is listed by octavia api if enabled
+func (om *OpenMgr) listPages(k OpResource) (pagination.Pager, error) {
+	if k == Lb && om.lbapi == LbApiOctavia {
+		cli, err := openstack.NewLoadBalancerV2(om.provider, gophercloud.EndpointOpts{})
+		if err != nil {
+			return pagination.Pager{}, err
+		}
+		return octavialbs.List(cli, octavialbs.ListOpts{}), nil
+	}
+	return k.ListPages(om.provider)
+}
+
 // should call once, fn should not too many!
 func (om *OpenMgr) Regist(k OpResource, fn Filterfn) {
 	om.mu.Lock()
@@ -119,6 +165,13 @@ func (om *OpenMgr) Regist(k OpResource, fn Filterfn) {
 	return
 }
 
+// ListStart return start time of the list, which is being called back
+func (om *OpenMgr) ListStart(k OpResource) time.Time {
+	om.mu.RLock()
+	defer om.mu.RUnlock()
+	return om.starts[k]
+}
+
 func (om *OpenMgr) Stop() {
 	close(om.stopch)
 }
@@ -155,12 +208,18 @@ func (om *OpenMgr) Loop(du time.Duration) {
 				wg.Add(1)
 				err = util.Submit(func() {
 					defer wg.Done()
-					pages, err := tmpk.ListPages(om.provider)
+					start := time.Now()
+					om.mu.Lock()
+					om.starts[tmpk] = start
+					om.mu.Unlock()
+					pages, err := om.listPages(tmpk)
 					if err != nil {
 						klog.Errorf("list %s page failed:%v", tmpk.String(), err)
+						metrics.ObserveList(tmpk.String(), start, err)
 						return
 					}
 					allpage, err := pages.AllPages()
+					metrics.ObserveList(tmpk.String(), start, err)
 					if err != nil {
 						klog.Errorf("page %s list failed:%v", tmpk.String(), err)
 						return
@@ -0,0 +1,153 @@
+package metrics
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	namespace = "vm_operator"
+
+	ResultSuccess = "success"
+	ResultFailed  = "failed"
+
+	OpCreate = "create"
+	OpUpdate = "update"
+	OpDelete = "delete"
+)
+
+var (
+	// reconcile duration of phase, such as nova, lb and fip
+	reconcileDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "reconcile_duration_seconds",
+		Help:      "Duration of reconcile per phase.",
+		Buckets:   prometheus.ExponentialBuckets(0.005, 2, 14),
+	}, []string{"phase"})
+
+	reconcileErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "reconcile_errors_total",
+		Help:      "Total number of reconcile errors per phase.",
+	}, []string{"phase"})
+
+	// list latency of openstack resource by OpenMgr
+	openstackListDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "openstack_list_duration_seconds",
+		Help:      "Duration of listing openstack resource.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 12),
+	}, []string{"resource"})
+
+	openstackListFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "openstack_list_failures_total",
+		Help:      "Total number of failed openstack resource list.",
+	}, []string{"resource"})
+
+	cacheSize = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "cache_size",
+		Help:      "Number of entries in openstack resource cache.",
+	}, []string{"cache"})
+
+	// number of virtual machines by stack kind and stack status
+	stackStatus = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "virtualmachines",
+		Help:      "Number of virtual machines by heat stack status.",
+	}, []string{"kind", "status"})
+
+	stackOperations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "stack_operations_total",
+		Help:      "Total number of heat stack create, update and delete calls.",
+	}, []string{"operation", "result"})
+
+	smu sync.Mutex
+	// key: virtual machine namespace/name
+	// value: kind - stack status
+	stacks = make(map[string]map[string]string)
+)
+
+func init() {
+	metrics.Registry.MustRegister(
+		reconcileDuration,
+		reconcileErrors,
+		openstackListDuration,
+		openstackListFailures,
+		cacheSize,
+		stackStatus,
+		stackOperations,
+	)
+}
+
+func ObserveReconcile(phase string, start time.Time, err error) {
+	reconcileDuration.WithLabelValues(phase).Observe(time.Since(start).Seconds())
+	if err != nil {
+		reconcileErrors.WithLabelValues(phase).Inc()
+	}
+}
+
+func ObserveList(resource string, start time.Time, err error) {
+	openstackListDuration.WithLabelValues(resource).Observe(time.Since(start).Seconds())
+	if err != nil {
+		openstackListFailures.WithLabelValues(resource).Inc()
+	}
+}
+
+func SetCacheSize(cache string, size int) {
+	cacheSize.WithLabelValues(cache).Set(float64(size))
+}
+
+func IncStackOperation(op string, err error) {
+	result := ResultSuccess
+	if err != nil {
+		result = ResultFailed
+	}
+	stackOperations.WithLabelValues(op, result).Inc()
+}
+
+// SetStackStatus record stack status of virtual machine,
+// the empty status means the stack is not exist
+func SetStackStatus(key, kind, status string) {
+	smu.Lock()
+	defer smu.Unlock()
+	v, ok := stacks[key]
+	if !ok {
+		if status == "" {
+			return
+		}
+		v = make(map[string]string)
+		stacks[key] = v
+	}
+	if status == "" {
+		delete(v, kind)
+	} else {
+		v[kind] = status
+	}
+	if len(v) == 0 {
+		delete(stacks, key)
+	}
+	refreshStackStatus()
+}
+
+// DeleteStackStatus remove virtual machine which had been deleted
+func DeleteStackStatus(key string) {
+	smu.Lock()
+	defer smu.Unlock()
+	delete(stacks, key)
+	refreshStackStatus()
+}
+
+func refreshStackStatus() {
+	stackStatus.Reset()
+	for _, v := range stacks {
+		for kind, status := range v {
+			stackStatus.WithLabelValues(kind, status).Inc()
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package metrics
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestObserveReconcile(t *testing.T) {
+	reconcileDuration.Reset()
+	reconcileErrors.Reset()
+	start := time.Now()
+	ObserveReconcile("vm", start, nil)
+	ObserveReconcile("vm", start, fmt.Errorf("failed"))
+	ObserveReconcile("lb", start, fmt.Errorf("failed"))
+
+	if v := testutil.ToFloat64(reconcileErrors.WithLabelValues("vm")); v != 1 {
+		t.Errorf("errors of vm should be 1, but %v", v)
+	}
+	if v := testutil.ToFloat64(reconcileErrors.WithLabelValues("lb")); v != 1 {
+		t.Errorf("errors of lb should be 1, but %v", v)
+	}
+	// duration is observed for each phase
+	if n := testutil.CollectAndCount(reconcileDuration); n != 2 {
+		t.Errorf("duration should be observed by 2 phases, but %d", n)
+	}
+}
+
+func TestIncStackOperation(t *testing.T) {
+	stackOperations.Reset()
+	IncStackOperation(OpCreate, nil)
+	IncStackOperation(OpCreate, nil)
+	IncStackOperation(OpUpdate, fmt.Errorf("failed"))
+
+	if v := testutil.ToFloat64(stackOperations.WithLabelValues(OpCreate, ResultSuccess)); v != 2 {
+		t.Errorf("succeeded create should be 2, but %v", v)
+	}
+	if v := testutil.ToFloat64(stackOperations.WithLabelValues(OpUpdate, ResultFailed)); v != 1 {
+		t.Errorf("failed update should be 1, but %v", v)
+	}
+}
+
+func TestSetStackStatus(t *testing.T) {
+	SetStackStatus("test/vm1", "vm", "CREATE_COMPLETE")
+	SetStackStatus("test/vm2", "vm", "CREATE_COMPLETE")
+	SetStackStatus("test/vm2", "lb", "UPDATE_FAILED")
+	if v := testutil.ToFloat64(stackStatus.WithLabelValues("vm", "CREATE_COMPLETE")); v != 2 {
+		t.Errorf("completed vm stacks should be 2, but %v", v)
+	}
+
+	// stack removed and virtual machine deleted
+	SetStackStatus("test/vm2", "lb", "")
+	DeleteStackStatus("test/vm1")
+	if v := testutil.ToFloat64(stackStatus.WithLabelValues("vm", "CREATE_COMPLETE")); v != 1 {
+		t.Errorf("completed vm stacks should be 1, but %v", v)
+	}
+	if n := testutil.CollectAndCount(stackStatus); n != 1 {
+		t.Errorf("only status of vm2 should be left, but %d", n)
+	}
+	DeleteStackStatus("test/vm2")
+}
@@ -1,4 +1,10 @@
 heat_template_version: 2016-10-14
+{{ $octavia := eq (.loadbalance.lb_api | default "neutron") "octavia" }}
+{{ $prefix := "OS::Neutron::LBaaS::" }}
+{{ $weights := .loadbalance.member_weights | default dict }}
+{{ if $octavia }}
+{{ $prefix = "OS::Octavia::" }}
+{{ end }}
 resources:
   ######################################################################
   #
@@ -6,7 +12,7 @@ resources:
   #
 
   lb:
-    type: 'OS::Neutron::LBaaS::LoadBalancer'
+    type: '{{ $prefix }}LoadBalancer'
     properties:
       name: {{ $.loadbalance.name }}
       vip_subnet: {{ .loadbalance.subnet.subnet_id }}
@@ -17,26 +23,70 @@ resources:
 {{ range $index, $v := $.loadbalance.port_map }}
 {{ if $v.ips }}
   {{ $.loadbalance.name }}-pool{{ $index }}:
-    type: 'OS::Neutron::LBaaS::Pool'
+    type: '{{ $prefix }}Pool'
     depends_on: {{ $.loadbalance.name }}-listen{{ $index }}
     properties:
-      lb_algorithm: ROUND_ROBIN
+      lb_algorithm: {{ $v.algorithm | default "ROUND_ROBIN" }}
+{{ if eq $v.protocol "TERMINATED_HTTPS" }}
+      protocol: HTTP
+{{ else }}
       protocol: {{ $v.protocol }}
+{{ end }}
       listener: {get_resource: {{ $.loadbalance.name }}-listen{{ $index }} }
+{{ if $v.session_persistence }}
+      session_persistence:
+        type: {{ $v.session_persistence.type }}
+{{ if $v.session_persistence.cookie_name }}
+        cookie_name: {{ $v.session_persistence.cookie_name }}
+{{ end }}
+{{ end }}
+
+{{ if $v.health_check }}
+  {{ $.loadbalance.name }}-monitor{{ $index }}:
+    type: '{{ $prefix }}HealthMonitor'
+    depends_on: {{ $.loadbalance.name }}-pool{{ $index }}
+    properties:
+      pool: {get_resource: {{ $.loadbalance.name }}-pool{{ $index }} }
+      type: {{ $v.health_check.type }}
+      delay: {{ $v.health_check.delay }}
+      timeout: {{ $v.health_check.timeout }}
+      max_retries: {{ $v.health_check.max_retries }}
+{{ if or (eq $v.health_check.type "HTTP") (eq $v.health_check.type "HTTPS") }}
+      url_path: {{ $v.health_check.url_path }}
+      expected_codes: "{{ $v.health_check.expected_codes }}"
+{{ end }}
+{{ end }}
 
   {{ $.loadbalance.name }}-listen{{ $index }}:
-    type: 'OS::Neutron::LBaaS::Listener'
+    type: '{{ $prefix }}Listener'
     depends_on: lb
     properties:
       loadbalancer: {get_resource: lb}
       protocol: {{ $v.protocol }}
       protocol_port: {{ $v.port }}
-      connection_limit: -1
+      connection_limit: {{ $v.connection_limit | default -1 }}
+{{ if eq $v.protocol "TERMINATED_HTTPS" }}
+      default_tls_container_ref: "{{ $v.tls_container_ref }}"
+{{ if $v.sni_container_refs }}
+      sni_container_refs:
+{{ range $ref := $v.sni_container_refs }}
+        - "{{ $ref }}"
+{{ end }}
+{{ end }}
+{{ end }}
+{{ if $octavia }}
+{{ if $v.timeout_client_data }}
+      timeout_client_data: {{ $v.timeout_client_data }}
+{{ end }}
+{{ if $v.timeout_member_data }}
+      timeout_member_data: {{ $v.timeout_member_data }}
+{{ end }}
+{{ end }}
 {{ range $ipindex, $ip := $v.ips }}
 
 {{ if $ip }}
   {{ $.loadbalance.name }}-member{{ $index }}-{{ $ipindex }}:
-    type: 'OS::Neutron::LBaaS::PoolMember'
+    type: '{{ $prefix }}PoolMember'
     depends_on: {{ $.loadbalance.name }}-pool{{ $index }}
     properties:
       pool:  {get_resource: {{ $.loadbalance.name }}-pool{{ $index }} }
@@ -46,7 +96,11 @@ resources:
 {{ else }}
       protocol_port: {{ $v.pod_port }}
 {{ end }}
+{{ if hasKey $weights $ip }}
+      weight: {{ index $weights $ip }}
+{{ else }}
       weight: 1
+{{ end }}
       address: {{ $ip }}
 {{ end }}
 {{ end }}
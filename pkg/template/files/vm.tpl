@@ -5,7 +5,11 @@ resources:
   # vitual machines
   #
 
-{{ range $intindex := intRange $.server.replicas }}
+{{ $nodes := $.server.nodes }}
+{{ if not $nodes }}
+{{ $nodes = intRange $.server.replicas }}
+{{ end }}
+{{ range $intindex := $nodes }}
 {{ if $.server.boot_volume_id }}
 {{ else }}
   {{ $.server.name }}-bootv{{ $intindex }}:
@@ -45,6 +49,11 @@ resources:
     properties:
       name: {{ $.server.name }}
       flavor: {{ $.server.flavor }}
+      metadata:
+        mixapp-index: "{{ $intindex }}"
+{{ if $.server.owner }}
+        mixapp.easystack.io/owner: "{{ $.server.owner }}"
+{{ end }}
 {{ if $.server.key_name }}
       key_name: {{ $.server.key_name }}
 {{ end }}
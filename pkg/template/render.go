@@ -1,13 +1,15 @@
 package template
 
 import (
-	"easystack.io/vm-operator/pkg/util"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"strings"
 	"text/template"
 	"unicode"
 
+	vmv1 "easystack.io/vm-operator/pkg/api/v1"
+	"easystack.io/vm-operator/pkg/util"
 	"github.com/Masterminds/sprig"
 	"github.com/tidwall/gjson"
 	klog "k8s.io/klog/v2"
@@ -127,6 +129,53 @@ func intRange(end interface{}) []int32 {
 	return result
 }
 
+// Params convert spec to params of template, fields generated by operator
+// are not in json of spec, which are added here
+func Params(spec *vmv1.VirtualMachineSpec) (interface{}, error) {
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return nil, err
+	}
+	params := Parse(gjson.ParseBytes(data))
+	if server := spec.Server; server != nil && server.Nodes != nil {
+		nodes := make([]interface{}, 0, len(server.Nodes))
+		for _, v := range server.Nodes {
+			nodes = append(nodes, int64(v))
+		}
+		params.(map[string]interface{})["server"].(map[string]interface{})["nodes"] = nodes
+	}
+	if server := spec.Server; server != nil && server.Owner != "" {
+		params.(map[string]interface{})["server"].(map[string]interface{})["owner"] = server.Owner
+	}
+	if lb := spec.LoadBalance; lb != nil {
+		lbparams, _ := params.(map[string]interface{})["loadbalance"].(map[string]interface{})
+		if lb.LbApi != "" {
+			lbparams["lb_api"] = lb.LbApi
+		}
+		if lb.MemberWeights != nil {
+			weights := make(map[string]interface{}, len(lb.MemberWeights))
+			for ip, w := range lb.MemberWeights {
+				weights[ip] = int64(w)
+			}
+			lbparams["member_weights"] = weights
+		}
+		ports, _ := lbparams["port_map"].([]interface{})
+		for i, pm := range lb.Ports {
+			port, ok := ports[i].(map[string]interface{})
+			if !ok || pm.TlsContainerRef == "" {
+				continue
+			}
+			port["tls_container_ref"] = pm.TlsContainerRef
+			refs := make([]interface{}, 0, len(pm.SniContainerRefs))
+			for _, ref := range pm.SniContainerRefs {
+				refs = append(refs, ref)
+			}
+			port["sni_container_refs"] = refs
+		}
+	}
+	return params, nil
+}
+
 func Parse(result gjson.Result) interface{} {
 	if result.IsArray() {
 		var rets []interface{}
@@ -213,6 +262,25 @@ func FindLbListens(jsonbs []byte, lbname string, fn func(index int, property *gj
 	util.PutBuf(buf)
 }
 
+func FindVmNodes(jsonbs []byte, fn func(index int)) {
+	result := gjson.Get(string(jsonbs), "resources")
+
+	if result.IsObject() {
+		result.ForEach(func(key, value gjson.Result) bool {
+			keys := key.String()
+			if strings.HasPrefix(keys, "node") && value.Get("type").String() == "OS::Nova::Server" {
+				index, err := lastNumber(keys)
+				if err != nil {
+					klog.Errorf("resource name %s not found last number", keys)
+					return true
+				}
+				fn(index)
+			}
+			return true
+		})
+	}
+}
+
 //get last int number
 // [!0-9][0-9]
 func lastNumber(s string) (int, error) {
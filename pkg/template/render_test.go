@@ -2,6 +2,8 @@ package template
 
 import (
 	"encoding/json"
+	"strconv"
+	"strings"
 	"testing"
 
 	vmv1 "easystack.io/vm-operator/pkg/api/v1"
@@ -127,3 +129,289 @@ func TestRenderByName(t *testing.T) {
 
 	}
 }
+
+func TestFindVmNodes(t *testing.T) {
+	var spec = vmv1.VirtualMachineSpec{
+		Server: &vmv1.ServerSpec{
+			Replicas:  2,
+			Name:      "abc",
+			BootImage: "a.iso",
+			BootVolume: &vmv1.VolumeSpec{
+				VolumeSize: 3,
+			},
+			Flavor: "1-2-4",
+			Subnet: &vmv1.SubnetSpec{
+				SubnetId: "default",
+			},
+			Nodes: []int32{0, 2},
+			Owner: "vm",
+		},
+	}
+	params, err := Params(&spec)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	bs, err := engine.RenderByName(Vm, params)
+	if err != nil {
+		t.Fatalf("render failed: %v", err)
+	}
+	jsonbs, err := yaml.YAMLToJSON(bs)
+	if err != nil {
+		t.Fatalf("YAMLToJSON failed: %v", err)
+	}
+	var nodes = make(map[int]bool)
+	FindVmNodes(jsonbs, func(index int) {
+		nodes[index] = true
+	})
+	if len(nodes) != 2 || !nodes[0] || !nodes[2] {
+		t.Errorf("nodes should be [0 2], but %v", nodes)
+	}
+	owner := gjson.GetBytes(jsonbs, `resources.node2.properties.metadata.mixapp\.easystack\.io/owner`)
+	if owner.String() != "vm" {
+		t.Errorf("owner should be in metadata of members, but %v", owner)
+	}
+}
+
+func TestRenderHealthMonitor(t *testing.T) {
+	var spec = vmv1.VirtualMachineSpec{
+		LoadBalance: &vmv1.LoadBalanceSpec{
+			Subnet: &vmv1.SubnetSpec{
+				SubnetId: "default",
+			},
+			Name: "net",
+			Ports: []*vmv1.PortMap{
+				{
+					Ips:      []string{"1.1.1.1"},
+					Port:     80,
+					Protocol: "HTTP",
+					HealthCheck: &vmv1.HealthCheck{
+						Type:          "HTTP",
+						Delay:         5,
+						Timeout:       3,
+						MaxRetries:    3,
+						UrlPath:       "/healthz",
+						ExpectedCodes: "200-204",
+					},
+				},
+				{
+					Ips:      []string{"1.1.1.1"},
+					Port:     90,
+					Protocol: "TCP",
+				},
+			},
+		},
+	}
+	bs, err := json.Marshal(&spec)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	bs, err = engine.RenderByName(Lb, Parse(gjson.ParseBytes(bs)))
+	if err != nil {
+		t.Fatalf("render failed: %v", err)
+	}
+	jsonbs, err := yaml.YAMLToJSON(bs)
+	if err != nil {
+		t.Fatalf("YAMLToJSON failed: %v", err)
+	}
+	monitor := gjson.GetBytes(jsonbs, "resources.net-monitor0")
+	if monitor.Get("type").String() != "OS::Neutron::LBaaS::HealthMonitor" {
+		t.Fatalf("monitor of pool 0 not found: %s", jsonbs)
+	}
+	props := monitor.Get("properties")
+	if props.Get("pool.get_resource").String() != "net-pool0" || props.Get("url_path").String() != "/healthz" ||
+		props.Get("expected_codes").String() != "200-204" || props.Get("max_retries").Int() != 3 {
+		t.Errorf("monitor properties is not expected: %s", props.Raw)
+	}
+	if gjson.GetBytes(jsonbs, "resources.net-monitor1").Exists() {
+		t.Errorf("monitor of pool 1 should not be rendered")
+	}
+}
+
+func TestRenderPoolOptions(t *testing.T) {
+	var spec = vmv1.VirtualMachineSpec{
+		LoadBalance: &vmv1.LoadBalanceSpec{
+			Subnet: &vmv1.SubnetSpec{
+				SubnetId: "default",
+			},
+			Name: "net",
+			Ports: []*vmv1.PortMap{
+				{
+					Ips:       []string{"1.1.1.1"},
+					Port:      80,
+					Protocol:  "HTTP",
+					Algorithm: "LEAST_CONNECTIONS",
+					SessionPersistence: &vmv1.SessionPersistence{
+						Type:       "APP_COOKIE",
+						CookieName: "session",
+					},
+					ConnectionLimit: 100,
+				},
+				{
+					Ips:      []string{"1.1.1.1"},
+					Port:     90,
+					Protocol: "TCP",
+				},
+			},
+		},
+	}
+	bs, err := json.Marshal(&spec)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	bs, err = engine.RenderByName(Lb, Parse(gjson.ParseBytes(bs)))
+	if err != nil {
+		t.Fatalf("render failed: %v", err)
+	}
+	jsonbs, err := yaml.YAMLToJSON(bs)
+	if err != nil {
+		t.Fatalf("YAMLToJSON failed: %v", err)
+	}
+	pool := gjson.GetBytes(jsonbs, "resources.net-pool0.properties")
+	if pool.Get("lb_algorithm").String() != "LEAST_CONNECTIONS" || pool.Get("session_persistence.cookie_name").String() != "session" {
+		t.Errorf("pool0 properties is not expected: %s", pool.Raw)
+	}
+	if gjson.GetBytes(jsonbs, "resources.net-listen0.properties.connection_limit").Int() != 100 {
+		t.Errorf("connection limit of listen0 should be 100")
+	}
+	pool = gjson.GetBytes(jsonbs, "resources.net-pool1.properties")
+	if pool.Get("lb_algorithm").String() != "ROUND_ROBIN" || pool.Get("session_persistence").Exists() {
+		t.Errorf("pool1 properties is not expected: %s", pool.Raw)
+	}
+	if gjson.GetBytes(jsonbs, "resources.net-listen1.properties.connection_limit").Int() != -1 {
+		t.Errorf("connection limit of listen1 should be -1")
+	}
+}
+
+func TestRenderTerminatedHttps(t *testing.T) {
+	var spec = vmv1.VirtualMachineSpec{
+		LoadBalance: &vmv1.LoadBalanceSpec{
+			Subnet: &vmv1.SubnetSpec{
+				SubnetId: "default",
+			},
+			Name: "net",
+			Ports: []*vmv1.PortMap{
+				{
+					Ips:              []string{"1.1.1.1"},
+					Port:             443,
+					PodPort:          80,
+					Protocol:         "TERMINATED_HTTPS",
+					TlsSecret:        "tls",
+					TlsContainerRef:  "http://barbican:9311/v1/containers/default",
+					SniContainerRefs: []string{"http://barbican:9311/v1/containers/sni"},
+				},
+			},
+		},
+	}
+	params, err := Params(&spec)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	bs, err := engine.RenderByName(Lb, params)
+	if err != nil {
+		t.Fatalf("render failed: %v", err)
+	}
+	jsonbs, err := yaml.YAMLToJSON(bs)
+	if err != nil {
+		t.Fatalf("YAMLToJSON failed: %v", err)
+	}
+	listen := gjson.GetBytes(jsonbs, "resources.net-listen0.properties")
+	if listen.Get("default_tls_container_ref").String() != spec.LoadBalance.Ports[0].TlsContainerRef {
+		t.Errorf("default tls container of listen0 is not expected: %s", listen.Raw)
+	}
+	if refs := listen.Get("sni_container_refs").Array(); len(refs) != 1 || refs[0].String() != spec.LoadBalance.Ports[0].SniContainerRefs[0] {
+		t.Errorf("sni containers of listen0 is not expected: %s", listen.Raw)
+	}
+	if gjson.GetBytes(jsonbs, "resources.net-pool0.properties.protocol").String() != "HTTP" {
+		t.Errorf("protocol of pool0 should be HTTP")
+	}
+}
+
+func TestRenderOctavia(t *testing.T) {
+	var spec = vmv1.VirtualMachineSpec{
+		LoadBalance: &vmv1.LoadBalanceSpec{
+			Subnet: &vmv1.SubnetSpec{
+				SubnetId: "default",
+			},
+			Name: "net",
+			Ports: []*vmv1.PortMap{
+				{
+					Ips:               []string{"1.1.1.1"},
+					Port:              80,
+					Protocol:          "TCP",
+					TimeoutClientData: 1000,
+				},
+			},
+		},
+	}
+	for _, api := range []string{"", "octavia"} {
+		spec.LoadBalance.LbApi = api
+		params, err := Params(&spec)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		bs, err := engine.RenderByName(Lb, params)
+		if err != nil {
+			t.Fatalf("render failed: %v", err)
+		}
+		jsonbs, err := yaml.YAMLToJSON(bs)
+		if err != nil {
+			t.Fatalf("YAMLToJSON failed: %v", err)
+		}
+		var (
+			prefix  = "OS::Neutron::LBaaS::"
+			timeout = gjson.GetBytes(jsonbs, "resources.net-listen0.properties.timeout_client_data")
+		)
+		if api == "octavia" {
+			prefix = "OS::Octavia::"
+			if timeout.Int() != 1000 {
+				t.Errorf("timeout of octavia listen should be rendered")
+			}
+		} else if timeout.Exists() {
+			t.Errorf("timeout of neutron listen should not be rendered")
+		}
+		for _, name := range []string{"lb", "net-listen0", "net-pool0", "net-member0-0"} {
+			ty := gjson.GetBytes(jsonbs, "resources."+name+".type").String()
+			if !strings.HasPrefix(ty, prefix) {
+				t.Errorf("type of %s should has prefix %s, but %s", name, prefix, ty)
+			}
+		}
+	}
+}
+
+func TestRenderMemberWeights(t *testing.T) {
+	var spec = vmv1.VirtualMachineSpec{
+		LoadBalance: &vmv1.LoadBalanceSpec{
+			Subnet: &vmv1.SubnetSpec{
+				SubnetId: "default",
+			},
+			Name: "net",
+			Ports: []*vmv1.PortMap{
+				{
+					Ips:      []string{"1.1.1.1", "2.2.2.2", "3.3.3.3"},
+					Port:     80,
+					Protocol: "TCP",
+				},
+			},
+			MemberWeights: map[string]int32{"1.1.1.1": 0, "2.2.2.2": 10},
+		},
+	}
+	params, err := Params(&spec)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	bs, err := engine.RenderByName(Lb, params)
+	if err != nil {
+		t.Fatalf("render failed: %v", err)
+	}
+	jsonbs, err := yaml.YAMLToJSON(bs)
+	if err != nil {
+		t.Fatalf("YAMLToJSON failed: %v", err)
+	}
+	// member without weight is 1
+	for i, want := range []int64{0, 10, 1} {
+		weight := gjson.GetBytes(jsonbs, "resources.net-member0-"+strconv.Itoa(i)+".properties.weight")
+		if !weight.Exists() || weight.Int() != want {
+			t.Errorf("weight of member %d should be %d, but %s", i, want, weight.Raw)
+		}
+	}
+}